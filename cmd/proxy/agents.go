@@ -3,14 +3,17 @@ package main
 import (
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"strings"
 	"time"
 
 	"github.com/SimonRichardson/cmdproxy/pkg/agent"
+	"github.com/SimonRichardson/cmdproxy/pkg/gossip"
 	"github.com/SimonRichardson/cmdproxy/pkg/group"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
 )
 
 const (
@@ -19,6 +22,8 @@ const (
 	defaultOutputAddresses  = true
 	defaultOutputPrefix     = "-agents"
 	defaultForwardAPIPort   = 0
+	defaultGossipEnabled    = false
+	defaultRegisterInterval = time.Second * 5
 )
 
 var (
@@ -38,7 +43,14 @@ func runAgents(args []string) error {
 
 		agentAPIAddr    = flagset.String("agents.api", defaultAgentAPIAddr, "listen address for agenet API")
 		agentBrokerSize = flagset.Int("agents.broker-size", defaultAgentBrokersSize, "amount of agent brokers required")
+
+		agentRegister         = flagset.String("agents.register", "", "address of a proxy API to register the agents with")
+		agentRegisterInterval = flagset.Duration("agents.register.interval", defaultRegisterInterval, "interval between each agent registration heartbeat")
+
+		gossipEnabled = flagset.Bool("gossip", defaultGossipEnabled, "join the agents into a gossip cluster")
+		gossipJoin    = stringSlice{}
 	)
+	flagset.Var(&gossipJoin, "gossip.join", "address of an existing gossip cluster member to join (repeatable)")
 	flagset.Usage = usageFor(flagset, "forward [flags]")
 	if err := flagset.Parse(args); err != nil {
 		return err
@@ -61,12 +73,33 @@ func runAgents(args []string) error {
 		return err
 	}
 
+	// Registrar registers the agents with a proxy, so they don't need to be
+	// piped into the proxy.
+	var registrar *agent.Registrar
+	if *agentRegister != "" {
+		if *agentRegisterInterval <= 0 {
+			return errors.New("agents register interval must be positive")
+		}
+		_, registerAddress, err := parseAddr(*agentRegister, defaultAPIPort)
+		if err != nil {
+			return err
+		}
+		registrar = agent.NewRegistrar(
+			"http",
+			registerAddress,
+			*agentRegisterInterval,
+			http.DefaultClient,
+			log.With(logger, "component", "registrar"),
+		)
+	}
+
 	var (
 		brokers = agent.NewBrokers(log.With(logger, "component", "brokers"))
 		addrs   = make([]string, *agentBrokerSize)
+		nodes   = make([]*gossip.Node, 0, *agentBrokerSize)
 	)
 	for i := 0; i < *agentBrokerSize; i++ {
-		addr, err := brokers.Add(agent.NewBroker(
+		broker := agent.NewBroker(
 			agent.NewAPI(
 				*delay,
 				logger,
@@ -74,11 +107,29 @@ func runAgents(args []string) error {
 			apiNetwork,
 			apiAddress,
 			log.With(logger, "component", "broker"),
-		))
+		)
+		if registrar != nil {
+			broker.Register(registrar)
+		}
+		addr, err := brokers.Add(broker)
 		if err != nil {
 			return err
 		}
 		addrs[i] = addr
+
+		// Each agent is a member of the gossip cluster, using the same address
+		// as the agent API.
+		if *gossipEnabled {
+			node := gossip.NewNode(
+				addr,
+				gossip.RoleAgent,
+				gossip.DefaultConfig(),
+				http.DefaultClient,
+				log.With(logger, "component", "gossip", "node", addr),
+			)
+			broker.Handle("/gossip/", node)
+			nodes = append(nodes, node)
+		}
 	}
 	level.Debug(logger).Log("addrs", strings.Join(addrs, ", "))
 
@@ -103,6 +154,12 @@ func runAgents(args []string) error {
 				output[i] += v
 			}
 
+			// When gossiping, the forwarder only needs one member of the
+			// cluster to learn every agent.
+			if *gossipEnabled && len(addrs) > 0 {
+				output = []string{fmt.Sprintf("-gossip.join=%s", addrs[0])}
+			}
+
 			if *outputAddresses {
 				fmt.Fprintln(os.Stdout, strings.Join(output, " "))
 			}
@@ -112,6 +169,28 @@ func runAgents(args []string) error {
 			brokers.Close()
 		})
 	}
+	for i, node := range nodes {
+		// The first agent seeds the cluster for the rest of the agents.
+		seeds := append([]string{}, gossipJoin...)
+		if i > 0 {
+			seeds = append(seeds, addrs[0])
+		}
+
+		node := node
+		g.Add(func() error {
+			// Other members can still join this node, even if it's unable
+			// to join any of the seeds.
+			if err := node.Join(seeds); err != nil {
+				level.Warn(logger).Log("node", node.Addr(), "err", err)
+			}
+
+			node.Run()
+			return nil
+		}, func(error) {
+			node.Stop()
+			node.Leave()
+		})
+	}
 	{
 		// Setup os signal interruptions.
 		cancel := make(chan struct{})
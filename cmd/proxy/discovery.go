@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/SimonRichardson/cmdproxy/pkg/gossip"
+	"github.com/SimonRichardson/cmdproxy/pkg/peer"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+)
+
+// newPeerFn creates the peers for any agents that are discovered.
+func newPeerFn(logger log.Logger) func(string) *peer.Peer {
+	return func(addr string) *peer.Peer {
+		return peer.NewPeer(
+			http.DefaultClient,
+			"http",
+			addr,
+			log.With(logger, "component", "peer"),
+		)
+	}
+}
+
+// reconcile the set of peers with the discovered agents, which are parsed in
+// the same way as the -agents flag, including any labels.
+func reconcile(reconciler *peer.Reconciler, addrs []string, logger log.Logger) {
+	var (
+		normalized = make([]string, 0, len(addrs))
+		labels     = make(map[string]peer.Labels, len(addrs))
+	)
+	for _, v := range addrs {
+		_, addr, l, err := parseAgent(v, defaultAgentAPIPort)
+		if err != nil {
+			level.Warn(logger).Log("agent", v, "err", err)
+			continue
+		}
+		normalized = append(normalized, addr)
+		labels[addr] = l
+	}
+
+	added, removed := reconciler.Reconcile(normalized)
+	reconciler.Relabel(labels)
+	for _, v := range added {
+		level.Info(logger).Log("agent", v, "membership", "added")
+	}
+	for _, v := range removed {
+		level.Info(logger).Log("agent", v, "membership", "removed")
+	}
+}
+
+// newGossipObserver follows the agents of the gossip cluster, keeping the set
+// of peers in line with the agents that are alive.
+func newGossipObserver(seeds []string, set *peer.Set, logger log.Logger) (*gossip.Observer, error) {
+	normalized := make([]string, len(seeds))
+	for k, v := range seeds {
+		_, addr, _, err := parseAgent(v, defaultAgentAPIPort)
+		if err != nil {
+			return nil, err
+		}
+		normalized[k] = addr
+	}
+
+	reconciler := peer.NewReconciler(set, newPeerFn(logger))
+	return gossip.NewObserver(
+		normalized,
+		gossip.DefaultConfig(),
+		http.DefaultClient,
+		func(members []gossip.Member) {
+			addrs := make([]string, 0, len(members))
+			for _, v := range members {
+				if v.Role == gossip.RoleAgent {
+					addrs = append(addrs, v.Addr)
+				}
+			}
+			reconcile(reconciler, addrs, logger)
+		},
+		log.With(logger, "component", "gossip"),
+	), nil
+}
+
+// fileDiscovery keeps the set of peers in line with the agents found in a
+// file. The file is reloaded when it changes, or when the process receives a
+// SIGHUP.
+type fileDiscovery struct {
+	path       string
+	interval   time.Duration
+	reconciler *peer.Reconciler
+	contents   []byte
+	logger     log.Logger
+	stop       chan chan struct{}
+}
+
+func newFileDiscovery(path string, interval time.Duration, set *peer.Set, logger log.Logger) *fileDiscovery {
+	return &fileDiscovery{
+		path:       path,
+		interval:   interval,
+		reconciler: peer.NewReconciler(set, newPeerFn(logger)),
+		logger:     logger,
+		stop:       make(chan chan struct{}),
+	}
+}
+
+// Run the discovery, checking the file for changes every interval.
+func (d *fileDiscovery) Run() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	step := time.NewTicker(d.interval)
+	defer step.Stop()
+
+	for {
+		select {
+		case <-step.C:
+			if err := d.load(false); err != nil {
+				level.Warn(d.logger).Log("file", d.path, "err", err)
+			}
+
+		case <-hup:
+			level.Info(d.logger).Log("file", d.path, "signal", "SIGHUP")
+			if err := d.load(true); err != nil {
+				level.Warn(d.logger).Log("file", d.path, "err", err)
+			}
+
+		case q := <-d.stop:
+			close(q)
+			return
+		}
+	}
+}
+
+// Stop the discovery.
+func (d *fileDiscovery) Stop() {
+	q := make(chan struct{})
+	d.stop <- q
+	<-q
+}
+
+// load the file, only reconciling the peers if the file has changed or if
+// it's forced to. If the file can't be loaded, the peers are left alone.
+func (d *fileDiscovery) load(force bool) error {
+	b, err := ioutil.ReadFile(d.path)
+	if err != nil {
+		return err
+	}
+	if !force && d.contents != nil && bytes.Equal(b, d.contents) {
+		return nil
+	}
+
+	addrs, err := parseAgentsFile(b)
+	if err != nil {
+		return err
+	}
+	d.contents = b
+
+	reconcile(d.reconciler, addrs, d.logger)
+	return nil
+}
+
+// parseAgentsFile reads the agent addresses from either a JSON list, or one
+// address per line. Empty lines and lines starting with # are ignored.
+func parseAgentsFile(b []byte) ([]string, error) {
+	var addrs []string
+	if trimmed := bytes.TrimSpace(b); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &addrs); err != nil {
+			return nil, errors.Wrap(err, "invalid agents file")
+		}
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(b))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			addrs = append(addrs, line)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Make sure that every address is valid, before any of them are used.
+	for _, v := range addrs {
+		if _, _, _, err := parseAgent(v, defaultAgentAPIPort); err != nil {
+			return nil, errors.Wrapf(err, "invalid agents file")
+		}
+	}
+	return addrs, nil
+}
+
+// dnsTimeout is how long each DNS lookup can take.
+const dnsTimeout = 5 * time.Second
+
+// resolver looks up DNS records, which is satisfied by a *net.Resolver.
+type resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// dnsDiscovery keeps the set of peers in line with the agents found in DNS.
+// Names starting with an underscore (i.e. _agent._tcp.example.internal) are
+// looked up as SRV records, otherwise the name is looked up as A (or AAAA)
+// records, using the port of the name or the default agent port.
+type dnsDiscovery struct {
+	name       string
+	interval   time.Duration
+	resolver   resolver
+	reconciler *peer.Reconciler
+	logger     log.Logger
+	stop       chan chan struct{}
+}
+
+func newDNSDiscovery(name string, interval time.Duration, resolver resolver, set *peer.Set, logger log.Logger) *dnsDiscovery {
+	return &dnsDiscovery{
+		name:       name,
+		interval:   interval,
+		resolver:   resolver,
+		reconciler: peer.NewReconciler(set, newPeerFn(logger)),
+		logger:     logger,
+		stop:       make(chan chan struct{}),
+	}
+}
+
+// Run the discovery, resolving the name every interval.
+func (d *dnsDiscovery) Run() {
+	step := time.NewTicker(d.interval)
+	defer step.Stop()
+
+	for {
+		select {
+		case <-step.C:
+			if err := d.load(); err != nil {
+				level.Warn(d.logger).Log("dns", d.name, "err", err)
+			}
+
+		case q := <-d.stop:
+			close(q)
+			return
+		}
+	}
+}
+
+// Stop the discovery.
+func (d *dnsDiscovery) Stop() {
+	q := make(chan struct{})
+	d.stop <- q
+	<-q
+}
+
+// load resolves the name and reconciles the peers. If the name can't be
+// resolved, the peers are left alone.
+func (d *dnsDiscovery) load() error {
+	ctx, cancel := context.WithTimeout(context.Background(), dnsTimeout)
+	defer cancel()
+
+	addrs, err := d.resolve(ctx)
+	if err != nil {
+		return err
+	}
+
+	reconcile(d.reconciler, addrs, d.logger)
+	return nil
+}
+
+func (d *dnsDiscovery) resolve(ctx context.Context) ([]string, error) {
+	var addrs []string
+	if strings.HasPrefix(d.name, "_") {
+		_, records, err := d.resolver.LookupSRV(ctx, "", "", d.name)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range records {
+			target := strings.TrimSuffix(v.Target, ".")
+			addrs = append(addrs, "tcp://"+net.JoinHostPort(target, strconv.Itoa(int(v.Port))))
+		}
+	} else {
+		host, port, err := net.SplitHostPort(d.name)
+		if err != nil {
+			host, port = d.name, strconv.Itoa(defaultAgentAPIPort)
+		}
+
+		hosts, err := d.resolver.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range hosts {
+			addrs = append(addrs, "tcp://"+net.JoinHostPort(v, port))
+		}
+	}
+
+	sort.Strings(addrs)
+	return addrs, nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SimonRichardson/cmdproxy/pkg/peer"
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+)
+
+func TestParseAgentsFile(t *testing.T) {
+	for _, testcase := range []struct {
+		contents string
+		addrs    []string
+		valid    bool
+	}{
+		{"foo:80\nbar:8080\n", []string{"foo:80", "bar:8080"}, true},
+		{"# agents\n\n  foo:80  \n", []string{"foo:80"}, true},
+		{`["foo:80", "tcp://bar:8080"]`, []string{"foo:80", "tcp://bar:8080"}, true},
+		{"foo:80,zone=a\nbar:8080\n", []string{"foo:80,zone=a", "bar:8080"}, true},
+		{"foo:80,zone\n", nil, false},
+		{"", nil, true},
+		{`["foo:80"`, nil, false},
+		{"foo:80\n%zz\n", nil, false},
+	} {
+		addrs, err := parseAgentsFile([]byte(testcase.contents))
+		if testcase.valid != (err == nil) {
+			t.Errorf("%q: unexpected error: %v", testcase.contents, err)
+			continue
+		}
+		if expected, actual := strings.Join(testcase.addrs, ","), strings.Join(addrs, ","); expected != actual {
+			t.Errorf("%q: expected: %s, actual: %s", testcase.contents, expected, actual)
+		}
+	}
+}
+
+func TestFileDiscovery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cmdproxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		path      = filepath.Join(dir, "peers.txt")
+		set       = peer.NewSet(nil)
+		discovery = newFileDiscovery(path, time.Millisecond, set, log.NewNopLogger())
+	)
+	addrs := func() string {
+		var res []string
+		for _, v := range set.Peers() {
+			res = append(res, v.Addr())
+		}
+		sort.Strings(res)
+		return strings.Join(res, ",")
+	}
+
+	if err := discovery.load(true); err == nil {
+		t.Errorf("expected: error, actual: %v", err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("foo:80,zone=a\nbar\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := discovery.load(false); err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := "bar:8080,foo:80", addrs(); expected != actual {
+		t.Errorf("expected: %s, actual: %s", expected, actual)
+	}
+	for _, v := range set.Peers() {
+		if v.Addr() != "foo:80" {
+			continue
+		}
+		if expected, actual := "a", v.Labels()["zone"]; expected != actual {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+	}
+
+	// A broken file leaves the peers alone.
+	if err := ioutil.WriteFile(path, []byte(`["foo:80"`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := discovery.load(false); err == nil {
+		t.Errorf("expected: error, actual: %v", err)
+	}
+	if expected, actual := "bar:8080,foo:80", addrs(); expected != actual {
+		t.Errorf("expected: %s, actual: %s", expected, actual)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(`["baz:80", "foo:80"]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	go discovery.Run()
+	defer discovery.Stop()
+
+	deadline := time.Now().Add(time.Second)
+	for addrs() != "baz:80,foo:80" && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if expected, actual := "baz:80,foo:80", addrs(); expected != actual {
+		t.Errorf("expected: %s, actual: %s", expected, actual)
+	}
+}
+
+type fakeResolver struct {
+	srv   map[string][]*net.SRV
+	hosts map[string][]string
+}
+
+func (r fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	records, ok := r.srv[name]
+	if !ok {
+		return "", nil, errors.Errorf("no such host %q", name)
+	}
+	return name, records, nil
+}
+
+func (r fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	hosts, ok := r.hosts[host]
+	if !ok {
+		return nil, errors.Errorf("no such host %q", host)
+	}
+	return hosts, nil
+}
+
+func TestDNSDiscovery(t *testing.T) {
+	t.Parallel()
+
+	resolver := fakeResolver{
+		srv: map[string][]*net.SRV{
+			"_agent._tcp.example.internal": {
+				{Target: "foo.example.internal.", Port: 8080},
+				{Target: "bar.example.internal.", Port: 8081},
+			},
+		},
+		hosts: map[string][]string{
+			"agents.example.internal": {"10.0.0.2", "10.0.0.1"},
+		},
+	}
+	addrs := func(set *peer.Set) string {
+		var res []string
+		for _, v := range set.Peers() {
+			res = append(res, v.Addr())
+		}
+		sort.Strings(res)
+		return strings.Join(res, ",")
+	}
+
+	for _, testcase := range []struct {
+		name  string
+		addrs string
+		valid bool
+	}{
+		{"_agent._tcp.example.internal", "bar.example.internal:8081,foo.example.internal:8080", true},
+		{"agents.example.internal", "10.0.0.1:8080,10.0.0.2:8080", true},
+		{"agents.example.internal:9090", "10.0.0.1:9090,10.0.0.2:9090", true},
+		{"_missing._tcp.example.internal", "", false},
+		{"missing.example.internal", "", false},
+	} {
+		var (
+			set       = peer.NewSet(nil)
+			discovery = newDNSDiscovery(testcase.name, time.Millisecond, resolver, set, log.NewNopLogger())
+		)
+		if err := discovery.load(); testcase.valid != (err == nil) {
+			t.Errorf("%s: unexpected error: %v", testcase.name, err)
+		}
+		if expected, actual := testcase.addrs, addrs(set); expected != actual {
+			t.Errorf("%s: expected: %s, actual: %s", testcase.name, expected, actual)
+		}
+	}
+
+	t.Run("run", func(t *testing.T) {
+		var (
+			set       = peer.NewSet(nil)
+			discovery = newDNSDiscovery("agents.example.internal", time.Millisecond, resolver, set, log.NewNopLogger())
+		)
+		go discovery.Run()
+		defer discovery.Stop()
+
+		deadline := time.Now().Add(time.Second)
+		for set.Len() != 2 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if expected, actual := "10.0.0.1:8080,10.0.0.2:8080", addrs(set); expected != actual {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+	})
+}
@@ -9,8 +9,11 @@ import (
 	"os"
 
 	"strings"
+	"time"
 
+	"github.com/SimonRichardson/cmdproxy/pkg/gossip"
 	"github.com/SimonRichardson/cmdproxy/pkg/group"
+	"github.com/SimonRichardson/cmdproxy/pkg/leader"
 	"github.com/SimonRichardson/cmdproxy/pkg/peer"
 	"github.com/SimonRichardson/cmdproxy/pkg/proxy"
 	"github.com/SimonRichardson/cmdproxy/pkg/scheduler"
@@ -27,9 +30,51 @@ func runForward(args []string) error {
 		debug   = flagset.Bool("debug", false, "debug logging")
 		apiAddr = flagset.String("api", defaultAPIAddr, "listen address for proxy API")
 
-		agents = stringSlice{}
+		storeType  = flagset.String("store.type", defaultStoreType, "task store type (memory, file, shared)")
+		storePath  = flagset.String("store.path", defaultStorePath, "path of the task log when using the file or shared store")
+		storeLease = flagset.Duration("store.lease", defaultStoreLease, "how long a proxy holds on to a task without renewing, when using the shared store")
+		storeOwner = flagset.String("store.owner", "", "name of the proxy when using the shared store (defaults to host and pid)")
+
+		schedulerWorkers = flagset.Int("scheduler.workers", defaultSchedulerWorkers, "amount of tasks that can be executed at once")
+
+		retryAttempts   = flagset.Int("retry.attempts", defaultRetry.MaxAttempts, "default amount of requests made to an agent, including retries")
+		retryBackoff    = flagset.Duration("retry.backoff", time.Duration(defaultRetry.InitialBackoff), "default backoff before the first retry")
+		retryMultiplier = flagset.Float64("retry.multiplier", defaultRetry.Multiplier, "default multiplier applied to the backoff of each retry")
+		retryMaxBackoff = flagset.Duration("retry.max-backoff", time.Duration(defaultRetry.MaxBackoff), "default longest backoff between retries (0 for no cap)")
+		retryJitter     = flagset.Float64("retry.jitter", defaultRetry.Jitter, "default fraction (0-1) of the backoff to randomly spread retries by")
+		retryCodes      = flagset.String("retry.codes", scheduler.FormatStatusCodes(defaultRetry.RetryableCodes), "default HTTP status codes that can be retried")
+
+		healthInterval           = flagset.Duration("health.interval", defaultHealth.Interval, "interval between agent health checks (0 to disable)")
+		healthTimeout            = flagset.Duration("health.timeout", defaultHealth.Timeout, "timeout of each agent health check")
+		healthHealthyThreshold   = flagset.Int("health.healthy-threshold", defaultHealth.HealthyThreshold, "consecutive successful checks before an agent is healthy")
+		healthUnhealthyThreshold = flagset.Int("health.unhealthy-threshold", defaultHealth.UnhealthyThreshold, "consecutive failed checks before an agent is unhealthy")
+		healthSkipUnhealthy      = flagset.Bool("health.skip-unhealthy", defaultHealthSkipUnhealthy, "skip unhealthy agents when running tasks")
+
+		breakerFailureRatio = flagset.Float64("breaker.failure-ratio", defaultBreaker.FailureRatio, "fraction (0-1) of the recent requests to an agent that have to fail to open its circuit (0 to disable)")
+		breakerWindow       = flagset.Int("breaker.window", defaultBreaker.Window, "amount of recent requests to an agent that the failure ratio is taken over")
+		breakerCoolDown     = flagset.Duration("breaker.cool-down", defaultBreaker.CoolDown, "how long the circuit of an agent stays open, before a trial request is let through")
+
+		agentsFile         = flagset.String("agents.file", "", "path of a file listing the agents, which is reloaded on change or SIGHUP")
+		agentsFileInterval = flagset.Duration("agents.file.interval", defaultAgentsFileInterval, "interval between checking the agents file for changes")
+
+		agentsDNS         = flagset.String("agents.dns", "", "DNS name to discover the agents from, using SRV records for names starting with _ or A records otherwise")
+		agentsDNSInterval = flagset.Duration("agents.dns.interval", defaultAgentsDNSInterval, "interval between resolving the agents DNS name")
+
+		registerEnabled = flagset.Bool("register.enabled", false, "let agents register themselves with the register and deregister routes")
+		registerTTL     = flagset.Duration("register.ttl", defaultRegisterTTL, "how long a registered agent is kept without a heartbeat")
+
+		leaderLock = flagset.String("leader.lock", "", "path of a lock file to elect the leader of the proxies with, which is the only proxy to run tasks")
+		leaderAddr = flagset.String("leader.addr", "", "address the other proxies reach the API with, when electing a leader (defaults to the host name and API port)")
+		leaderTTL  = flagset.Duration("leader.ttl", defaultLeaderTTL, "how long the leader leads without renewing the lock")
+
+		requestTimeout = flagset.Duration("request.timeout", defaultRequestTimeout, "default timeout of each request made to an agent (0 for no timeout)")
+		taskDeadline   = flagset.Duration("task.deadline", defaultTaskDeadline, "default deadline of each task, once it starts executing (0 for no deadline)")
+
+		agents     = stringSlice{}
+		gossipJoin = stringSlice{}
 	)
-	flagset.Var(&agents, "agents", "agent host host:peer (repeatable)")
+	flagset.Var(&agents, "agents", "agent host host:peer, optionally followed by labels host:peer,zone=a,role=db (repeatable)")
+	flagset.Var(&gossipJoin, "gossip.join", "address of a gossip cluster member to learn agents from (repeatable)")
 	flagset.Usage = usageFor(flagset, "forward [flags]")
 	if err := flagset.Parse(args); err != nil {
 		return err
@@ -75,6 +120,51 @@ func runForward(args []string) error {
 
 	level.Info(logger).Log("Agents", agents.String())
 
+	// Defaults for any task parameters that aren't supplied.
+	defaults := proxy.DefaultTaskDefaults()
+	{
+		codes, err := scheduler.ParseStatusCodes(*retryCodes)
+		if err != nil {
+			return err
+		}
+		defaults.Retry = scheduler.RetryPolicy{
+			MaxAttempts:    *retryAttempts,
+			InitialBackoff: scheduler.Duration(*retryBackoff),
+			Multiplier:     *retryMultiplier,
+			MaxBackoff:     scheduler.Duration(*retryMaxBackoff),
+			Jitter:         *retryJitter,
+			RetryableCodes: codes,
+		}
+		if err := defaults.Retry.Validate(); err != nil {
+			return err
+		}
+
+		if *requestTimeout < 0 || *taskDeadline < 0 {
+			return errors.New("request timeout and task deadline must not be negative")
+		}
+		defaults.RequestTimeout = *requestTimeout
+		defaults.TaskDeadline = *taskDeadline
+	}
+
+	healthPolicy := peer.HealthPolicy{
+		Interval:           *healthInterval,
+		Timeout:            *healthTimeout,
+		HealthyThreshold:   *healthHealthyThreshold,
+		UnhealthyThreshold: *healthUnhealthyThreshold,
+	}
+	if err := healthPolicy.Validate(); err != nil {
+		return err
+	}
+
+	breakerPolicy := peer.BreakerPolicy{
+		FailureRatio: *breakerFailureRatio,
+		Window:       *breakerWindow,
+		CoolDown:     *breakerCoolDown,
+	}
+	if err := breakerPolicy.Validate(); err != nil {
+		return err
+	}
+
 	// Parse URLs for listeners.
 	apiNetwork, apiAddress, err := parseAddr(*apiAddr, defaultAPIPort)
 	if err != nil {
@@ -84,7 +174,7 @@ func runForward(args []string) error {
 	// Create proxy peer agents.
 	peers := make([]*peer.Peer, agents.Len())
 	for i := 0; i < agents.Len(); i++ {
-		_, agentAddr, e := parseAddr(agents[i], defaultAgentAPIPort)
+		_, agentAddr, labels, e := parseAgent(agents[i], defaultAgentAPIPort)
 		if e != nil {
 			return e
 		}
@@ -95,13 +185,130 @@ func runForward(args []string) error {
 			agentAddr,
 			log.With(logger, "component", "peer"),
 		)
+		peers[i].SetLabels(labels)
 	}
 
+	// Task store keeps the tasks for the scheduler.
+	store, err := newTaskStore(*storeType, *storePath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := store.Close(); err != nil {
+			level.Warn(logger).Log("err", err)
+		}
+	}()
+
 	// Scheduler runs tasks on the proxy peer agents.
 	scheduler := scheduler.NewScheduler(
 		peers,
+		store,
+		*schedulerWorkers,
 		log.With(logger, "component", "scheduler"),
 	)
+	scheduler.SkipUnhealthy(*healthSkipUnhealthy)
+	scheduler.SetBreakerPolicy(breakerPolicy)
+	if *storeType == "shared" {
+		owner := *storeOwner
+		if owner == "" {
+			hostname, err := os.Hostname()
+			if err != nil {
+				return err
+			}
+			owner = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+		}
+		if err := scheduler.Share(owner, *storeLease); err != nil {
+			return err
+		}
+		level.Info(logger).Log("store", *storePath, "owner", owner, "lease", *storeLease)
+	}
+
+	// Checker marks the proxy peer agents as healthy or unhealthy.
+	checker := peer.NewChecker(
+		scheduler.Membership(),
+		healthPolicy,
+		log.With(logger, "component", "health"),
+	)
+
+	// Registry keeps the proxy peer agents that register themselves.
+	var registry *peer.Registry
+	if *registerEnabled {
+		if *registerTTL <= 0 {
+			return errors.New("register ttl must be positive")
+		}
+		registry = peer.NewRegistry(
+			peer.NewReconciler(scheduler.Membership(), newPeerFn(logger)),
+			*registerTTL,
+			log.With(logger, "component", "registry"),
+		)
+	}
+
+	// File discovery keeps the proxy peer agents in line with a file.
+	var discovery *fileDiscovery
+	if *agentsFile != "" {
+		if *agentsFileInterval <= 0 {
+			return errors.New("agents file interval must be positive")
+		}
+		discovery = newFileDiscovery(
+			*agentsFile,
+			*agentsFileInterval,
+			scheduler.Membership(),
+			log.With(logger, "component", "discovery"),
+		)
+		if err := discovery.load(true); err != nil {
+			return err
+		}
+	}
+
+	// DNS discovery keeps the proxy peer agents in line with DNS.
+	var dns *dnsDiscovery
+	if *agentsDNS != "" {
+		if *agentsDNSInterval <= 0 {
+			return errors.New("agents dns interval must be positive")
+		}
+		dns = newDNSDiscovery(
+			*agentsDNS,
+			*agentsDNSInterval,
+			net.DefaultResolver,
+			scheduler.Membership(),
+			log.With(logger, "component", "discovery"),
+		)
+		if err := dns.load(); err != nil {
+			level.Warn(logger).Log("dns", *agentsDNS, "err", err)
+		}
+	}
+
+	// Leader runs the scheduler only whilst leading the other proxies.
+	var leading *leaderScheduler
+	if *leaderLock != "" {
+		// The followers answer from the store, so it has to be shared with
+		// the leader.
+		if *storeType != "shared" {
+			return errors.New("leader lock requires the shared task store")
+		}
+		if *leaderTTL <= 0 {
+			return errors.New("leader ttl must be positive")
+		}
+		candidate, err := candidateAddr(*leaderAddr, apiAddress)
+		if err != nil {
+			return err
+		}
+		leading = newLeaderScheduler(
+			scheduler,
+			leader.NewFileLock(*leaderLock),
+			candidate,
+			*leaderTTL,
+			log.With(logger, "component", "leader"),
+		)
+	}
+
+	// Gossip observer learns the proxy peer agents from the cluster.
+	var observer *gossip.Observer
+	if gossipJoin.Len() > 0 {
+		if observer, err = newGossipObserver(gossipJoin, scheduler.Membership(), logger); err != nil {
+			return err
+		}
+	}
 
 	// Bind listeners.
 	apiListener, err := net.Listen(apiNetwork, apiAddress)
@@ -121,7 +328,15 @@ func runForward(args []string) error {
 			close(cancel)
 		})
 	}
-	{
+	if leading != nil {
+		// Set up the scheduler for tasks to be worked on, whilst leading.
+		g.Add(func() error {
+			leading.Run()
+			return nil
+		}, func(error) {
+			leading.Stop()
+		})
+	} else {
 		// Set up the scheduler for tasks to be worked on.
 		g.Add(func() error {
 			scheduler.Run()
@@ -130,13 +345,66 @@ func runForward(args []string) error {
 			scheduler.Stop()
 		})
 	}
+	{
+		// Set up the health checks for the peers.
+		g.Add(func() error {
+			checker.Run()
+			return nil
+		}, func(error) {
+			checker.Stop()
+		})
+	}
+	if registry != nil {
+		// Set up the expiry of registered peers.
+		g.Add(func() error {
+			registry.Run()
+			return nil
+		}, func(error) {
+			registry.Stop()
+		})
+	}
+	if discovery != nil {
+		// Set up the reloading of the agents file.
+		g.Add(func() error {
+			discovery.Run()
+			return nil
+		}, func(error) {
+			discovery.Stop()
+		})
+	}
+	if dns != nil {
+		// Set up the resolving of the agents DNS name.
+		g.Add(func() error {
+			dns.Run()
+			return nil
+		}, func(error) {
+			dns.Stop()
+		})
+	}
+	if observer != nil {
+		// Set up the gossip observer for the peers.
+		g.Add(func() error {
+			observer.Run()
+			return nil
+		}, func(error) {
+			observer.Stop()
+		})
+	}
 	{
 		// Set up the new server mux
 		g.Add(func() error {
+			var election *leader.Election
+			if leading != nil {
+				election = leading.election
+			}
+
 			var (
 				mux = http.NewServeMux()
 				api = proxy.NewAPI(
 					scheduler,
+					registry,
+					election,
+					defaults,
 					logger,
 				)
 			)
@@ -165,3 +433,16 @@ func runForward(args []string) error {
 
 	return g.Run()
 }
+
+func newTaskStore(storeType, path string) (scheduler.TaskStore, error) {
+	switch storeType {
+	case "memory":
+		return scheduler.NewMemoryTaskStore(), nil
+	case "file":
+		return scheduler.NewFileTaskStore(path)
+	case "shared":
+		return scheduler.NewSharedFileTaskStore(path)
+	default:
+		return nil, errors.Errorf("%s: unsupported task store type", storeType)
+	}
+}
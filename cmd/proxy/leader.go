@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/SimonRichardson/cmdproxy/pkg/leader"
+	"github.com/SimonRichardson/cmdproxy/pkg/scheduler"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// leaderScheduler runs the scheduler only whilst the proxy is leading, so
+// that only one of the proxies works on the tasks.
+type leaderScheduler struct {
+	scheduler *scheduler.Scheduler
+	election  *leader.Election
+	changed   chan struct{}
+	logger    log.Logger
+	stop      chan chan struct{}
+}
+
+func newLeaderScheduler(s *scheduler.Scheduler, lock leader.Lock, candidate string, ttl time.Duration, logger log.Logger) *leaderScheduler {
+	l := &leaderScheduler{
+		scheduler: s,
+		changed:   make(chan struct{}, 1),
+		logger:    logger,
+		stop:      make(chan chan struct{}),
+	}
+	l.election = leader.NewElection(lock, candidate, ttl, func(bool) {
+		select {
+		case l.changed <- struct{}{}:
+		default:
+		}
+	}, logger)
+	return l
+}
+
+// Run the election, starting and stopping the scheduler as the proxy starts
+// and stops leading.
+func (l *leaderScheduler) Run() {
+	go l.election.Run()
+
+	var running chan struct{}
+	for {
+		select {
+		case <-l.changed:
+			leading := l.election.Leading()
+			if leading && running == nil {
+				level.Info(l.logger).Log("scheduler", "started")
+
+				running = make(chan struct{})
+				go func(done chan struct{}) {
+					defer close(done)
+					l.scheduler.Run()
+				}(running)
+			} else if !leading && running != nil {
+				// Let the tasks that are executing finish first.
+				l.scheduler.Stop()
+				<-running
+				running = nil
+
+				level.Info(l.logger).Log("scheduler", "stopped")
+			}
+
+		case q := <-l.stop:
+			l.election.Stop()
+			if running != nil {
+				l.scheduler.Stop()
+				<-running
+			}
+			close(q)
+			return
+		}
+	}
+}
+
+// Stop the election and the scheduler.
+func (l *leaderScheduler) Stop() {
+	q := make(chan struct{})
+	l.stop <- q
+	<-q
+}
+
+// candidateAddr returns the URL that the other proxies can reach the API
+// with. If no address is given, then the API address is used, replacing an
+// unspecified host with the host name.
+func candidateAddr(addr, apiAddress string) (string, error) {
+	if addr != "" {
+		_, address, err := parseAddr(addr, defaultAPIPort)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("http://%s", address), nil
+	}
+
+	host, port, err := net.SplitHostPort(apiAddress)
+	if err != nil {
+		return "", err
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		if host, err = os.Hostname(); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("http://%s", net.JoinHostPort(host, port)), nil
+}
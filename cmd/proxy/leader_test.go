@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SimonRichardson/cmdproxy/pkg/leader"
+	"github.com/SimonRichardson/cmdproxy/pkg/peer"
+	"github.com/SimonRichardson/cmdproxy/pkg/scheduler"
+	"github.com/go-kit/kit/log"
+)
+
+func TestCandidateAddr(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, testcase := range []struct {
+		addr, apiAddress string
+		candidate        string
+	}{
+		{"", "127.0.0.1:7650", "http://127.0.0.1:7650"},
+		{"", "0.0.0.0:7650", "http://" + hostname + ":7650"},
+		{"", ":7650", "http://" + hostname + ":7650"},
+		{"foo", "0.0.0.0:7650", "http://foo:7650"},
+		{"tcp://foo:80", "0.0.0.0:7650", "http://foo:80"},
+	} {
+		candidate, err := candidateAddr(testcase.addr, testcase.apiAddress)
+		if err != nil {
+			t.Errorf("(%q, %q): %v", testcase.addr, testcase.apiAddress, err)
+			continue
+		}
+		if expected, actual := testcase.candidate, candidate; expected != actual {
+			t.Errorf("(%q, %q): expected: %s, actual: %s", testcase.addr, testcase.apiAddress, expected, actual)
+		}
+	}
+}
+
+func TestLeaderScheduler(t *testing.T) {
+	var (
+		logger = log.NewNopLogger()
+		lock   = leader.NewMemoryLock()
+		ttl    = 30 * time.Millisecond
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		addr = strings.Replace(server.URL, "http://", "", 1)
+	)
+	defer server.Close()
+
+	// newProxy creates a leaderScheduler with a task waiting to be executed.
+	newProxy := func(candidate string) (*leaderScheduler, *scheduler.Task) {
+		var (
+			p    = peer.NewPeer(http.DefaultClient, "http", addr, logger)
+			s    = scheduler.NewScheduler([]*peer.Peer{p}, scheduler.NewMemoryTaskStore(), 1, logger)
+			task = scheduler.NewTask(scheduler.ModeTypeSequential, 0, "info", false)
+		)
+		if err := s.Register(task); err != nil {
+			t.Fatal(err)
+		}
+		return newLeaderScheduler(s, lock, candidate, ttl, logger), task
+	}
+	completed := func(task *scheduler.Task) bool {
+		select {
+		case <-task.Done():
+			return true
+		case <-time.After(time.Second):
+			return false
+		}
+	}
+
+	a, aTask := newProxy("a")
+	go a.Run()
+	if !completed(aTask) {
+		t.Fatalf("expected: %s, actual: %s", scheduler.TaskStatusTypeCompleted, aTask.Status())
+	}
+
+	b, bTask := newProxy("b")
+	go b.Run()
+	defer b.Stop()
+
+	time.Sleep(2 * ttl)
+	if expected, actual := scheduler.TaskStatusTypePending, bTask.Status(); expected != actual {
+		t.Errorf("expected: %s, actual: %s", expected, actual)
+	}
+
+	// Once the leader stops, the other proxy starts to run the tasks.
+	a.Stop()
+	if !completed(bTask) {
+		t.Errorf("expected: %s, actual: %s", scheduler.TaskStatusTypeCompleted, bTask.Status())
+	}
+}
@@ -7,6 +7,10 @@ import (
 	"runtime"
 	"strings"
 	"text/tabwriter"
+	"time"
+
+	"github.com/SimonRichardson/cmdproxy/pkg/peer"
+	"github.com/SimonRichardson/cmdproxy/pkg/scheduler"
 )
 
 var version = "dev"
@@ -53,10 +57,30 @@ func (c command) Run(args []string) {
 const (
 	defaultAPIPort      = 7650
 	defaultAgentAPIPort = 8080
+	defaultStoreType    = "memory"
+	defaultStorePath    = "cmdproxy.tasks"
+	defaultStoreLease   = 10 * time.Second
+
+	defaultSchedulerWorkers = 4
+
+	defaultHealthSkipUnhealthy = true
+
+	defaultRegisterTTL = 15 * time.Second
+
+	defaultRequestTimeout = 30 * time.Second
+	defaultTaskDeadline   = 5 * time.Minute
+
+	defaultLeaderTTL = 10 * time.Second
+
+	defaultAgentsFileInterval = time.Second
+	defaultAgentsDNSInterval  = 30 * time.Second
 )
 
 var (
 	defaultAPIAddr = fmt.Sprintf("tcp://0.0.0.0:%d", defaultAPIPort)
+	defaultRetry   = scheduler.DefaultRetryPolicy()
+	defaultHealth  = peer.DefaultHealthPolicy()
+	defaultBreaker = peer.DefaultBreakerPolicy()
 )
 
 func main() {
@@ -10,6 +10,7 @@ import (
 	"strings"
 	"syscall"
 
+	"github.com/SimonRichardson/cmdproxy/pkg/peer"
 	"github.com/pkg/errors"
 )
 
@@ -78,3 +79,20 @@ func parseAddr(addr string, defaultPort int) (network, address string, err error
 
 	return u.Scheme, u.Host, nil
 }
+
+// parseAgent splits an agent into the address and the labels that optionally
+// follow it (e.g. host:8080,zone=a,role=db).
+func parseAgent(agent string, defaultPort int) (network, address string, labels peer.Labels, err error) {
+	parts := strings.SplitN(agent, ",", 2)
+	if network, address, err = parseAddr(parts[0], defaultPort); err != nil {
+		return network, address, labels, err
+	}
+
+	labels = peer.Labels{}
+	if len(parts) == 2 {
+		if labels, err = peer.ParseLabels(parts[1]); err != nil {
+			return network, address, labels, errors.Wrapf(err, "%s: invalid labels", agent)
+		}
+	}
+	return network, address, labels, nil
+}
@@ -60,3 +60,37 @@ func TestParseAddr(t *testing.T) {
 		}
 	}
 }
+
+func TestParseAgent(t *testing.T) {
+	for _, testcase := range []struct {
+		agent   string
+		address string
+		labels  string
+	}{
+		{"foo", "foo:123", ""},
+		{"foo:80,zone=a", "foo:80", "zone=a"},
+		{"tcp://foo:80,zone=a,role=db", "foo:80", "role=db,zone=a"},
+	} {
+		_, address, labels, err := parseAgent(testcase.agent, 123)
+		if err != nil {
+			t.Errorf("(%q): %v", testcase.agent, err)
+			continue
+		}
+		if address != testcase.address || labels.String() != testcase.labels {
+			t.Errorf("(%q): want [%s %s], have [%s %s]",
+				testcase.agent,
+				testcase.address, testcase.labels,
+				address, labels,
+			)
+		}
+	}
+
+	for _, agent := range []string{
+		"foo:80,zone",
+		"foo:80,=a",
+	} {
+		if _, _, _, err := parseAgent(agent, 123); err == nil {
+			t.Errorf("(%q): expected: error, actual: %v", agent, err)
+		}
+	}
+}
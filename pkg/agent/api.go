@@ -1,6 +1,7 @@
 package agent
 
 import (
+	"fmt"
 	"net/http"
 	"time"
 
@@ -11,6 +12,7 @@ import (
 // These are the agent API URL paths.
 const (
 	APIPathUpdateQuery = "/update"
+	APIPathHealthQuery = "/health"
 )
 
 // API serves the agent API
@@ -39,6 +41,8 @@ func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	switch {
 	case method == "GET" && path == APIPathUpdateQuery:
 		a.handleUpdateQuery(w, r)
+	case method == "GET" && path == APIPathHealthQuery:
+		a.handleHealthQuery(w, r)
 	default:
 		http.NotFound(w, r)
 	}
@@ -70,6 +74,12 @@ func (a *API) handleUpdateQuery(w http.ResponseWriter, r *http.Request) {
 	qr.EncodeTo(w)
 }
 
+func (a *API) handleHealthQuery(w http.ResponseWriter, r *http.Request) {
+	// The agent is healthy as long as it can respond.
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "OK")
+}
+
 type interceptingWriter struct {
 	code int
 	http.ResponseWriter
@@ -35,6 +35,17 @@ func TestAPI(t *testing.T) {
 		}
 	})
 
+	t.Run("health", func(t *testing.T) {
+		resp, err := http.Get(fmt.Sprintf("%s/health", url))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("expected: %v, actual: %v", http.StatusOK, resp.StatusCode)
+		}
+	})
+
 	t.Run("not found", func(t *testing.T) {
 		resp, err := http.Get(fmt.Sprintf("%s/bad", url))
 		if err != nil {
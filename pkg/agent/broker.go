@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"time"
 
 	"github.com/SimonRichardson/cmdproxy/pkg/group"
 	"github.com/go-kit/kit/log"
@@ -15,6 +16,10 @@ type Broker struct {
 	api                    *API
 	apiNetwork, apiAddress string
 	apiListener            net.Listener
+	handlers               map[string]http.Handler
+	registrar              *Registrar
+	stop                   chan struct{}
+	stopped                chan struct{}
 	logger                 log.Logger
 }
 
@@ -24,10 +29,26 @@ func NewBroker(api *API, apiNetwork, apiAddress string, logger log.Logger) *Brok
 		api:        api,
 		apiNetwork: apiNetwork,
 		apiAddress: apiAddress,
+		handlers:   make(map[string]http.Handler),
+		stop:       make(chan struct{}),
+		stopped:    make(chan struct{}),
 		logger:     logger,
 	}
 }
 
+// Handle adds an extra handler for the pattern, alongside the API. It must be
+// called before the Broker is served.
+func (b *Broker) Handle(pattern string, handler http.Handler) {
+	b.handlers[pattern] = handler
+}
+
+// Register the Broker with a proxy, once it's bound. The Broker keeps
+// registering every interval of the Registrar, until it's closed. It must be
+// called before the Broker is bound.
+func (b *Broker) Register(registrar *Registrar) {
+	b.registrar = registrar
+}
+
 // Bind the API address for the broker
 func (b *Broker) Bind() (addr string, err error) {
 	// Bind listeners
@@ -38,6 +59,14 @@ func (b *Broker) Bind() (addr string, err error) {
 
 	address := b.apiListener.Addr().String()
 	level.Debug(b.logger).Log("broker-addr", address)
+
+	// Any requests sent by the proxy wait on the listener, until the Broker
+	// is served.
+	if b.registrar != nil {
+		go b.heartbeat()
+	} else {
+		close(b.stopped)
+	}
 	return address, nil
 }
 
@@ -45,6 +74,9 @@ func (b *Broker) Bind() (addr string, err error) {
 func (b *Broker) Serve() error {
 	mux := http.NewServeMux()
 	mux.Handle("/", b.api)
+	for pattern, handler := range b.handlers {
+		mux.Handle(pattern, handler)
+	}
 
 	// Help with debugging
 	level.Debug(b.logger).Log("serving", fmt.Sprintf("%s%s", b.apiListener.Addr().String(), "/"))
@@ -52,8 +84,43 @@ func (b *Broker) Serve() error {
 	return http.Serve(b.apiListener, mux)
 }
 
+// heartbeat registers the Broker every interval, until the Broker is closed.
+func (b *Broker) heartbeat() {
+	defer close(b.stopped)
+
+	addr := b.apiListener.Addr().String()
+	step := time.NewTicker(b.registrar.Interval())
+	defer step.Stop()
+
+	for {
+		if err := b.registrar.Register(addr); err != nil {
+			level.Warn(b.registrar.logger).Log("err", err)
+		}
+
+		select {
+		case <-step.C:
+		case <-b.stop:
+			return
+		}
+	}
+}
+
 // Close the API on the Broker
 func (b *Broker) Close() error {
+	// Nothing was bound, so there's no heartbeat to stop or listener to close.
+	if b.apiListener == nil {
+		return b.api.Close()
+	}
+
+	if b.registrar != nil {
+		close(b.stop)
+		<-b.stopped
+
+		if err := b.registrar.Deregister(b.apiListener.Addr().String()); err != nil {
+			level.Warn(b.registrar.logger).Log("err", err)
+		}
+	}
+
 	if err := b.api.Close(); err != nil {
 		level.Warn(b.logger).Log("component", "API", "err", err)
 	}
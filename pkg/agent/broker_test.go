@@ -89,4 +89,36 @@ func TestBrokers(t *testing.T) {
 			}
 		}
 	})
+	t.Run("handle", func(t *testing.T) {
+		var (
+			api    = NewAPI(0, logger)
+			broker = NewBroker(api, "tcp", "127.0.0.1:0", logger)
+		)
+		broker.Handle("/extra", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+		}))
+
+		addr, err := broker.Bind()
+		if err != nil {
+			t.Fatal(err)
+		}
+		go broker.Serve()
+		defer broker.Close()
+
+		res, err := http.Get(fmt.Sprintf("http://%s/extra", addr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := http.StatusAccepted, res.StatusCode; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+
+		res, err = http.Get(fmt.Sprintf("http://%s/update?info=hello", addr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := http.StatusOK, res.StatusCode; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
 }
@@ -0,0 +1,78 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+)
+
+// These are the proxy registration URL paths.
+const (
+	RegistrarPathRegister   = "/register"
+	RegistrarPathDeregister = "/deregister"
+)
+
+// Registrar registers agents with a proxy, so that the proxy can send work to
+// the agents without being told about them up front.
+type Registrar struct {
+	network, addr string
+	interval      time.Duration
+	client        *http.Client
+	logger        log.Logger
+}
+
+// NewRegistrar creates a Registrar for the proxy found at the address. Agents
+// register again every interval, to let the proxy know they're still alive, so
+// each request to the proxy times out after the interval.
+func NewRegistrar(network, addr string, interval time.Duration, client *http.Client, logger log.Logger) *Registrar {
+	return &Registrar{
+		network:  network,
+		addr:     addr,
+		interval: interval,
+		client:   client,
+		logger:   logger,
+	}
+}
+
+// Interval returns how often an agent should register again.
+func (r *Registrar) Interval() time.Duration {
+	return r.interval
+}
+
+// Register the agent address with the proxy.
+func (r *Registrar) Register(addr string) error {
+	return r.send(RegistrarPathRegister, addr)
+}
+
+// Deregister the agent address from the proxy.
+func (r *Registrar) Deregister(addr string) error {
+	return r.send(RegistrarPathDeregister, addr)
+}
+
+func (r *Registrar) send(path, addr string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), r.interval)
+	defer cancel()
+
+	u := fmt.Sprintf("%s://%s%s?addr=%s", r.network, r.addr, path, url.QueryEscape(addr))
+	req, err := http.NewRequest("POST", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+
+	resp, err := r.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
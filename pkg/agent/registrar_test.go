@@ -0,0 +1,131 @@
+package agent
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestRegistrar(t *testing.T) {
+	t.Parallel()
+
+	var (
+		logger = log.NewNopLogger()
+
+		mutex    sync.Mutex
+		requests []string
+		server   = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			requests = append(requests, r.Method+" "+r.URL.Path)
+			mutex.Unlock()
+
+			if r.URL.Query().Get("addr") == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		addr      = strings.Replace(server.URL, "http://", "", 1)
+		registrar = NewRegistrar("http", addr, 10*time.Millisecond, http.DefaultClient, logger)
+	)
+	defer server.Close()
+
+	count := func(request string) int {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		var n int
+		for _, v := range requests {
+			if v == request {
+				n++
+			}
+		}
+		return n
+	}
+
+	t.Run("register", func(t *testing.T) {
+		if err := registrar.Register("127.0.0.1:1"); err != nil {
+			t.Error(err)
+		}
+		if err := registrar.Deregister("127.0.0.1:1"); err != nil {
+			t.Error(err)
+		}
+		if err := registrar.Register(""); err == nil {
+			t.Errorf("expected: error, actual: %v", err)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		var (
+			slow = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-time.After(time.Second):
+				case <-r.Context().Done():
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			registrar = NewRegistrar("http", strings.Replace(slow.URL, "http://", "", 1), 10*time.Millisecond, http.DefaultClient, logger)
+		)
+		defer slow.Close()
+
+		begin := time.Now()
+		if err := registrar.Register("127.0.0.1:1"); err == nil {
+			t.Errorf("expected: error, actual: %v", err)
+		}
+		if elapsed := time.Since(begin); elapsed > 500*time.Millisecond {
+			t.Errorf("expected: timeout, actual: %v", elapsed)
+		}
+	})
+
+	t.Run("broker", func(t *testing.T) {
+		broker := NewBroker(NewAPI(0, logger), "tcp", "127.0.0.1:0", logger)
+		broker.Register(registrar)
+
+		before := count("POST /register")
+		if _, err := broker.Bind(); err != nil {
+			t.Fatal(err)
+		}
+		go broker.Serve()
+
+		// Wait for a few heartbeats.
+		deadline := time.Now().Add(time.Second)
+		for count("POST /register") < before+3 && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		if count("POST /register") < before+3 {
+			t.Errorf("expected: heartbeats, actual: %d", count("POST /register")-before)
+		}
+
+		deregistered := count("POST /deregister")
+		broker.Close()
+		if expected, actual := deregistered+1, count("POST /deregister"); expected != actual {
+			t.Errorf("expected: %d, actual: %d", expected, actual)
+		}
+	})
+
+	t.Run("close before bind", func(t *testing.T) {
+		broker := NewBroker(NewAPI(0, logger), "tcp", "127.0.0.1:0", logger)
+		broker.Register(registrar)
+
+		deregistered := count("POST /deregister")
+		closed := make(chan error)
+		go func() { closed <- broker.Close() }()
+
+		select {
+		case err := <-closed:
+			if err != nil {
+				t.Error(err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected: closed, actual: blocked")
+		}
+		if expected, actual := deregistered, count("POST /deregister"); expected != actual {
+			t.Errorf("expected: %d, actual: %d", expected, actual)
+		}
+	})
+}
@@ -0,0 +1,121 @@
+package gossip
+
+import (
+	"sort"
+	"time"
+)
+
+// Status defines what a member of the cluster is believed to be doing.
+type Status string
+
+const (
+	// StatusAlive is used for members that are responding to probes.
+	StatusAlive Status = "alive"
+
+	// StatusSuspect is used for members that failed a probe, but haven't yet
+	// been declared as dead.
+	StatusSuspect Status = "suspect"
+
+	// StatusDead is used for members that failed to refute being a suspect in
+	// time, or that have left the cluster.
+	StatusDead Status = "dead"
+)
+
+// precedence defines which Status wins when two members have the same
+// incarnation.
+func (s Status) precedence() int {
+	switch s {
+	case StatusSuspect:
+		return 1
+	case StatusDead:
+		return 2
+	default:
+		return 0
+	}
+}
+
+// RoleAgent is the role of members that serve the agent API.
+const RoleAgent = "agent"
+
+// Member is a single member of the cluster, identified by its address.
+type Member struct {
+	Addr        string `json:"addr"`
+	Role        string `json:"role"`
+	Status      Status `json:"status"`
+	Incarnation uint64 `json:"incarnation"`
+
+	// updated is when the status last changed locally, which isn't shared as
+	// the clocks of each member can't be compared.
+	updated time.Time
+}
+
+// members is the local view of the cluster.
+// The members aren't safe for concurrent use, so the owner must guard them.
+type members map[string]*Member
+
+// merge a member from another view of the cluster, returning true if the local
+// view changed. The member with the highest incarnation always wins, otherwise
+// the status with the highest precedence wins.
+func (m members) merge(other Member, now time.Time) bool {
+	current, ok := m[other.Addr]
+	if !ok {
+		// There's no point in learning about members that are already dead.
+		if other.Status == StatusDead {
+			return false
+		}
+		other.updated = now
+		m[other.Addr] = &other
+		return true
+	}
+
+	if other.Incarnation < current.Incarnation {
+		return false
+	}
+	if other.Incarnation == current.Incarnation && other.Status.precedence() <= current.Status.precedence() {
+		return false
+	}
+
+	if other.Status != current.Status {
+		current.updated = now
+	}
+	current.Role = other.Role
+	current.Status = other.Status
+	current.Incarnation = other.Incarnation
+	return true
+}
+
+// expire moves any members that have been suspected for longer than the
+// suspicion timeout to dead, returning the members that changed.
+func (m members) expire(suspicion time.Duration, now time.Time) []Member {
+	var changed []Member
+	for _, v := range m {
+		if v.Status == StatusSuspect && now.Sub(v.updated) >= suspicion {
+			v.Status = StatusDead
+			v.updated = now
+			changed = append(changed, *v)
+		}
+	}
+	return changed
+}
+
+// reap forgets any members that have been dead for longer than the dead
+// timeout.
+func (m members) reap(dead time.Duration, now time.Time) {
+	for addr, v := range m {
+		if v.Status == StatusDead && now.Sub(v.updated) >= dead {
+			delete(m, addr)
+		}
+	}
+}
+
+// list returns a copy of the members, ordered by address.
+func (m members) list() []Member {
+	res := make([]Member, 0, len(m))
+	for _, v := range m {
+		res = append(res, *v)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Addr < res[j].Addr
+	})
+	return res
+}
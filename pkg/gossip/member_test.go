@@ -0,0 +1,75 @@
+package gossip
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMembers(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	t.Run("merge unknown", func(t *testing.T) {
+		m := members{}
+		if !m.merge(Member{Addr: "a", Status: StatusAlive}, now) {
+			t.Errorf("expected: true, actual: false")
+		}
+		if m.merge(Member{Addr: "b", Status: StatusDead}, now) {
+			t.Errorf("expected: false, actual: true")
+		}
+		if expected, actual := 1, len(m); expected != actual {
+			t.Errorf("expected: %d, actual: %d", expected, actual)
+		}
+	})
+
+	t.Run("merge precedence", func(t *testing.T) {
+		for _, testcase := range []struct {
+			current, other Member
+			merged         bool
+			status         Status
+		}{
+			{Member{Status: StatusAlive, Incarnation: 1}, Member{Status: StatusSuspect, Incarnation: 1}, true, StatusSuspect},
+			{Member{Status: StatusSuspect, Incarnation: 1}, Member{Status: StatusAlive, Incarnation: 1}, false, StatusSuspect},
+			{Member{Status: StatusSuspect, Incarnation: 1}, Member{Status: StatusAlive, Incarnation: 2}, true, StatusAlive},
+			{Member{Status: StatusSuspect, Incarnation: 1}, Member{Status: StatusDead, Incarnation: 1}, true, StatusDead},
+			{Member{Status: StatusDead, Incarnation: 2}, Member{Status: StatusAlive, Incarnation: 1}, false, StatusDead},
+			{Member{Status: StatusAlive, Incarnation: 1}, Member{Status: StatusAlive, Incarnation: 1}, false, StatusAlive},
+		} {
+			testcase.current.Addr, testcase.other.Addr = "a", "a"
+
+			current := testcase.current
+			m := members{"a": &current}
+			if expected, actual := testcase.merged, m.merge(testcase.other, now); expected != actual {
+				t.Errorf("%v <- %v: expected: %v, actual: %v", testcase.current, testcase.other, expected, actual)
+			}
+			if expected, actual := testcase.status, m["a"].Status; expected != actual {
+				t.Errorf("%v <- %v: expected: %v, actual: %v", testcase.current, testcase.other, expected, actual)
+			}
+		}
+	})
+
+	t.Run("expire and reap", func(t *testing.T) {
+		m := members{
+			"a": &Member{Addr: "a", Status: StatusSuspect, updated: now.Add(-time.Minute)},
+			"b": &Member{Addr: "b", Status: StatusSuspect, updated: now},
+			"c": &Member{Addr: "c", Status: StatusAlive, updated: now.Add(-time.Minute)},
+		}
+
+		changed := m.expire(time.Second, now)
+		if expected, actual := 1, len(changed); expected != actual {
+			t.Fatalf("expected: %d, actual: %d", expected, actual)
+		}
+		if expected, actual := "a", changed[0].Addr; expected != actual {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+
+		m.reap(time.Second, now.Add(2*time.Second))
+		if _, ok := m["a"]; ok {
+			t.Errorf("expected: reaped, actual: %v", m["a"])
+		}
+		if expected, actual := 2, len(m); expected != actual {
+			t.Errorf("expected: %d, actual: %d", expected, actual)
+		}
+	})
+}
@@ -0,0 +1,395 @@
+package gossip
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+)
+
+// These are the gossip URL paths.
+const (
+	PathPing    = "/gossip/ping"
+	PathPingReq = "/gossip/ping-req"
+	PathMembers = "/gossip/members"
+)
+
+// Config defines how the members of the cluster probe each other.
+type Config struct {
+	// ProbeInterval is how often a random member is probed.
+	ProbeInterval time.Duration
+	ProbeTimeout  time.Duration
+
+	// IndirectChecks is how many other members are asked to probe a member
+	// that failed a direct probe, before it's suspected.
+	IndirectChecks int
+
+	// SuspicionTimeout is how long a member can be suspected before it's
+	// declared as dead.
+	SuspicionTimeout time.Duration
+
+	// DeadTimeout is how long a dead member is remembered for, so that the
+	// death spreads through the cluster.
+	DeadTimeout time.Duration
+}
+
+// DefaultConfig returns a Config suitable for a local network.
+func DefaultConfig() Config {
+	return Config{
+		ProbeInterval:    time.Second,
+		ProbeTimeout:     500 * time.Millisecond,
+		IndirectChecks:   2,
+		SuspicionTimeout: 5 * time.Second,
+		DeadTimeout:      30 * time.Second,
+	}
+}
+
+// Validate the Config, to make sure that it's usable.
+func (c Config) Validate() error {
+	switch {
+	case c.ProbeInterval <= 0:
+		return errors.New("gossip probe interval must be positive")
+	case c.ProbeTimeout <= 0:
+		return errors.New("gossip probe timeout must be positive")
+	case c.IndirectChecks < 0:
+		return errors.New("gossip indirect checks must not be negative")
+	case c.SuspicionTimeout <= 0:
+		return errors.New("gossip suspicion timeout must be positive")
+	case c.DeadTimeout <= 0:
+		return errors.New("gossip dead timeout must be positive")
+	}
+	return nil
+}
+
+// message is exchanged on every ping, so that each side learns the view of
+// the cluster from the other.
+type message struct {
+	Members []Member `json:"members"`
+}
+
+// pingReq asks a member to probe the target on behalf of another member.
+type pingReq struct {
+	Target string `json:"target"`
+}
+
+// Node is a member of the cluster, which periodically probes the other members
+// to detect failures.
+// The Node serves the gossip URL paths, so it needs to be reachable on the
+// address that it's created with.
+type Node struct {
+	mutex   sync.Mutex
+	self    string
+	members members
+	config  Config
+	client  *http.Client
+	logger  log.Logger
+	stop    chan chan struct{}
+}
+
+// NewNode creates a new Node that's reachable at the address, with the role
+// describing what the member is used for.
+func NewNode(addr, role string, config Config, client *http.Client, logger log.Logger) *Node {
+	return &Node{
+		mutex: sync.Mutex{},
+		self:  addr,
+		members: members{
+			addr: &Member{
+				Addr:    addr,
+				Role:    role,
+				Status:  StatusAlive,
+				updated: time.Now(),
+			},
+		},
+		config: config,
+		client: client,
+		logger: logger,
+		stop:   make(chan chan struct{}),
+	}
+}
+
+// Addr returns the address of the Node.
+func (n *Node) Addr() string {
+	return n.self
+}
+
+// Members returns the local view of the cluster, including the Node.
+func (n *Node) Members() []Member {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	return n.members.list()
+}
+
+// Join the cluster via any of the seed addresses. It returns an error if none
+// of the seeds could be reached.
+func (n *Node) Join(seeds []string) error {
+	var joined int
+	for _, seed := range seeds {
+		if seed == n.self {
+			continue
+		}
+		if err := n.ping(seed); err != nil {
+			level.Warn(n.logger).Log("seed", seed, "err", err)
+			continue
+		}
+		joined++
+	}
+	if joined == 0 && len(seeds) > 0 {
+		return errors.New("unable to join any seeds")
+	}
+	return nil
+}
+
+// Leave the cluster, by telling every other member that the Node is dead.
+func (n *Node) Leave() {
+	n.mutex.Lock()
+	self := n.members[n.self]
+	self.Incarnation++
+	self.Status = StatusDead
+	targets := n.targets("")
+	n.mutex.Unlock()
+
+	var wg sync.WaitGroup
+	for _, v := range targets {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			if err := n.ping(addr); err != nil {
+				level.Debug(n.logger).Log("member", addr, "err", err)
+			}
+		}(v)
+	}
+	wg.Wait()
+}
+
+// Run the Node, probing a random member every probe interval.
+func (n *Node) Run() {
+	step := time.NewTicker(n.config.ProbeInterval)
+	defer step.Stop()
+
+	for {
+		select {
+		case <-step.C:
+			n.probe()
+
+		case q := <-n.stop:
+			close(q)
+			return
+		}
+	}
+}
+
+// Stop the Node.
+func (n *Node) Stop() {
+	q := make(chan struct{})
+	n.stop <- q
+	<-q
+}
+
+func (n *Node) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	method, path := r.Method, r.URL.Path
+	switch {
+	case method == "POST" && path == PathPing:
+		n.handlePing(w, r)
+	case method == "POST" && path == PathPingReq:
+		n.handlePingReq(w, r)
+	case method == "GET" && path == PathMembers:
+		n.handleMembers(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (n *Node) handlePing(w http.ResponseWriter, r *http.Request) {
+	var msg message
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	n.merge(msg.Members)
+	n.handleMembers(w, r)
+}
+
+func (n *Node) handlePingReq(w http.ResponseWriter, r *http.Request) {
+	var req pingReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := n.ping(req.Target); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	n.handleMembers(w, r)
+}
+
+func (n *Node) handleMembers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(message{Members: n.Members()})
+}
+
+// probe a random member, falling back to asking other members to probe it
+// before it's suspected.
+func (n *Node) probe() {
+	n.mutex.Lock()
+	now := time.Now()
+	for _, v := range n.members.expire(n.config.SuspicionTimeout, now) {
+		level.Warn(n.logger).Log("member", v.Addr, "status", v.Status)
+	}
+	n.members.reap(n.config.DeadTimeout, now)
+	targets := n.targets("")
+	n.mutex.Unlock()
+
+	if len(targets) == 0 {
+		return
+	}
+
+	target := targets[rand.Intn(len(targets))]
+	err := n.ping(target)
+	if err == nil {
+		return
+	}
+	level.Debug(n.logger).Log("member", target, "err", err)
+
+	if n.indirect(target) {
+		return
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if member, ok := n.members[target]; ok && member.Status == StatusAlive {
+		suspect := *member
+		suspect.Status = StatusSuspect
+		if n.members.merge(suspect, time.Now()) {
+			level.Warn(n.logger).Log("member", target, "status", StatusSuspect)
+		}
+	}
+}
+
+// indirect asks other members to probe the target, returning true if any of
+// them managed to reach it.
+func (n *Node) indirect(target string) bool {
+	n.mutex.Lock()
+	others := n.targets(target)
+	n.mutex.Unlock()
+
+	rand.Shuffle(len(others), func(i, j int) {
+		others[i], others[j] = others[j], others[i]
+	})
+	if len(others) > n.config.IndirectChecks {
+		others = others[:n.config.IndirectChecks]
+	}
+
+	var (
+		wg  sync.WaitGroup
+		res = make(chan bool, len(others))
+	)
+	for _, v := range others {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+
+			err := n.send(addr, PathPingReq, pingReq{Target: target}, n.config.ProbeTimeout*2)
+			res <- err == nil
+		}(v)
+	}
+	wg.Wait()
+	close(res)
+
+	for ok := range res {
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// targets returns the addresses of every other member that isn't dead,
+// excluding the address.
+// The Node must be locked when calling targets.
+func (n *Node) targets(exclude string) []string {
+	var res []string
+	for addr, v := range n.members {
+		if addr == n.self || addr == exclude || v.Status == StatusDead {
+			continue
+		}
+		res = append(res, addr)
+	}
+	return res
+}
+
+// ping exchanges the view of the cluster with the member.
+func (n *Node) ping(addr string) error {
+	return n.send(addr, PathPing, message{Members: n.Members()}, n.config.ProbeTimeout)
+}
+
+func (n *Node) send(addr, path string, body interface{}, timeout time.Duration) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("http://%s%s", addr, path), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	resp, err := n.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var msg message
+	if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+		return err
+	}
+	n.merge(msg.Members)
+	return nil
+}
+
+// merge the view of the cluster from another member, refuting any suspicion
+// about the Node itself.
+func (n *Node) merge(others []Member) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	now := time.Now()
+	for _, v := range others {
+		if v.Addr == n.self {
+			self := n.members[n.self]
+			if self.Status == StatusAlive && v.Status != StatusAlive && v.Incarnation >= self.Incarnation {
+				self.Incarnation = v.Incarnation + 1
+				level.Info(n.logger).Log("refute", v.Status, "incarnation", self.Incarnation)
+			}
+			continue
+		}
+
+		previous := StatusDead
+		if current, ok := n.members[v.Addr]; ok {
+			previous = current.Status
+		}
+		if n.members.merge(v, now) && previous != v.Status {
+			level.Debug(n.logger).Log("member", v.Addr, "status", v.Status)
+		}
+	}
+}
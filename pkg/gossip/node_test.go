@@ -0,0 +1,165 @@
+package gossip
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+var testConfig = Config{
+	ProbeInterval:    10 * time.Millisecond,
+	ProbeTimeout:     100 * time.Millisecond,
+	IndirectChecks:   1,
+	SuspicionTimeout: 100 * time.Millisecond,
+	DeadTimeout:      time.Second,
+}
+
+// startNode serves a new Node on loopback, returning a function that kills
+// the Node without it leaving the cluster.
+func startNode(t *testing.T) (*Node, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		node   = NewNode(listener.Addr().String(), RoleAgent, testConfig, &http.Client{}, log.NewNopLogger())
+		server = &http.Server{Handler: node}
+	)
+	go server.Serve(listener)
+	go node.Run()
+
+	return node, func() {
+		node.Stop()
+		server.Close()
+	}
+}
+
+// eventually checks the condition until it's true or the timeout passes.
+func eventually(timeout time.Duration, fn func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if fn() {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return fn()
+}
+
+func status(node *Node, addr string) Status {
+	for _, v := range node.Members() {
+		if v.Addr == addr {
+			return v.Status
+		}
+	}
+	return ""
+}
+
+func TestConfig(t *testing.T) {
+	t.Parallel()
+
+	if err := DefaultConfig().Validate(); err != nil {
+		t.Errorf("expected: nil, actual: %v", err)
+	}
+
+	config := DefaultConfig()
+	config.ProbeInterval = 0
+	if err := config.Validate(); err == nil {
+		t.Errorf("expected: error, actual: %v", err)
+	}
+}
+
+func TestNode(t *testing.T) {
+	t.Parallel()
+
+	t.Run("join", func(t *testing.T) {
+		a, stopA := startNode(t)
+		defer stopA()
+		b, stopB := startNode(t)
+		defer stopB()
+		c, stopC := startNode(t)
+		defer stopC()
+
+		if err := b.Join([]string{a.Addr()}); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Join([]string{a.Addr()}); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, node := range []*Node{a, b, c} {
+			node := node
+			if !eventually(2*time.Second, func() bool { return len(node.Members()) == 3 }) {
+				t.Errorf("%s: expected: 3, actual: %v", node.Addr(), node.Members())
+			}
+		}
+	})
+
+	t.Run("join unreachable", func(t *testing.T) {
+		a, stopA := startNode(t)
+		defer stopA()
+
+		if err := a.Join([]string{"127.0.0.1:1"}); err == nil {
+			t.Errorf("expected: error, actual: %v", err)
+		}
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		a, stopA := startNode(t)
+		defer stopA()
+		b, stopB := startNode(t)
+		defer stopB()
+		c, stopC := startNode(t)
+
+		for _, node := range []*Node{b, c} {
+			if err := node.Join([]string{a.Addr()}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !eventually(2*time.Second, func() bool { return status(b, c.Addr()) == StatusAlive }) {
+			t.Fatalf("expected: %s, actual: %s", StatusAlive, status(b, c.Addr()))
+		}
+
+		stopC()
+
+		for _, node := range []*Node{a, b} {
+			node := node
+			if !eventually(2*time.Second, func() bool { return status(node, c.Addr()) == StatusDead }) {
+				t.Errorf("%s: expected: %s, actual: %s", node.Addr(), StatusDead, status(node, c.Addr()))
+			}
+		}
+	})
+
+	t.Run("leave", func(t *testing.T) {
+		a, stopA := startNode(t)
+		defer stopA()
+		b, stopB := startNode(t)
+		defer stopB()
+
+		if err := b.Join([]string{a.Addr()}); err != nil {
+			t.Fatal(err)
+		}
+		b.Leave()
+
+		if expected, actual := StatusDead, status(a, b.Addr()); expected != actual {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+	})
+
+	t.Run("refute", func(t *testing.T) {
+		a, stopA := startNode(t)
+		defer stopA()
+
+		a.merge([]Member{{Addr: a.Addr(), Role: RoleAgent, Status: StatusSuspect, Incarnation: 3}})
+		if expected, actual := uint64(4), a.Members()[0].Incarnation; expected != actual {
+			t.Errorf("expected: %d, actual: %d", expected, actual)
+		}
+		if expected, actual := StatusAlive, status(a, a.Addr()); expected != actual {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+	})
+}
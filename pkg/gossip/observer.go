@@ -0,0 +1,160 @@
+package gossip
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+)
+
+// Observer follows the members of a cluster, without becoming a member
+// itself. This means that the Observer doesn't need to be reachable by the
+// members of the cluster.
+type Observer struct {
+	mutex   sync.Mutex
+	seeds   []string
+	members members
+	config  Config
+	client  *http.Client
+	notify  func([]Member)
+	logger  log.Logger
+	stop    chan chan struct{}
+}
+
+// NewObserver creates a new Observer, which starts by asking the seeds for the
+// members of the cluster. The notify function is called with the alive
+// members, every time the Observer syncs with the cluster.
+func NewObserver(seeds []string, config Config, client *http.Client, notify func([]Member), logger log.Logger) *Observer {
+	return &Observer{
+		mutex:   sync.Mutex{},
+		seeds:   seeds,
+		members: members{},
+		config:  config,
+		client:  client,
+		notify:  notify,
+		logger:  logger,
+		stop:    make(chan chan struct{}),
+	}
+}
+
+// Members returns the local view of the cluster.
+func (o *Observer) Members() []Member {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	return o.members.list()
+}
+
+// Alive returns the members that are alive.
+func (o *Observer) Alive() []Member {
+	var res []Member
+	for _, v := range o.Members() {
+		if v.Status == StatusAlive {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// Run the Observer, syncing with the cluster every probe interval.
+func (o *Observer) Run() {
+	o.step()
+
+	step := time.NewTicker(o.config.ProbeInterval)
+	defer step.Stop()
+
+	for {
+		select {
+		case <-step.C:
+			o.step()
+
+		case q := <-o.stop:
+			close(q)
+			return
+		}
+	}
+}
+
+// Stop the Observer.
+func (o *Observer) Stop() {
+	q := make(chan struct{})
+	o.stop <- q
+	<-q
+}
+
+func (o *Observer) step() {
+	if err := o.Sync(); err != nil {
+		level.Warn(o.logger).Log("err", err)
+	}
+	if o.notify != nil {
+		o.notify(o.Alive())
+	}
+}
+
+// Sync the local view of the cluster with the view of any member that's
+// alive, falling back to the seeds if none of them respond. Dead members are
+// left out of the local view.
+func (o *Observer) Sync() error {
+	var candidates []string
+	for _, v := range o.Alive() {
+		candidates = append(candidates, v.Addr)
+	}
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	candidates = append(candidates, o.seeds...)
+
+	for _, addr := range candidates {
+		others, err := o.fetch(addr)
+		if err != nil {
+			level.Debug(o.logger).Log("member", addr, "err", err)
+			continue
+		}
+
+		// The view of the member replaces the local view, as the Observer
+		// doesn't take part in detecting failures.
+		view, now := members{}, time.Now()
+		for _, v := range others {
+			view.merge(v, now)
+		}
+
+		o.mutex.Lock()
+		o.members = view
+		o.mutex.Unlock()
+		return nil
+	}
+	return errors.New("unable to reach any members")
+}
+
+func (o *Observer) fetch(addr string) ([]Member, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("http://%s%s", addr, PathMembers), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), o.config.ProbeTimeout)
+	defer cancel()
+
+	resp, err := o.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var msg message
+	if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+		return nil, err
+	}
+	return msg.Members, nil
+}
@@ -0,0 +1,61 @@
+package gossip
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestObserver(t *testing.T) {
+	t.Parallel()
+
+	a, stopA := startNode(t)
+	defer stopA()
+	b, stopB := startNode(t)
+
+	if err := b.Join([]string{a.Addr()}); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mutex    sync.Mutex
+		notified []Member
+		observer = NewObserver([]string{a.Addr()}, testConfig, &http.Client{}, func(members []Member) {
+			mutex.Lock()
+			notified = members
+			mutex.Unlock()
+		}, log.NewNopLogger())
+	)
+	go observer.Run()
+	defer observer.Stop()
+
+	alive := func(n int) func() bool {
+		return func() bool {
+			mutex.Lock()
+			defer mutex.Unlock()
+			return len(notified) == n
+		}
+	}
+
+	if !eventually(2*time.Second, alive(2)) {
+		t.Fatalf("expected: 2, actual: %v", observer.Members())
+	}
+
+	// Once a member leaves, the observer forgets it.
+	b.Leave()
+	stopB()
+
+	if !eventually(2*time.Second, alive(1)) {
+		t.Fatalf("expected: 1, actual: %v", observer.Members())
+	}
+	if expected, actual := a.Addr(), observer.Alive()[0].Addr; expected != actual {
+		t.Errorf("expected: %s, actual: %s", expected, actual)
+	}
+
+	if err := NewObserver([]string{"127.0.0.1:1"}, testConfig, &http.Client{}, nil, log.NewNopLogger()).Sync(); err == nil {
+		t.Errorf("expected: error, actual: %v", err)
+	}
+}
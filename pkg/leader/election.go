@@ -0,0 +1,122 @@
+package leader
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// Election elects a leader from the candidates sharing a Lock, where the
+// leader is the candidate holding the lock. The leader renews the lock every
+// third of the ttl, whilst the other candidates try to acquire it, so that a
+// new leader is elected once the lock of a leader that went away expires.
+type Election struct {
+	mutex     sync.RWMutex
+	lock      Lock
+	candidate string
+	ttl       time.Duration
+	leader    string
+	notify    func(bool)
+	logger    log.Logger
+	stop      chan chan struct{}
+}
+
+// NewElection creates an Election for the candidate, which is typically the
+// address of the candidate, so that the other candidates can reach the leader.
+// The notify function is called every time the candidate starts or stops
+// leading.
+func NewElection(lock Lock, candidate string, ttl time.Duration, notify func(bool), logger log.Logger) *Election {
+	return &Election{
+		mutex:     sync.RWMutex{},
+		lock:      lock,
+		candidate: candidate,
+		ttl:       ttl,
+		notify:    notify,
+		logger:    logger,
+		stop:      make(chan chan struct{}),
+	}
+}
+
+// Candidate returns the candidate of the election.
+func (e *Election) Candidate() string {
+	return e.candidate
+}
+
+// Leader returns the candidate that was last known to be leading, which is
+// empty if the leader isn't known.
+func (e *Election) Leader() string {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	return e.leader
+}
+
+// Leading defines if the candidate is the leader.
+func (e *Election) Leading() bool {
+	return e.Leader() == e.candidate
+}
+
+// Run the election, campaigning every third of the ttl.
+func (e *Election) Run() {
+	e.Campaign()
+
+	step := time.NewTicker(e.ttl / 3)
+	defer step.Stop()
+
+	for {
+		select {
+		case <-step.C:
+			e.Campaign()
+
+		case q := <-e.stop:
+			close(q)
+			return
+		}
+	}
+}
+
+// Stop the election, which also stands the candidate down if it's leading.
+func (e *Election) Stop() {
+	q := make(chan struct{})
+	e.stop <- q
+	<-q
+
+	if e.Leading() {
+		if err := e.lock.Release(e.candidate); err != nil {
+			level.Warn(e.logger).Log("candidate", e.candidate, "err", err)
+		}
+	}
+	e.elect("")
+}
+
+// Campaign tries to acquire the lock for the candidate, which also finds the
+// current leader. If the lock can't be reached, the candidate stands down, as
+// the lock could expire and be acquired by another candidate.
+func (e *Election) Campaign() {
+	leader, err := e.lock.Acquire(e.candidate, e.ttl)
+	if err != nil {
+		level.Warn(e.logger).Log("candidate", e.candidate, "err", err)
+		leader = ""
+	}
+	e.elect(leader)
+}
+
+// elect the leader, notifying if the candidate started or stopped leading.
+func (e *Election) elect(leader string) {
+	e.mutex.Lock()
+	var (
+		was = e.leader == e.candidate
+		is  = leader == e.candidate
+	)
+	if leader != e.leader {
+		level.Info(e.logger).Log("candidate", e.candidate, "leader", leader)
+	}
+	e.leader = leader
+	e.mutex.Unlock()
+
+	if was != is && e.notify != nil {
+		e.notify(is)
+	}
+}
@@ -0,0 +1,120 @@
+package leader
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+)
+
+func TestElection(t *testing.T) {
+	t.Parallel()
+
+	logger := log.NewNopLogger()
+
+	t.Run("campaign", func(t *testing.T) {
+		var (
+			mutex   sync.Mutex
+			changes []bool
+			lock    = NewMemoryLock()
+			a       = NewElection(lock, "a", time.Minute, func(leading bool) {
+				mutex.Lock()
+				changes = append(changes, leading)
+				mutex.Unlock()
+			}, logger)
+			b = NewElection(lock, "b", time.Minute, nil, logger)
+		)
+
+		a.Campaign()
+		b.Campaign()
+
+		for _, testcase := range []struct {
+			election *Election
+			leading  bool
+		}{
+			{a, true},
+			{b, false},
+		} {
+			if expected, actual := "a", testcase.election.Leader(); expected != actual {
+				t.Errorf("expected: %s, actual: %s", expected, actual)
+			}
+			if expected, actual := testcase.leading, testcase.election.Leading(); expected != actual {
+				t.Errorf("expected: %t, actual: %t", expected, actual)
+			}
+		}
+
+		// Campaigning again whilst leading doesn't notify again.
+		a.Campaign()
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		if expected, actual := 1, len(changes); expected != actual || !changes[0] {
+			t.Errorf("expected: %d, actual: %v", expected, changes)
+		}
+	})
+
+	t.Run("lock error", func(t *testing.T) {
+		var (
+			lock     = NewMemoryLock()
+			leading  = true
+			election = NewElection(failingLock{lock}, "a", time.Minute, func(v bool) {
+				leading = v
+			}, logger)
+		)
+		election.elect("a")
+		election.Campaign()
+
+		if leading || election.Leading() {
+			t.Errorf("expected: not leading, actual: leading")
+		}
+	})
+
+	t.Run("run", func(t *testing.T) {
+		var (
+			lock = NewMemoryLock()
+			ttl  = 30 * time.Millisecond
+			a    = NewElection(lock, "a", ttl, nil, logger)
+			b    = NewElection(lock, "b", ttl, nil, logger)
+		)
+
+		go a.Run()
+		waitFor := func(e *Election, leader string) bool {
+			deadline := time.Now().Add(time.Second)
+			for time.Now().Before(deadline) {
+				if e.Leader() == leader {
+					return true
+				}
+				time.Sleep(time.Millisecond)
+			}
+			return false
+		}
+		if !waitFor(a, "a") {
+			t.Fatalf("expected: a, actual: %s", a.Leader())
+		}
+
+		go b.Run()
+		defer b.Stop()
+		if !waitFor(b, "a") {
+			t.Fatalf("expected: a, actual: %s", b.Leader())
+		}
+
+		// Stopping the leader, allows another candidate to lead.
+		a.Stop()
+		if expected, actual := "", a.Leader(); expected != actual {
+			t.Errorf("expected: %q, actual: %q", expected, actual)
+		}
+		if !waitFor(b, "b") {
+			t.Errorf("expected: b, actual: %s", b.Leader())
+		}
+	})
+}
+
+type failingLock struct {
+	Lock
+}
+
+func (failingLock) Acquire(candidate string, ttl time.Duration) (string, error) {
+	return "", errors.New("failing lock")
+}
@@ -0,0 +1,140 @@
+package leader
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Lock is held by at most one candidate at a time, until the lock expires.
+type Lock interface {
+	// Acquire the lock for the candidate, until the ttl expires. Acquiring a
+	// lock that's already held by the candidate renews it.
+	// It returns the candidate holding the lock, which isn't the candidate if
+	// the lock is held by another candidate.
+	Acquire(candidate string, ttl time.Duration) (string, error)
+
+	// Release the lock, if it's held by the candidate.
+	Release(candidate string) error
+}
+
+// hold defines who holds a lock and until when.
+type hold struct {
+	Candidate string    `json:"candidate"`
+	Expires   time.Time `json:"expires"`
+}
+
+// acquire the hold for the candidate, unless it's held by another candidate.
+func (h hold) acquire(candidate string, ttl time.Duration, now time.Time) (hold, bool) {
+	if h.Candidate != "" && h.Candidate != candidate && now.Before(h.Expires) {
+		return h, false
+	}
+	return hold{Candidate: candidate, Expires: now.Add(ttl)}, true
+}
+
+// MemoryLock is a Lock for candidates with in the same process.
+type MemoryLock struct {
+	mutex sync.Mutex
+	hold  hold
+}
+
+// NewMemoryLock creates a new in-memory Lock.
+func NewMemoryLock() *MemoryLock {
+	return &MemoryLock{
+		mutex: sync.Mutex{},
+	}
+}
+
+// Acquire the lock for the candidate, until the ttl expires.
+func (l *MemoryLock) Acquire(candidate string, ttl time.Duration) (string, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.hold, _ = l.hold.acquire(candidate, ttl, time.Now())
+	return l.hold.Candidate, nil
+}
+
+// Release the lock, if it's held by the candidate.
+func (l *MemoryLock) Release(candidate string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.hold.Candidate == candidate {
+		l.hold = hold{}
+	}
+	return nil
+}
+
+// FileLock is a Lock for candidates that share a lock file, which records the
+// candidate holding the lock. The lock file is locked whilst it's read and
+// written, so only one candidate can acquire the lock at once.
+type FileLock struct {
+	path string
+}
+
+// NewFileLock creates a Lock using the lock file found at the path, which is
+// created if it doesn't exist.
+func NewFileLock(path string) *FileLock {
+	return &FileLock{
+		path: path,
+	}
+}
+
+// Acquire the lock for the candidate, until the ttl expires.
+func (l *FileLock) Acquire(candidate string, ttl time.Duration) (string, error) {
+	var holder string
+	err := l.update(func(h hold) (hold, bool) {
+		res, ok := h.acquire(candidate, ttl, time.Now())
+		holder = res.Candidate
+		return res, ok
+	})
+	return holder, err
+}
+
+// Release the lock, if it's held by the candidate.
+func (l *FileLock) Release(candidate string) error {
+	return l.update(func(h hold) (hold, bool) {
+		return hold{}, h.Candidate == candidate
+	})
+}
+
+// update reads the hold from the lock file and writes back the hold returned
+// by the function, if it's changed.
+func (l *FileLock) update(fn func(hold) (hold, bool)) error {
+	file, err := os.OpenFile(l.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := lockFile(file); err != nil {
+		return errors.Wrapf(err, "lock %q", l.path)
+	}
+	defer unlockFile(file)
+
+	var h hold
+	if err := json.NewDecoder(file).Decode(&h); err != nil && err != io.EOF {
+		return errors.Wrapf(err, "corrupt lock %q", l.path)
+	}
+
+	h, changed := fn(h)
+	if !changed {
+		return nil
+	}
+
+	b, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := file.WriteAt(b, 0); err != nil {
+		return err
+	}
+	return file.Sync()
+}
@@ -0,0 +1,76 @@
+package leader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "leader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, newLock := range map[string]func() Lock{
+		"memory": func() Lock { return NewMemoryLock() },
+		"file":   func() Lock { return NewFileLock(filepath.Join(dir, "leader.lock")) },
+	} {
+		newLock := newLock
+		t.Run(name, func(t *testing.T) {
+			var (
+				lock  = newLock()
+				other = lock
+			)
+			if _, ok := lock.(*FileLock); ok {
+				// Another lock for the same file, as if it was another proxy.
+				other = newLock()
+			}
+
+			acquire := func(l Lock, candidate string, ttl time.Duration, expected string) {
+				t.Helper()
+
+				actual, err := l.Acquire(candidate, ttl)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if expected != actual {
+					t.Errorf("expected: %s, actual: %s", expected, actual)
+				}
+			}
+
+			acquire(lock, "a", time.Minute, "a")
+			acquire(other, "b", time.Minute, "a")
+			acquire(lock, "a", time.Minute, "a")
+
+			// Releasing a lock held by someone else does nothing.
+			if err := other.Release("b"); err != nil {
+				t.Fatal(err)
+			}
+			acquire(other, "b", time.Minute, "a")
+
+			if err := lock.Release("a"); err != nil {
+				t.Fatal(err)
+			}
+			acquire(other, "b", -time.Second, "b")
+
+			// The lock has expired, so it can be acquired by anyone.
+			acquire(lock, "a", time.Minute, "a")
+		})
+	}
+
+	t.Run("corrupt", func(t *testing.T) {
+		path := filepath.Join(dir, "corrupt.lock")
+		if err := ioutil.WriteFile(path, []byte("bad"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewFileLock(path).Acquire("a", time.Minute); err == nil {
+			t.Errorf("expected: error, actual: %v", err)
+		}
+	})
+}
@@ -0,0 +1,19 @@
+//go:build windows
+// +build windows
+
+package leader
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// lockFile isn't supported on windows, so the lock file can't be used.
+func lockFile(file *os.File) error {
+	return errors.New("lock file isn't supported on windows")
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,147 @@
+package peer
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// BalanceType enumerates the ways of picking a single peer.
+type BalanceType string
+
+const (
+	// BalanceTypeRoundRobin picks each peer in turn.
+	BalanceTypeRoundRobin BalanceType = "round-robin"
+
+	// BalanceTypeLeastOutstanding picks the peer with the fewest requests
+	// waiting on a response.
+	BalanceTypeLeastOutstanding BalanceType = "least-outstanding"
+
+	// BalanceTypePowerOfTwo picks two peers at random and then picks the one
+	// with the lowest latency.
+	BalanceTypePowerOfTwo BalanceType = "power-of-two"
+)
+
+// ParseBalanceType takes a string and validates it against known BalanceTypes
+func ParseBalanceType(s string) (BalanceType, error) {
+	switch s {
+	case string(BalanceTypeRoundRobin):
+		return BalanceTypeRoundRobin, nil
+	case string(BalanceTypeLeastOutstanding):
+		return BalanceTypeLeastOutstanding, nil
+	case string(BalanceTypePowerOfTwo):
+		return BalanceTypePowerOfTwo, nil
+	default:
+		return BalanceType(""), errors.New("invalid balance type")
+	}
+}
+
+// Balancer picks a single peer out of the peers.
+type Balancer interface {
+	// Pick a peer, which is nil if there are no peers.
+	Pick(peers []*Peer) *Peer
+}
+
+// NewBalancer creates a Balancer for the BalanceType.
+func NewBalancer(t BalanceType) (Balancer, error) {
+	switch t {
+	case BalanceTypeRoundRobin:
+		return &roundRobin{}, nil
+	case BalanceTypeLeastOutstanding:
+		return &leastOutstanding{}, nil
+	case BalanceTypePowerOfTwo:
+		return &powerOfTwo{
+			rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+		}, nil
+	default:
+		return nil, errors.Errorf("invalid balance type %q", t)
+	}
+}
+
+type roundRobin struct {
+	mutex sync.Mutex
+	next  int
+}
+
+// Pick the peers in turn.
+func (b *roundRobin) Pick(peers []*Peer) *Peer {
+	if len(peers) == 0 {
+		return nil
+	}
+	return peers[b.offset()%len(peers)]
+}
+
+// offset returns the next offset, which only ever increases.
+func (b *roundRobin) offset() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	n := b.next
+	b.next++
+	return n
+}
+
+type leastOutstanding struct {
+	turns roundRobin
+}
+
+// Pick the peer with the fewest outstanding requests, where the peers are
+// taken in turn if they have the same amount.
+func (b *leastOutstanding) Pick(peers []*Peer) *Peer {
+	if len(peers) == 0 {
+		return nil
+	}
+
+	var (
+		offset = b.turns.offset()
+		res    *Peer
+		fewest int
+	)
+	for i := range peers {
+		p := peers[(i+offset)%len(peers)]
+		if outstanding := p.Stats().Outstanding; res == nil || outstanding < fewest {
+			res, fewest = p, outstanding
+		}
+	}
+	return res
+}
+
+type powerOfTwo struct {
+	mutex sync.Mutex
+	rand  *rand.Rand
+}
+
+// Pick two peers at random and then pick the peer with the lowest latency, or
+// the fewest outstanding requests if they have the same latency.
+func (b *powerOfTwo) Pick(peers []*Peer) *Peer {
+	switch len(peers) {
+	case 0:
+		return nil
+	case 1:
+		return peers[0]
+	}
+
+	b.mutex.Lock()
+	var (
+		i = b.rand.Intn(len(peers))
+		j = b.rand.Intn(len(peers) - 1)
+	)
+	b.mutex.Unlock()
+	if j >= i {
+		j++
+	}
+
+	x, y := peers[i].Stats(), peers[j].Stats()
+	switch {
+	case x.Latency < y.Latency:
+		return peers[i]
+	case y.Latency < x.Latency:
+		return peers[j]
+	case y.Outstanding < x.Outstanding:
+		return peers[j]
+	default:
+		return peers[i]
+	}
+}
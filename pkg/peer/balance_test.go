@@ -0,0 +1,149 @@
+package peer
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestBalanceType(t *testing.T) {
+	t.Parallel()
+
+	for _, v := range []BalanceType{
+		BalanceTypeRoundRobin,
+		BalanceTypeLeastOutstanding,
+		BalanceTypePowerOfTwo,
+	} {
+		balance, err := ParseBalanceType(string(v))
+		if err != nil {
+			t.Errorf("expected: nil, actual: %v", err)
+		}
+		if expected, actual := v, balance; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	}
+
+	if _, err := ParseBalanceType("bad"); err == nil {
+		t.Errorf("expected: error, actual: %v", err)
+	}
+	if _, err := NewBalancer("bad"); err == nil {
+		t.Errorf("expected: error, actual: %v", err)
+	}
+}
+
+func TestBalancer(t *testing.T) {
+	t.Parallel()
+
+	logger := log.NewNopLogger()
+
+	newPeers := func(n int) []*Peer {
+		res := make([]*Peer, n)
+		for i := range res {
+			res[i] = NewPeer(http.DefaultClient, "http", fmt.Sprintf("10.0.0.%d:8080", i), logger)
+		}
+		return res
+	}
+
+	newBalancer := func(t *testing.T, balance BalanceType) Balancer {
+		balancer, err := NewBalancer(balance)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return balancer
+	}
+
+	t.Run("no peers", func(t *testing.T) {
+		for _, v := range []BalanceType{
+			BalanceTypeRoundRobin,
+			BalanceTypeLeastOutstanding,
+			BalanceTypePowerOfTwo,
+		} {
+			if p := newBalancer(t, v).Pick(nil); p != nil {
+				t.Errorf("%s: expected: nil, actual: %v", v, p)
+			}
+		}
+	})
+
+	t.Run("round-robin", func(t *testing.T) {
+		var (
+			peers    = newPeers(3)
+			balancer = newBalancer(t, BalanceTypeRoundRobin)
+		)
+		for i := 0; i < 6; i++ {
+			if expected, actual := peers[i%3], balancer.Pick(peers); expected != actual {
+				t.Errorf("expected: %s, actual: %s", expected.Addr(), actual.Addr())
+			}
+		}
+	})
+
+	t.Run("least-outstanding", func(t *testing.T) {
+		var (
+			peers    = newPeers(3)
+			balancer = newBalancer(t, BalanceTypeLeastOutstanding)
+		)
+		peers[0].begin()
+		peers[0].begin()
+		peers[2].begin()
+
+		for i := 0; i < 3; i++ {
+			if expected, actual := peers[1], balancer.Pick(peers); expected != actual {
+				t.Errorf("expected: %s, actual: %s", expected.Addr(), actual.Addr())
+			}
+		}
+
+		// Peers with the same amount of outstanding requests are taken in turn.
+		peers[1].begin()
+		picked := make(map[*Peer]struct{})
+		for i := 0; i < 3; i++ {
+			picked[balancer.Pick(peers)] = struct{}{}
+		}
+		if _, ok := picked[peers[0]]; ok {
+			t.Errorf("expected: %s to be skipped", peers[0].Addr())
+		}
+		if expected, actual := 2, len(picked); expected != actual {
+			t.Errorf("expected: %d, actual: %d", expected, actual)
+		}
+	})
+
+	t.Run("power-of-two", func(t *testing.T) {
+		var (
+			peers    = newPeers(2)
+			balancer = newBalancer(t, BalanceTypePowerOfTwo)
+		)
+		peers[0].begin()
+		peers[0].end(100*time.Millisecond, nil)
+		peers[1].begin()
+		peers[1].end(10*time.Millisecond, nil)
+
+		// With two peers, both are always compared.
+		for i := 0; i < 10; i++ {
+			if expected, actual := peers[1], balancer.Pick(peers); expected != actual {
+				t.Errorf("expected: %s, actual: %s", expected.Addr(), actual.Addr())
+			}
+		}
+
+		if expected, actual := peers[0], balancer.Pick(peers[:1]); expected != actual {
+			t.Errorf("expected: %s, actual: %s", expected.Addr(), actual.Addr())
+		}
+	})
+
+	t.Run("power-of-two spread", func(t *testing.T) {
+		var (
+			peers    = newPeers(4)
+			balancer = newBalancer(t, BalanceTypePowerOfTwo)
+			picked   = make(map[*Peer]int)
+		)
+		for i := 0; i < 100; i++ {
+			picked[balancer.Pick(peers)]++
+		}
+
+		// Without any stats, the first of the pair is picked, which spreads the
+		// picks over every peer.
+		if len(picked) != len(peers) {
+			t.Errorf("expected: picks spread over the peers, actual: %v", picked)
+		}
+	})
+}
@@ -0,0 +1,235 @@
+package peer
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// BreakerPolicy defines when the circuit breaker of a peer opens and how long
+// it stays open for.
+type BreakerPolicy struct {
+	// FailureRatio (between 0 and 1) of the recent requests that have to fail
+	// for the circuit to open, where zero disables the breaker.
+	FailureRatio float64 `json:"failure_ratio"`
+
+	// Window is the amount of recent requests that the ratio is taken over,
+	// which also have to be made before the circuit can open.
+	Window int `json:"window"`
+
+	// CoolDown is how long the circuit stays open, before a single trial
+	// request is let through (half-open).
+	CoolDown time.Duration `json:"cool_down"`
+}
+
+// DefaultBreakerPolicy has the breaker disabled, until a FailureRatio is set,
+// with a window of the last 10 requests to a peer and a 30 second cool down.
+func DefaultBreakerPolicy() BreakerPolicy {
+	return BreakerPolicy{
+		FailureRatio: 0,
+		Window:       10,
+		CoolDown:     30 * time.Second,
+	}
+}
+
+// Enabled defines if the breaker is used at all.
+func (p BreakerPolicy) Enabled() bool {
+	return p.FailureRatio > 0
+}
+
+// Validate the BreakerPolicy, to make sure that it's usable.
+func (p BreakerPolicy) Validate() error {
+	switch {
+	case p.FailureRatio < 0 || p.FailureRatio > 1:
+		return errors.New("breaker failure ratio must be between 0 and 1")
+	case p.Enabled() && p.Window < 1:
+		return errors.New("breaker window must be at least 1")
+	case p.CoolDown < 0:
+		return errors.New("breaker cool down must not be negative")
+	}
+	return nil
+}
+
+// BreakerState enumerates the states of the circuit breaker of a peer.
+type BreakerState string
+
+const (
+	// BreakerStateClosed lets every request through.
+	BreakerStateClosed BreakerState = "closed"
+
+	// BreakerStateOpen doesn't let any requests through, until the cool down
+	// has passed.
+	BreakerStateOpen BreakerState = "open"
+
+	// BreakerStateHalfOpen lets a single trial request through, which closes
+	// the circuit if it succeeds or opens it again if it fails.
+	BreakerStateHalfOpen BreakerState = "half-open"
+)
+
+// Breaker describes the circuit breaker of a peer.
+type Breaker struct {
+	State BreakerState `json:"state"`
+
+	// Requests and Failures are the outcomes of the recent requests, whilst
+	// the circuit is closed.
+	Requests int `json:"requests"`
+	Failures int `json:"failures"`
+
+	// Opened is when the circuit last opened.
+	Opened time.Time `json:"opened"`
+}
+
+// Breaker returns the current state of the circuit breaker of the peer.
+func (p *Peer) Breaker() Breaker {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return Breaker{
+		State:    p.circuit.current(),
+		Requests: len(p.circuit.outcomes),
+		Failures: p.circuit.failures,
+		Opened:   p.circuit.opened,
+	}
+}
+
+// Permit is given to each request that the circuit breaker lets through, so
+// that only the outcomes of the requests sent since the circuit last changed
+// state are observed.
+type Permit struct {
+	generation uint64
+	trial      bool
+}
+
+// Allow defines if a request can be sent to the peer, according to the
+// circuit breaker. Once the cool down of an open circuit has passed, it's
+// half-open and only the first request is allowed through as a trial. The
+// Permit of an allowed request has to be given to either Observe or Release.
+func (p *Peer) Allow(policy BreakerPolicy) (Permit, bool) {
+	if !policy.Enabled() {
+		return Permit{}, true
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	c := &p.circuit
+	switch c.current() {
+	case BreakerStateOpen:
+		if time.Since(c.opened) < policy.CoolDown {
+			return Permit{}, false
+		}
+		c.state = BreakerStateHalfOpen
+	case BreakerStateHalfOpen:
+		if c.trial {
+			return Permit{}, false
+		}
+	default:
+		return Permit{generation: c.generation}, true
+	}
+
+	c.trial = true
+	return Permit{generation: c.generation, trial: true}, true
+}
+
+// Observe the outcome of the request with the Permit, returning true if the
+// circuit changed state. Only the outcome of the trial request closes or opens
+// a half-open circuit.
+func (p *Peer) Observe(permit Permit, success bool, policy BreakerPolicy) bool {
+	if !policy.Enabled() {
+		return false
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	// Requests that were already in flight when the circuit changed state.
+	c := &p.circuit
+	if permit.generation != c.generation {
+		return false
+	}
+
+	switch c.current() {
+	case BreakerStateHalfOpen:
+		if !permit.trial {
+			return false
+		}
+		if success {
+			c.close()
+		} else {
+			c.open()
+		}
+		return true
+	case BreakerStateOpen:
+		return false
+	}
+
+	c.add(success, policy.Window)
+	if len(c.outcomes) >= policy.Window && float64(c.failures) >= policy.FailureRatio*float64(len(c.outcomes)) {
+		c.open()
+		return true
+	}
+	return false
+}
+
+// Release the request with the Permit, without an outcome (e.g. the request was
+// cancelled). If it was the trial request of a half-open circuit, then another
+// trial request is allowed.
+func (p *Peer) Release(permit Permit) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if c := &p.circuit; permit.trial && permit.generation == c.generation {
+		c.trial = false
+	}
+}
+
+// circuit keeps the outcomes of the recent requests to a peer. The generation
+// changes every time the circuit opens or closes.
+type circuit struct {
+	state      BreakerState
+	generation uint64
+	outcomes   []bool
+	next       int
+	failures   int
+	opened     time.Time
+	trial      bool
+}
+
+// current state of the circuit, which is closed until it first opens.
+func (c *circuit) current() BreakerState {
+	if c.state == "" {
+		return BreakerStateClosed
+	}
+	return c.state
+}
+
+func (c *circuit) add(success bool, window int) {
+	if len(c.outcomes) < window {
+		c.outcomes = append(c.outcomes, success)
+	} else {
+		if !c.outcomes[c.next] {
+			c.failures--
+		}
+		c.outcomes[c.next] = success
+		c.next = (c.next + 1) % len(c.outcomes)
+	}
+	if !success {
+		c.failures++
+	}
+}
+
+func (c *circuit) open() {
+	c.state = BreakerStateOpen
+	c.opened = time.Now()
+	c.reset()
+}
+
+func (c *circuit) close() {
+	c.state = BreakerStateClosed
+	c.reset()
+}
+
+func (c *circuit) reset() {
+	c.generation++
+	c.outcomes, c.next, c.failures, c.trial = nil, 0, 0, false
+}
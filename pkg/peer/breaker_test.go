@@ -0,0 +1,241 @@
+package peer
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestBreakerPolicy(t *testing.T) {
+	t.Parallel()
+
+	t.Run("default", func(t *testing.T) {
+		policy := DefaultBreakerPolicy()
+		if err := policy.Validate(); err != nil {
+			t.Errorf("expected: nil, actual: %v", err)
+		}
+		if policy.Enabled() {
+			t.Errorf("expected: false, actual: %v", policy.Enabled())
+		}
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		policy := DefaultBreakerPolicy()
+		policy.FailureRatio = 0.5
+		if err := policy.Validate(); err != nil {
+			t.Errorf("expected: nil, actual: %v", err)
+		}
+		if !policy.Enabled() {
+			t.Errorf("expected: true, actual: %v", policy.Enabled())
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		policy := BreakerPolicy{}
+		if err := policy.Validate(); err != nil {
+			t.Errorf("expected: nil, actual: %v", err)
+		}
+		if policy.Enabled() {
+			t.Errorf("expected: false, actual: %v", policy.Enabled())
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, fn := range []func(*BreakerPolicy){
+			func(p *BreakerPolicy) { p.FailureRatio = -0.1 },
+			func(p *BreakerPolicy) { p.FailureRatio = 1.1 },
+			func(p *BreakerPolicy) { p.Window = 0 },
+			func(p *BreakerPolicy) { p.CoolDown = -1 },
+		} {
+			policy := DefaultBreakerPolicy()
+			policy.FailureRatio = 0.5
+			fn(&policy)
+			if err := policy.Validate(); err == nil {
+				t.Errorf("expected: error, actual: %v", err)
+			}
+		}
+	})
+}
+
+func TestPeerBreaker(t *testing.T) {
+	t.Parallel()
+
+	var (
+		logger = log.NewNopLogger()
+		policy = BreakerPolicy{
+			FailureRatio: 0.5,
+			Window:       4,
+			CoolDown:     time.Minute,
+		}
+	)
+
+	// state asserts the state of the circuit breaker of the peer.
+	state := func(t *testing.T, peer *Peer, expected BreakerState) {
+		t.Helper()
+		if actual := peer.Breaker().State; expected != actual {
+			t.Fatalf("expected: %s, actual: %s", expected, actual)
+		}
+	}
+
+	// observe the outcome of a request that the circuit breaker allowed.
+	observe := func(peer *Peer, success bool, policy BreakerPolicy) bool {
+		permit, _ := peer.Allow(policy)
+		return peer.Observe(permit, success, policy)
+	}
+
+	t.Run("closed by default", func(t *testing.T) {
+		peer := NewPeer(http.DefaultClient, "http", "0.0.0.0:0", logger)
+		state(t, peer, BreakerStateClosed)
+		if _, ok := peer.Allow(policy); !ok {
+			t.Errorf("expected: true, actual: false")
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		var (
+			peer     = NewPeer(http.DefaultClient, "http", "0.0.0.0:0", logger)
+			disabled = BreakerPolicy{Window: 1}
+		)
+		for i := 0; i < 10; i++ {
+			if observe(peer, false, disabled) {
+				t.Fatalf("%d: expected: false, actual: true", i)
+			}
+		}
+		state(t, peer, BreakerStateClosed)
+		if _, ok := peer.Allow(disabled); !ok {
+			t.Errorf("expected: true, actual: false")
+		}
+	})
+
+	t.Run("failure ratio", func(t *testing.T) {
+		peer := NewPeer(http.DefaultClient, "http", "0.0.0.0:0", logger)
+
+		// The window has to fill up, before the circuit opens.
+		for i, success := range []bool{false, false, true} {
+			if observe(peer, success, policy) {
+				t.Fatalf("%d: expected: closed, actual: %v", i, peer.Breaker())
+			}
+		}
+		if expected, actual := (Breaker{State: BreakerStateClosed, Requests: 3, Failures: 2}), peer.Breaker(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+
+		if !observe(peer, true, policy) {
+			t.Fatalf("expected: open, actual: %v", peer.Breaker())
+		}
+		state(t, peer, BreakerStateOpen)
+		if _, ok := peer.Allow(policy); ok {
+			t.Errorf("expected: false, actual: true")
+		}
+		if peer.Breaker().Opened.IsZero() {
+			t.Errorf("expected: opened, actual: %v", peer.Breaker().Opened)
+		}
+	})
+
+	t.Run("window", func(t *testing.T) {
+		peer := NewPeer(http.DefaultClient, "http", "0.0.0.0:0", logger)
+
+		// Old failures drop out of the window.
+		for i, success := range []bool{false, true, true, true, true, false, true, true} {
+			if observe(peer, success, policy) {
+				t.Fatalf("%d: expected: closed, actual: %v", i, peer.Breaker())
+			}
+		}
+		if expected, actual := 1, peer.Breaker().Failures; expected != actual {
+			t.Errorf("expected: %d, actual: %d", expected, actual)
+		}
+		if !observe(peer, false, policy) {
+			t.Fatalf("expected: open, actual: %v", peer.Breaker())
+		}
+	})
+
+	t.Run("half-open", func(t *testing.T) {
+		var (
+			peer   = NewPeer(http.DefaultClient, "http", "0.0.0.0:0", logger)
+			cooled = BreakerPolicy{FailureRatio: 1, Window: 1}
+		)
+
+		if !observe(peer, false, cooled) {
+			t.Fatalf("expected: open, actual: %v", peer.Breaker())
+		}
+
+		// Only a single trial is let through once the cool down has passed.
+		trial, ok := peer.Allow(cooled)
+		if !ok {
+			t.Fatalf("expected: true, actual: false")
+		}
+		state(t, peer, BreakerStateHalfOpen)
+		if _, ok := peer.Allow(cooled); ok {
+			t.Fatalf("expected: false, actual: true")
+		}
+
+		// Releasing the trial lets another trial through.
+		peer.Release(trial)
+		if trial, ok = peer.Allow(cooled); !ok {
+			t.Fatalf("expected: true, actual: false")
+		}
+
+		// A failed trial opens the circuit again.
+		if !peer.Observe(trial, false, cooled) {
+			t.Fatalf("expected: open, actual: %v", peer.Breaker())
+		}
+		state(t, peer, BreakerStateOpen)
+
+		// A successful trial closes the circuit.
+		if !observe(peer, true, cooled) {
+			t.Fatalf("expected: closed, actual: %v", peer.Breaker())
+		}
+		state(t, peer, BreakerStateClosed)
+		if expected, actual := 0, peer.Breaker().Requests; expected != actual {
+			t.Errorf("expected: %d, actual: %d", expected, actual)
+		}
+	})
+
+	t.Run("in flight whilst open", func(t *testing.T) {
+		peer := NewPeer(http.DefaultClient, "http", "0.0.0.0:0", logger)
+
+		inflight, _ := peer.Allow(policy)
+		for i := 0; i < 4; i++ {
+			observe(peer, false, policy)
+		}
+		state(t, peer, BreakerStateOpen)
+
+		if peer.Observe(inflight, true, policy) {
+			t.Errorf("expected: false, actual: true")
+		}
+		state(t, peer, BreakerStateOpen)
+	})
+
+	t.Run("in flight whilst half-open", func(t *testing.T) {
+		var (
+			peer   = NewPeer(http.DefaultClient, "http", "0.0.0.0:0", logger)
+			cooled = BreakerPolicy{FailureRatio: 1, Window: 1}
+		)
+
+		inflight, _ := peer.Allow(cooled)
+		if !observe(peer, false, cooled) {
+			t.Fatalf("expected: open, actual: %v", peer.Breaker())
+		}
+		trial, ok := peer.Allow(cooled)
+		if !ok {
+			t.Fatalf("expected: true, actual: false")
+		}
+
+		// Only the trial closes or opens the half-open circuit.
+		if peer.Observe(inflight, true, cooled) {
+			t.Errorf("expected: false, actual: true")
+		}
+		peer.Release(inflight)
+		state(t, peer, BreakerStateHalfOpen)
+		if _, ok := peer.Allow(cooled); ok {
+			t.Errorf("expected: false, actual: true")
+		}
+
+		if !peer.Observe(trial, true, cooled) {
+			t.Fatalf("expected: closed, actual: %v", peer.Breaker())
+		}
+		state(t, peer, BreakerStateClosed)
+	})
+}
@@ -0,0 +1,208 @@
+package peer
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+)
+
+// HealthPolicy defines how often a peer is checked and how many checks it
+// takes for a peer to change between healthy and unhealthy.
+type HealthPolicy struct {
+	// Interval between each check, where zero disables checking.
+	Interval time.Duration
+	Timeout  time.Duration
+
+	// HealthyThreshold is the amount of consecutive successful checks before
+	// an unhealthy peer is healthy again.
+	HealthyThreshold int
+
+	// UnhealthyThreshold is the amount of consecutive failed checks before a
+	// healthy peer is marked as unhealthy.
+	UnhealthyThreshold int
+}
+
+// DefaultHealthPolicy checks every peer every 5 seconds.
+func DefaultHealthPolicy() HealthPolicy {
+	return HealthPolicy{
+		Interval:           5 * time.Second,
+		Timeout:            time.Second,
+		HealthyThreshold:   2,
+		UnhealthyThreshold: 3,
+	}
+}
+
+// Validate the HealthPolicy, to make sure that it's usable.
+func (p HealthPolicy) Validate() error {
+	switch {
+	case p.Interval < 0:
+		return errors.New("health interval must not be negative")
+	case p.Timeout < 0:
+		return errors.New("health timeout must not be negative")
+	case p.HealthyThreshold < 1:
+		return errors.New("health healthy threshold must be at least 1")
+	case p.UnhealthyThreshold < 1:
+		return errors.New("health unhealthy threshold must be at least 1")
+	}
+	return nil
+}
+
+// Health describes the outcome of the health checks of a peer. A peer is
+// healthy until it's proven otherwise.
+type Health struct {
+	Healthy   bool      `json:"healthy"`
+	Successes int       `json:"successes"`
+	Failures  int       `json:"failures"`
+	LastCheck time.Time `json:"last_check"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Health returns the current health of the peer.
+func (p *Peer) Health() Health {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.health
+}
+
+// Healthy defines if the peer is currently healthy.
+func (p *Peer) Healthy() bool {
+	return p.Health().Healthy
+}
+
+// Check the health of the peer, returning an error if the peer isn't healthy.
+func (p *Peer) Check(ctx context.Context) error {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s://%s/health", p.network, p.addr), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// observe the outcome of a health check, returning true if the peer changed
+// between healthy and unhealthy.
+func (p *Peer) observe(err error, policy HealthPolicy) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	previous := p.health.Healthy
+
+	p.health.LastCheck = time.Now()
+	if err != nil {
+		p.health.Successes = 0
+		p.health.Failures++
+		p.health.Error = err.Error()
+		if p.health.Failures >= policy.UnhealthyThreshold {
+			p.health.Healthy = false
+		}
+	} else {
+		p.health.Failures = 0
+		p.health.Successes++
+		p.health.Error = ""
+		if p.health.Successes >= policy.HealthyThreshold {
+			p.health.Healthy = true
+		}
+	}
+
+	return previous != p.health.Healthy
+}
+
+// Checker periodically checks the health of each peer with in the set.
+type Checker struct {
+	peers  *Set
+	policy HealthPolicy
+	logger log.Logger
+	stop   chan chan struct{}
+}
+
+// NewChecker creates a new Checker for the set of peers, using the policy.
+func NewChecker(peers *Set, policy HealthPolicy, logger log.Logger) *Checker {
+	return &Checker{
+		peers:  peers,
+		policy: policy,
+		logger: logger,
+		stop:   make(chan chan struct{}),
+	}
+}
+
+// Run the checker, until it's stopped.
+func (c *Checker) Run() {
+	if c.policy.Interval <= 0 {
+		q := <-c.stop
+		close(q)
+		return
+	}
+
+	step := time.NewTicker(c.policy.Interval)
+	defer step.Stop()
+
+	for {
+		select {
+		case <-step.C:
+			c.CheckAll()
+
+		case q := <-c.stop:
+			close(q)
+			return
+		}
+	}
+}
+
+// Stop the checker.
+func (c *Checker) Stop() {
+	q := make(chan struct{})
+	c.stop <- q
+	<-q
+}
+
+// CheckAll the peers at once, waiting for all the checks to finish.
+func (c *Checker) CheckAll() {
+	var wg sync.WaitGroup
+	for _, p := range c.peers.Peers() {
+		wg.Add(1)
+		go func(p *Peer) {
+			defer wg.Done()
+			c.check(p)
+		}(p)
+	}
+	wg.Wait()
+}
+
+func (c *Checker) check(p *Peer) {
+	ctx := context.Background()
+	if c.policy.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.policy.Timeout)
+		defer cancel()
+	}
+
+	err := p.Check(ctx)
+	if !p.observe(err, c.policy) {
+		if err != nil {
+			level.Debug(c.logger).Log("peer", p.Addr(), "err", err)
+		}
+		return
+	}
+
+	if p.Healthy() {
+		level.Info(c.logger).Log("peer", p.Addr(), "health", "healthy")
+	} else {
+		level.Warn(c.logger).Log("peer", p.Addr(), "health", "unhealthy", "err", err)
+	}
+}
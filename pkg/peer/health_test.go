@@ -0,0 +1,150 @@
+package peer
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+)
+
+func TestHealthPolicy(t *testing.T) {
+	t.Parallel()
+
+	t.Run("default", func(t *testing.T) {
+		if err := DefaultHealthPolicy().Validate(); err != nil {
+			t.Errorf("expected: nil, actual: %v", err)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, fn := range []func(*HealthPolicy){
+			func(p *HealthPolicy) { p.Interval = -1 },
+			func(p *HealthPolicy) { p.Timeout = -1 },
+			func(p *HealthPolicy) { p.HealthyThreshold = 0 },
+			func(p *HealthPolicy) { p.UnhealthyThreshold = 0 },
+		} {
+			policy := DefaultHealthPolicy()
+			fn(&policy)
+			if err := policy.Validate(); err == nil {
+				t.Errorf("expected: error, actual: %v", err)
+			}
+		}
+	})
+}
+
+func TestPeerHealth(t *testing.T) {
+	t.Parallel()
+
+	var (
+		logger = log.NewNopLogger()
+		policy = HealthPolicy{
+			HealthyThreshold:   2,
+			UnhealthyThreshold: 3,
+		}
+	)
+
+	t.Run("healthy by default", func(t *testing.T) {
+		peer := NewPeer(http.DefaultClient, "http", "0.0.0.0:0", logger)
+		if !peer.Healthy() {
+			t.Errorf("expected: true, actual: %v", peer.Healthy())
+		}
+	})
+
+	t.Run("thresholds", func(t *testing.T) {
+		var (
+			peer = NewPeer(http.DefaultClient, "http", "0.0.0.0:0", logger)
+			bad  = errors.New("bad")
+		)
+
+		for i := 0; i < 2; i++ {
+			if peer.observe(bad, policy) || !peer.Healthy() {
+				t.Fatalf("%d: expected: healthy, actual: %v", i, peer.Health())
+			}
+		}
+		if !peer.observe(bad, policy) || peer.Healthy() {
+			t.Fatalf("expected: unhealthy, actual: %v", peer.Health())
+		}
+		if expected, actual := "bad", peer.Health().Error; expected != actual {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+
+		if peer.observe(nil, policy) || peer.Healthy() {
+			t.Fatalf("expected: unhealthy, actual: %v", peer.Health())
+		}
+		if !peer.observe(nil, policy) || !peer.Healthy() {
+			t.Fatalf("expected: healthy, actual: %v", peer.Health())
+		}
+		if expected, actual := 0, peer.Health().Failures; expected != actual {
+			t.Errorf("expected: %d, actual: %d", expected, actual)
+		}
+	})
+
+	t.Run("check", func(t *testing.T) {
+		var (
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/health" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			addr = strings.Replace(server.URL, "http://", "", 1)
+			peer = NewPeer(http.DefaultClient, "http", addr, logger)
+		)
+		defer server.Close()
+
+		if err := peer.Check(context.Background()); err != nil {
+			t.Errorf("expected: nil, actual: %v", err)
+		}
+	})
+}
+
+func TestChecker(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mutex   sync.Mutex
+		healthy = true
+		server  = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			if !healthy {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		logger  = log.NewNopLogger()
+		addr    = strings.Replace(server.URL, "http://", "", 1)
+		peer    = NewPeer(http.DefaultClient, "http", addr, logger)
+		checker = NewChecker(NewSet([]*Peer{peer}), HealthPolicy{
+			HealthyThreshold:   1,
+			UnhealthyThreshold: 1,
+		}, logger)
+	)
+	defer server.Close()
+
+	mutex.Lock()
+	healthy = false
+	mutex.Unlock()
+
+	checker.CheckAll()
+	if peer.Healthy() {
+		t.Errorf("expected: false, actual: %v", peer.Healthy())
+	}
+
+	mutex.Lock()
+	healthy = true
+	mutex.Unlock()
+
+	checker.CheckAll()
+	if !peer.Healthy() {
+		t.Errorf("expected: true, actual: %v", peer.Healthy())
+	}
+}
@@ -0,0 +1,155 @@
+package peer
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Labels describe a peer, so that work can be targeted at a subset of the
+// peers (e.g. zone=a,role=db).
+type Labels map[string]string
+
+// ParseLabels takes a comma separated list of key=value pairs and returns the
+// Labels.
+func ParseLabels(s string) (Labels, error) {
+	res := make(Labels)
+	if strings.TrimSpace(s) == "" {
+		return res, nil
+	}
+
+	for _, v := range strings.Split(s, ",") {
+		parts := strings.SplitN(strings.TrimSpace(v), "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.Errorf("invalid label %q", v)
+		}
+		if _, ok := res[parts[0]]; ok {
+			return nil, errors.Errorf("duplicate label %q", parts[0])
+		}
+		res[parts[0]] = parts[1]
+	}
+	return res, nil
+}
+
+// String returns the Labels as a comma separated list of key=value pairs,
+// ordered by key.
+func (l Labels) String() string {
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + "=" + l[k]
+	}
+	return strings.Join(parts, ",")
+}
+
+// Requirement of a Selector, which a label of a peer has to meet.
+type Requirement struct {
+	Key   string
+	Value string
+	Equal bool
+}
+
+// Matches defines if the labels meet the requirement. A label that's missing
+// never equals the value.
+func (r Requirement) Matches(l Labels) bool {
+	v, ok := l[r.Key]
+	return (ok && v == r.Value) == r.Equal
+}
+
+func (r Requirement) String() string {
+	if r.Equal {
+		return r.Key + "=" + r.Value
+	}
+	return r.Key + "!=" + r.Value
+}
+
+// Selector selects the peers with labels that meet every Requirement. An empty
+// Selector selects every peer.
+type Selector []Requirement
+
+// ParseSelector takes a comma separated list of key=value or key!=value
+// requirements and returns the Selector.
+func ParseSelector(s string) (Selector, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+
+	var res Selector
+	for _, v := range strings.Split(s, ",") {
+		var (
+			req   = Requirement{Equal: true}
+			parts = strings.SplitN(strings.TrimSpace(v), "=", 2)
+		)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("invalid selector %q", v)
+		}
+		if strings.HasSuffix(parts[0], "!") {
+			parts[0], req.Equal = strings.TrimSuffix(parts[0], "!"), false
+		}
+		if parts[0] == "" {
+			return nil, errors.Errorf("invalid selector %q", v)
+		}
+		req.Key, req.Value = parts[0], parts[1]
+		res = append(res, req)
+	}
+	return res, nil
+}
+
+// Empty defines if the Selector selects every peer.
+func (s Selector) Empty() bool {
+	return len(s) == 0
+}
+
+// Matches defines if the labels meet every requirement of the Selector.
+func (s Selector) Matches(l Labels) bool {
+	for _, v := range s {
+		if !v.Matches(l) {
+			return false
+		}
+	}
+	return true
+}
+
+// Select the peers that match the Selector, keeping the order of the peers.
+func (s Selector) Select(peers []*Peer) []*Peer {
+	if s.Empty() {
+		return peers
+	}
+
+	res := make([]*Peer, 0, len(peers))
+	for _, p := range peers {
+		if s.Matches(p.Labels()) {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+func (s Selector) String() string {
+	parts := make([]string, len(s))
+	for i, v := range s {
+		parts[i] = v.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+// MarshalText encodes the Selector in the same form that it's parsed from.
+func (s Selector) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText decodes the Selector from the form that it's parsed from.
+func (s *Selector) UnmarshalText(b []byte) error {
+	res, err := ParseSelector(string(b))
+	if err != nil {
+		return err
+	}
+	*s = res
+	return nil
+}
@@ -0,0 +1,140 @@
+package peer
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestLabels(t *testing.T) {
+	t.Parallel()
+
+	t.Run("parse", func(t *testing.T) {
+		labels, err := ParseLabels("zone=a, role=db")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := Labels{"zone": "a", "role": "db"}
+		if actual := labels; !reflect.DeepEqual(expected, actual) {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := "role=db,zone=a", labels.String(); expected != actual {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+	})
+
+	t.Run("parse empty", func(t *testing.T) {
+		labels, err := ParseLabels("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := 0, len(labels); expected != actual {
+			t.Errorf("expected: %d, actual: %d", expected, actual)
+		}
+	})
+
+	t.Run("parse invalid", func(t *testing.T) {
+		for _, s := range []string{"zone", "=a", "zone=a,zone=b", "zone=a,"} {
+			if _, err := ParseLabels(s); err == nil {
+				t.Errorf("%s: expected: error, actual: %v", s, err)
+			}
+		}
+	})
+}
+
+func TestSelector(t *testing.T) {
+	t.Parallel()
+
+	t.Run("parse", func(t *testing.T) {
+		selector, err := ParseSelector("zone=a,role!=db")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := Selector{
+			{Key: "zone", Value: "a", Equal: true},
+			{Key: "role", Value: "db", Equal: false},
+		}
+		if actual := selector; !reflect.DeepEqual(expected, actual) {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := "zone=a,role!=db", selector.String(); expected != actual {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+	})
+
+	t.Run("parse invalid", func(t *testing.T) {
+		for _, s := range []string{"zone", "=a", "!=a", "zone=a,"} {
+			if _, err := ParseSelector(s); err == nil {
+				t.Errorf("%s: expected: error, actual: %v", s, err)
+			}
+		}
+	})
+
+	t.Run("matches", func(t *testing.T) {
+		labels := Labels{"zone": "a", "role": "db"}
+		for s, expected := range map[string]bool{
+			"":                true,
+			"zone=a":          true,
+			"zone=b":          false,
+			"zone=a,role=db":  true,
+			"zone=a,role!=db": false,
+			"rack!=1":         true,
+			"rack=":           false,
+		} {
+			selector, err := ParseSelector(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := selector.Matches(labels); expected != actual {
+				t.Errorf("%s: expected: %t, actual: %t", s, expected, actual)
+			}
+		}
+	})
+
+	t.Run("select", func(t *testing.T) {
+		logger := log.NewNopLogger()
+
+		peers := make([]*Peer, 3)
+		for k, zone := range []string{"a", "b", "a"} {
+			peers[k] = NewPeer(http.DefaultClient, "http", "0.0.0.0:0", logger)
+			peers[k].SetLabels(Labels{"zone": zone})
+		}
+
+		selector, err := ParseSelector("zone=a")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []*Peer{peers[0], peers[2]}
+		if actual := selector.Select(peers); !reflect.DeepEqual(expected, actual) {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := 3, len(Selector(nil).Select(peers)); expected != actual {
+			t.Errorf("expected: %d, actual: %d", expected, actual)
+		}
+	})
+
+	t.Run("text", func(t *testing.T) {
+		selector, err := ParseSelector("zone=a,role!=db")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := selector.MarshalText()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var actual Selector
+		if err := actual.UnmarshalText(b); err != nil {
+			t.Fatal(err)
+		}
+		if expected := selector; !reflect.DeepEqual(expected, actual) {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+}
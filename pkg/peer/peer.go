@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"sync"
+	"time"
 
 	"github.com/go-kit/kit/log"
 )
@@ -15,6 +17,14 @@ type Peer struct {
 	network string
 	addr    string
 	logger  log.Logger
+
+	mutex  sync.Mutex
+	health Health
+	labels Labels
+	stats  Stats
+
+	latencies window
+	circuit   circuit
 }
 
 // NewPeer creates a new peer using a resuable client.
@@ -24,18 +34,51 @@ func NewPeer(client *http.Client, network, addr string, logger log.Logger) *Peer
 		network: network,
 		addr:    addr,
 		logger:  logger,
+		mutex:   sync.Mutex{},
+		health:  Health{Healthy: true},
+	}
+}
+
+// Addr returns the address of the peer.
+func (p *Peer) Addr() string {
+	return p.addr
+}
+
+// Labels returns the labels that describe the peer.
+func (p *Peer) Labels() Labels {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	res := make(Labels, len(p.labels))
+	for k, v := range p.labels {
+		res[k] = v
 	}
+	return res
+}
+
+// SetLabels allows the updating of the labels, before the peer is used.
+func (p *Peer) SetLabels(l Labels) {
+	p.mutex.Lock()
+	p.labels = l
+	p.mutex.Unlock()
 }
 
 // NewRequest creates a new request ready to send to the client.
 func (p *Peer) NewRequest(info string) (*Request, error) {
+	return p.NewRequestContext(context.Background(), info)
+}
+
+// NewRequestContext creates a new request ready to send to the client, which
+// is cancelled when the context is done.
+func (p *Peer) NewRequestContext(ctx context.Context, info string) (*Request, error) {
 	req, err := http.NewRequest("GET", fmt.Sprintf("%s://%s/update?info=%s", p.network, p.addr, info), nil)
 	if err != nil {
 		return nil, err
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(ctx)
 	return &Request{
+		peer:    p,
 		request: req.WithContext(ctx),
 		client:  p.client,
 		cancel:  cancel,
@@ -44,14 +87,21 @@ func (p *Peer) NewRequest(info string) (*Request, error) {
 
 // Request encapsulates a way to do the request on the chosen peer.
 type Request struct {
+	peer    *Peer
 	request *http.Request
 	client  *http.Client
 	cancel  context.CancelFunc
 }
 
-// Do the peer request and wait for the response.
+// Do the peer request and wait for the response, which is tracked in the
+// stats of the peer.
 func (r *Request) Do() (*http.Response, error) {
-	return r.client.Do(r.request)
+	r.peer.begin()
+	begin := time.Now()
+
+	resp, err := r.client.Do(r.request)
+	r.peer.end(time.Since(begin), err)
+	return resp, err
 }
 
 // Cancel allows the cancelling of the peer request.
@@ -59,6 +109,12 @@ func (r *Request) Cancel() {
 	r.cancel()
 }
 
+// Err returns why the request was cancelled or timed out, which is nil
+// otherwise.
+func (r *Request) Err() error {
+	return r.request.Context().Err()
+}
+
 // URL returns the request URL, which is useful for debugging.
 func (r *Request) URL() *url.URL {
 	return r.request.URL
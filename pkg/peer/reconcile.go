@@ -0,0 +1,75 @@
+package peer
+
+import "sync"
+
+// Reconciler keeps the members of a Set in line with a list of discovered
+// addresses. Only the members that were added by the Reconciler are ever
+// removed, so any other members of the Set are left alone.
+type Reconciler struct {
+	mutex   sync.Mutex
+	set     *Set
+	known   map[string]struct{}
+	newPeer func(addr string) *Peer
+}
+
+// NewReconciler creates a Reconciler for the Set, which uses the newPeer
+// function to create any newly discovered members.
+func NewReconciler(set *Set, newPeer func(addr string) *Peer) *Reconciler {
+	return &Reconciler{
+		mutex:   sync.Mutex{},
+		set:     set,
+		known:   make(map[string]struct{}),
+		newPeer: newPeer,
+	}
+}
+
+// Reconcile the Set with the discovered addresses, returning the addresses
+// that were added and removed.
+func (r *Reconciler) Reconcile(addrs []string) (added, removed []string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	discovered := make(map[string]struct{}, len(addrs))
+	for _, addr := range addrs {
+		discovered[addr] = struct{}{}
+		if _, ok := r.known[addr]; ok {
+			continue
+		}
+
+		// Members that already exist are owned by something else.
+		if err := r.set.Add(r.newPeer(addr)); err != nil {
+			continue
+		}
+		r.known[addr] = struct{}{}
+		added = append(added, addr)
+	}
+
+	for addr := range r.known {
+		if _, ok := discovered[addr]; ok {
+			continue
+		}
+
+		delete(r.known, addr)
+		if err := r.set.Remove(addr); err != nil {
+			continue
+		}
+		removed = append(removed, addr)
+	}
+	return added, removed
+}
+
+// Relabel the members that were added by the Reconciler, using the labels of
+// their addresses.
+func (r *Reconciler) Relabel(labels map[string]Labels) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, p := range r.set.Peers() {
+		if _, ok := r.known[p.Addr()]; !ok {
+			continue
+		}
+		if l, ok := labels[p.Addr()]; ok {
+			p.SetLabels(l)
+		}
+	}
+}
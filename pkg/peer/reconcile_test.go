@@ -0,0 +1,84 @@
+package peer
+
+import (
+	"net/http"
+	"sort"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestReconciler(t *testing.T) {
+	t.Parallel()
+
+	var (
+		logger  = log.NewNopLogger()
+		newPeer = func(addr string) *Peer {
+			return NewPeer(http.DefaultClient, "http", addr, logger)
+		}
+		addrs = func(peers []*Peer) []string {
+			res := make([]string, len(peers))
+			for k, v := range peers {
+				res[k] = v.Addr()
+			}
+			sort.Strings(res)
+			return res
+		}
+		equal = func(a, b []string) bool {
+			if len(a) != len(b) {
+				return false
+			}
+			for k := range a {
+				if a[k] != b[k] {
+					return false
+				}
+			}
+			return true
+		}
+	)
+
+	var (
+		set        = NewSet([]*Peer{newPeer("static:1")})
+		reconciler = NewReconciler(set, newPeer)
+	)
+
+	added, removed := reconciler.Reconcile([]string{"a:1", "b:1", "static:1"})
+	if expected, actual := []string{"a:1", "b:1"}, added; !equal(expected, actual) {
+		t.Errorf("expected: %v, actual: %v", expected, actual)
+	}
+	if len(removed) != 0 {
+		t.Errorf("expected: [], actual: %v", removed)
+	}
+
+	added, removed = reconciler.Reconcile([]string{"b:1", "c:1"})
+	if expected, actual := []string{"c:1"}, added; !equal(expected, actual) {
+		t.Errorf("expected: %v, actual: %v", expected, actual)
+	}
+	if expected, actual := []string{"a:1"}, removed; !equal(expected, actual) {
+		t.Errorf("expected: %v, actual: %v", expected, actual)
+	}
+
+	// The static member is never removed, as it wasn't added by discovery.
+	if expected, actual := []string{"b:1", "c:1", "static:1"}, addrs(set.Peers()); !equal(expected, actual) {
+		t.Errorf("expected: %v, actual: %v", expected, actual)
+	}
+
+	// Only the members that were added by discovery are relabelled.
+	reconciler.Relabel(map[string]Labels{
+		"b:1":      {"zone": "a"},
+		"static:1": {"zone": "b"},
+	})
+	for _, v := range set.Peers() {
+		labels := v.Labels()
+		switch v.Addr() {
+		case "b:1":
+			if expected, actual := "a", labels["zone"]; expected != actual {
+				t.Errorf("expected: %s, actual: %s", expected, actual)
+			}
+		default:
+			if expected, actual := 0, len(labels); expected != actual {
+				t.Errorf("%s: expected: %d, actual: %d", v.Addr(), expected, actual)
+			}
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package peer
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// Registry keeps track of the peers that register themselves, expiring any
+// peer that fails to register again with in the TTL.
+type Registry struct {
+	mutex      sync.Mutex
+	seen       map[string]time.Time
+	ttl        time.Duration
+	reconciler *Reconciler
+	logger     log.Logger
+	stop       chan chan struct{}
+}
+
+// NewRegistry creates a Registry, which uses the Reconciler to keep the
+// members in line with the registered peers.
+func NewRegistry(reconciler *Reconciler, ttl time.Duration, logger log.Logger) *Registry {
+	return &Registry{
+		mutex:      sync.Mutex{},
+		seen:       make(map[string]time.Time),
+		ttl:        ttl,
+		reconciler: reconciler,
+		logger:     logger,
+		stop:       make(chan chan struct{}),
+	}
+}
+
+// Register a peer, or refresh the registration of the peer if it's already
+// registered.
+func (r *Registry) Register(addr string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	_, ok := r.seen[addr]
+	r.seen[addr] = time.Now()
+	if !ok {
+		r.reconcile()
+	}
+	return nil
+}
+
+// Deregister a peer, which removes the peer straight away.
+func (r *Registry) Deregister(addr string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.seen[addr]; !ok {
+		return ErrPeerNotFound
+	}
+
+	delete(r.seen, addr)
+	r.reconcile()
+	return nil
+}
+
+// Registered returns the addresses of the registered peers.
+func (r *Registry) Registered() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	res := make([]string, 0, len(r.seen))
+	for addr := range r.seen {
+		res = append(res, addr)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Expire any peer that hasn't registered with in the TTL, returning the
+// addresses of the expired peers.
+func (r *Registry) Expire() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var (
+		expired []string
+		now     = time.Now()
+	)
+	for addr, seen := range r.seen {
+		if now.Sub(seen) >= r.ttl {
+			delete(r.seen, addr)
+			expired = append(expired, addr)
+		}
+	}
+	if len(expired) > 0 {
+		r.reconcile()
+	}
+	sort.Strings(expired)
+	return expired
+}
+
+// Run the Registry, expiring peers until it's stopped.
+func (r *Registry) Run() {
+	step := time.NewTicker(r.ttl / 2)
+	defer step.Stop()
+
+	for {
+		select {
+		case <-step.C:
+			for _, addr := range r.Expire() {
+				level.Warn(r.logger).Log("peer", addr, "registration", "expired")
+			}
+
+		case q := <-r.stop:
+			close(q)
+			return
+		}
+	}
+}
+
+// Stop the Registry.
+func (r *Registry) Stop() {
+	q := make(chan struct{})
+	r.stop <- q
+	<-q
+}
+
+// reconcile must be called with the Registry locked.
+func (r *Registry) reconcile() {
+	addrs := make([]string, 0, len(r.seen))
+	for addr := range r.seen {
+		addrs = append(addrs, addr)
+	}
+
+	added, removed := r.reconciler.Reconcile(addrs)
+	for _, v := range added {
+		level.Info(r.logger).Log("peer", v, "membership", "added")
+	}
+	for _, v := range removed {
+		level.Info(r.logger).Log("peer", v, "membership", "removed")
+	}
+}
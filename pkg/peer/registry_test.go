@@ -0,0 +1,73 @@
+package peer
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestRegistry(t *testing.T) {
+	t.Parallel()
+
+	var (
+		logger  = log.NewNopLogger()
+		newPeer = func(addr string) *Peer {
+			return NewPeer(http.DefaultClient, "http", addr, logger)
+		}
+	)
+
+	t.Run("register", func(t *testing.T) {
+		var (
+			set      = NewSet(nil)
+			registry = NewRegistry(NewReconciler(set, newPeer), time.Minute, logger)
+		)
+		for i := 0; i < 2; i++ {
+			if err := registry.Register("a:1"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if expected, actual := 1, set.Len(); expected != actual {
+			t.Errorf("expected: %d, actual: %d", expected, actual)
+		}
+
+		if err := registry.Deregister("a:1"); err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := 0, set.Len(); expected != actual {
+			t.Errorf("expected: %d, actual: %d", expected, actual)
+		}
+		if expected, actual := ErrPeerNotFound, registry.Deregister("a:1"); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("expire", func(t *testing.T) {
+		var (
+			set      = NewSet(nil)
+			registry = NewRegistry(NewReconciler(set, newPeer), 20*time.Millisecond, logger)
+		)
+		if err := registry.Register("a:1"); err != nil {
+			t.Fatal(err)
+		}
+		if expired := registry.Expire(); len(expired) != 0 {
+			t.Errorf("expected: [], actual: %v", expired)
+		}
+
+		time.Sleep(30 * time.Millisecond)
+		if err := registry.Register("b:1"); err != nil {
+			t.Fatal(err)
+		}
+
+		if expected, actual := []string{"a:1"}, registry.Expire(); len(actual) != 1 || expected[0] != actual[0] {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := []string{"b:1"}, registry.Registered(); len(actual) != 1 || expected[0] != actual[0] {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := 1, set.Len(); expected != actual {
+			t.Errorf("expected: %d, actual: %d", expected, actual)
+		}
+	})
+}
@@ -0,0 +1,73 @@
+package peer
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// DefaultReplicas is the amount of virtual nodes each peer has on a Ring.
+const DefaultReplicas = 128
+
+// Ring maps keys onto peers using consistent hashing, where each peer is
+// placed onto the ring a number of times (virtual nodes) to spread the keys
+// evenly. Adding or removing a peer only moves the keys of that peer.
+type Ring struct {
+	hashes []uint64
+	peers  map[uint64]*Peer
+}
+
+// NewRing creates a Ring of the peers, with the amount of replicas (virtual
+// nodes) for each peer.
+func NewRing(peers []*Peer, replicas int) *Ring {
+	if replicas < 1 {
+		replicas = 1
+	}
+
+	r := &Ring{
+		hashes: make([]uint64, 0, len(peers)*replicas),
+		peers:  make(map[uint64]*Peer, len(peers)*replicas),
+	}
+	for _, p := range peers {
+		for i := 0; i < replicas; i++ {
+			h := hash(strconv.Itoa(i) + "-" + p.Addr())
+			// On the rare collision, the first peer keeps the virtual node.
+			if _, ok := r.peers[h]; ok {
+				continue
+			}
+			r.hashes = append(r.hashes, h)
+			r.peers[h] = p
+		}
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+	return r
+}
+
+// Get the peer that the key maps to, which is nil if the Ring is empty.
+func (r *Ring) Get(key string) *Peer {
+	if len(r.hashes) == 0 {
+		return nil
+	}
+
+	h := hash(key)
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.peers[r.hashes[i]]
+}
+
+// hash the key using FNV-1a, with a final mix of the bits so that similar
+// keys are spread around the ring.
+func hash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
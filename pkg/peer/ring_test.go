@@ -0,0 +1,120 @@
+package peer
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestRing(t *testing.T) {
+	t.Parallel()
+
+	logger := log.NewNopLogger()
+
+	newPeers := func(n int) []*Peer {
+		res := make([]*Peer, n)
+		for i := range res {
+			res[i] = NewPeer(http.DefaultClient, "http", fmt.Sprintf("10.0.0.%d:8080", i), logger)
+		}
+		return res
+	}
+
+	// assign every key to a peer address.
+	assign := func(ring *Ring, keys int) map[string]string {
+		res := make(map[string]string, keys)
+		for i := 0; i < keys; i++ {
+			key := fmt.Sprintf("key-%d", i)
+			res[key] = ring.Get(key).Addr()
+		}
+		return res
+	}
+
+	t.Run("empty", func(t *testing.T) {
+		if p := NewRing(nil, DefaultReplicas).Get("key"); p != nil {
+			t.Errorf("expected: nil, actual: %v", p)
+		}
+	})
+
+	t.Run("deterministic", func(t *testing.T) {
+		var (
+			peers    = newPeers(5)
+			reversed = []*Peer{peers[4], peers[3], peers[2], peers[1], peers[0]}
+			a        = assign(NewRing(peers, DefaultReplicas), 1000)
+			b        = assign(NewRing(reversed, DefaultReplicas), 1000)
+		)
+		for k, v := range a {
+			if b[k] != v {
+				t.Errorf("%s: expected: %s, actual: %s", k, v, b[k])
+			}
+		}
+	})
+
+	t.Run("distribution", func(t *testing.T) {
+		const (
+			peers = 10
+			keys  = 100000
+		)
+
+		counts := make(map[string]int)
+		for _, v := range assign(NewRing(newPeers(peers), DefaultReplicas), keys) {
+			counts[v]++
+		}
+		if expected, actual := peers, len(counts); expected != actual {
+			t.Fatalf("expected: %d, actual: %d", expected, actual)
+		}
+
+		// Every peer should be within a quarter of an even share of the keys.
+		mean := float64(keys) / peers
+		for addr, count := range counts {
+			if deviation := math.Abs(float64(count)-mean) / mean; deviation > 0.25 {
+				t.Errorf("%s: expected: %v keys (±25%%), actual: %d", addr, mean, count)
+			}
+		}
+	})
+
+	t.Run("add peer", func(t *testing.T) {
+		const keys = 10000
+
+		var (
+			peers  = newPeers(6)
+			before = assign(NewRing(peers[:5], DefaultReplicas), keys)
+			after  = assign(NewRing(peers, DefaultReplicas), keys)
+			moved  int
+		)
+		for k, v := range before {
+			if after[k] == v {
+				continue
+			}
+			moved++
+
+			// Keys only ever move to the new peer.
+			if expected, actual := peers[5].Addr(), after[k]; expected != actual {
+				t.Errorf("%s: expected: %s, actual: %s", k, expected, actual)
+			}
+		}
+
+		// Roughly a sixth of the keys move to the new peer.
+		if share := float64(moved) / keys; share < 1.0/6*0.75 || share > 1.0/6*1.25 {
+			t.Errorf("expected: %v of the keys to move, actual: %v", 1.0/6, share)
+		}
+	})
+
+	t.Run("remove peer", func(t *testing.T) {
+		const keys = 10000
+
+		var (
+			peers  = newPeers(5)
+			before = assign(NewRing(peers, DefaultReplicas), keys)
+			after  = assign(NewRing(peers[1:], DefaultReplicas), keys)
+		)
+		for k, v := range before {
+			// Only the keys of the removed peer move.
+			if moved := after[k] != v; moved != (v == peers[0].Addr()) {
+				t.Errorf("%s: expected: moved %t, actual: %s to %s", k, !moved, v, after[k])
+			}
+		}
+	})
+}
@@ -0,0 +1,132 @@
+package peer
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrPeerNotFound is returned by a Set when no peer matches the address.
+	ErrPeerNotFound = errors.New("no peer found")
+
+	// ErrPeerExists is returned by a Set when a peer with the same address is
+	// already a member.
+	ErrPeerExists = errors.New("peer already exists")
+)
+
+// Set holds the current membership of peers, which can be changed at runtime.
+// Each read returns a snapshot of the peers, so a change to the membership
+// never affects the peers that are already being used.
+type Set struct {
+	mutex    sync.RWMutex
+	peers    []*Peer
+	draining map[string]struct{}
+}
+
+// NewSet creates a new Set with the peers as the initial members.
+func NewSet(peers []*Peer) *Set {
+	res := make([]*Peer, len(peers))
+	copy(res, peers)
+	return &Set{
+		mutex:    sync.RWMutex{},
+		peers:    res,
+		draining: make(map[string]struct{}),
+	}
+}
+
+// Peers returns all the members, including any that are draining.
+func (s *Set) Peers() []*Peer {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	res := make([]*Peer, len(s.peers))
+	copy(res, s.peers)
+	return res
+}
+
+// Active returns the members that aren't draining.
+func (s *Set) Active() []*Peer {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	res := make([]*Peer, 0, len(s.peers))
+	for _, p := range s.peers {
+		if _, ok := s.draining[p.Addr()]; !ok {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// Len returns the amount of members, including any that are draining.
+func (s *Set) Len() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return len(s.peers)
+}
+
+// Draining defines if the member with the address is draining.
+func (s *Set) Draining(addr string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	_, ok := s.draining[addr]
+	return ok
+}
+
+// Add a new member to the set.
+func (s *Set) Add(p *Peer) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.find(p.Addr()) >= 0 {
+		return ErrPeerExists
+	}
+
+	s.peers = append(s.peers, p)
+	return nil
+}
+
+// Drain a member, so that no new work is sent to it. Work that's already been
+// sent to the member is left to finish.
+func (s *Set) Drain(addr string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.find(addr) < 0 {
+		return ErrPeerNotFound
+	}
+
+	s.draining[addr] = struct{}{}
+	return nil
+}
+
+// Remove a member from the set.
+func (s *Set) Remove(addr string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	pos := s.find(addr)
+	if pos < 0 {
+		return ErrPeerNotFound
+	}
+
+	// Make a new slice, so any previous snapshot isn't modified.
+	peers := make([]*Peer, 0, len(s.peers)-1)
+	peers = append(peers, s.peers[:pos]...)
+	s.peers = append(peers, s.peers[pos+1:]...)
+
+	delete(s.draining, addr)
+	return nil
+}
+
+func (s *Set) find(addr string) int {
+	for k, v := range s.peers {
+		if v.Addr() == addr {
+			return k
+		}
+	}
+	return -1
+}
@@ -0,0 +1,81 @@
+package peer
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestSet(t *testing.T) {
+	t.Parallel()
+
+	logger := log.NewNopLogger()
+
+	newPeer := func(addr string) *Peer {
+		return NewPeer(http.DefaultClient, "http", addr, logger)
+	}
+	addrs := func(peers []*Peer) []string {
+		res := make([]string, len(peers))
+		for k, v := range peers {
+			res[k] = v.Addr()
+		}
+		return res
+	}
+
+	t.Run("add", func(t *testing.T) {
+		set := NewSet([]*Peer{newPeer("a:1")})
+		if err := set.Add(newPeer("b:1")); err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := 2, set.Len(); expected != actual {
+			t.Errorf("expected: %d, actual: %d", expected, actual)
+		}
+		if expected, actual := ErrPeerExists, set.Add(newPeer("b:1")); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("drain", func(t *testing.T) {
+		set := NewSet([]*Peer{newPeer("a:1"), newPeer("b:1")})
+		if err := set.Drain("a:1"); err != nil {
+			t.Fatal(err)
+		}
+		if !set.Draining("a:1") {
+			t.Errorf("expected: true, actual: %v", set.Draining("a:1"))
+		}
+		if expected, actual := []string{"b:1"}, addrs(set.Active()); len(actual) != 1 || expected[0] != actual[0] {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := 2, len(set.Peers()); expected != actual {
+			t.Errorf("expected: %d, actual: %d", expected, actual)
+		}
+		if expected, actual := ErrPeerNotFound, set.Drain("c:1"); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		set := NewSet([]*Peer{newPeer("a:1"), newPeer("b:1"), newPeer("c:1")})
+		snapshot := set.Peers()
+
+		if err := set.Drain("b:1"); err != nil {
+			t.Fatal(err)
+		}
+		if err := set.Remove("b:1"); err != nil {
+			t.Fatal(err)
+		}
+		if set.Draining("b:1") {
+			t.Errorf("expected: false, actual: %v", set.Draining("b:1"))
+		}
+		if expected, actual := []string{"a:1", "c:1"}, addrs(set.Peers()); len(actual) != 2 || expected[0] != actual[0] || expected[1] != actual[1] {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := []string{"a:1", "b:1", "c:1"}, addrs(snapshot); len(actual) != 3 || expected[1] != actual[1] {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := ErrPeerNotFound, set.Remove("b:1"); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+}
@@ -0,0 +1,108 @@
+package peer
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// latencyWeight defines how much each request counts towards the moving
+	// average of the latency.
+	latencyWeight = 0.3
+
+	// latencyWindow is the amount of recent latencies that are kept for the
+	// percentiles, which need at least minLatencies to be meaningful.
+	latencyWindow = 100
+	minLatencies  = 10
+)
+
+// Stats describe the requests that are sent to a peer.
+type Stats struct {
+	// Outstanding is the amount of requests that are waiting on a response.
+	Outstanding int `json:"outstanding"`
+
+	// Latency is the exponentially weighted moving average of how long the
+	// peer takes to respond, which is zero until the peer first responds.
+	Latency time.Duration `json:"latency"`
+}
+
+// Stats returns the current stats of the requests sent to the peer.
+func (p *Peer) Stats() Stats {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.stats
+}
+
+// begin tracks a request that's waiting on a response.
+func (p *Peer) begin() {
+	p.mutex.Lock()
+	p.stats.Outstanding++
+	p.mutex.Unlock()
+}
+
+// end tracks a request that's finished, where the latency is only observed if
+// the peer responded.
+func (p *Peer) end(latency time.Duration, err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.stats.Outstanding--
+	if err != nil {
+		return
+	}
+
+	p.latencies.add(latency)
+
+	if p.stats.Latency == 0 {
+		p.stats.Latency = latency
+		return
+	}
+	p.stats.Latency += time.Duration(latencyWeight * float64(latency-p.stats.Latency))
+}
+
+// LatencyPercentile returns the percentile (between 0 and 100) of the recent
+// latencies of the peer, which is false until the peer has responded enough
+// times.
+func (p *Peer) LatencyPercentile(q float64) (time.Duration, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.latencies.percentile(q)
+}
+
+// window keeps the most recent latencies.
+type window struct {
+	samples []time.Duration
+	next    int
+}
+
+func (w *window) add(d time.Duration) {
+	if len(w.samples) < latencyWindow {
+		w.samples = append(w.samples, d)
+		return
+	}
+	w.samples[w.next] = d
+	w.next = (w.next + 1) % latencyWindow
+}
+
+// percentile uses the nearest rank of the sorted latencies.
+func (w *window) percentile(q float64) (time.Duration, bool) {
+	if len(w.samples) < minLatencies {
+		return 0, false
+	}
+
+	sorted := make([]time.Duration, len(w.samples))
+	copy(sorted, w.samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	rank := int(math.Ceil(q / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1], true
+}
@@ -0,0 +1,119 @@
+package peer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestStats(t *testing.T) {
+	t.Parallel()
+
+	logger := log.NewNopLogger()
+
+	t.Run("request", func(t *testing.T) {
+		var (
+			received = make(chan struct{})
+			release  = make(chan struct{})
+			server   = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				close(received)
+				<-release
+				w.WriteHeader(http.StatusOK)
+			}))
+		)
+		defer server.Close()
+
+		peer := NewPeer(http.DefaultClient, "http", strings.Replace(server.URL, "http://", "", 1), logger)
+		req, err := peer.NewRequest("info")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			if resp, err := req.Do(); err == nil {
+				resp.Body.Close()
+			}
+		}()
+
+		<-received
+		if expected, actual := 1, peer.Stats().Outstanding; expected != actual {
+			t.Errorf("expected: %d, actual: %d", expected, actual)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+		<-done
+
+		stats := peer.Stats()
+		if expected, actual := 0, stats.Outstanding; expected != actual {
+			t.Errorf("expected: %d, actual: %d", expected, actual)
+		}
+		if stats.Latency < 10*time.Millisecond {
+			t.Errorf("expected: latency of at least 10ms, actual: %v", stats.Latency)
+		}
+	})
+
+	t.Run("failed request", func(t *testing.T) {
+		peer := NewPeer(http.DefaultClient, "http", "0.0.0.0:0", logger)
+		req, err := peer.NewRequest("info")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := req.Do(); err == nil {
+			t.Fatal("expected: error, actual: nil")
+		}
+
+		if expected, actual := (Stats{}), peer.Stats(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("percentile", func(t *testing.T) {
+		peer := NewPeer(http.DefaultClient, "http", "0.0.0.0:0", logger)
+		for i := 1; i < minLatencies; i++ {
+			peer.begin()
+			peer.end(time.Duration(i)*time.Millisecond, nil)
+		}
+		if _, ok := peer.LatencyPercentile(50); ok {
+			t.Errorf("expected: not enough latencies")
+		}
+
+		// Only the most recent latencies are kept.
+		for i := 1; i <= latencyWindow; i++ {
+			peer.begin()
+			peer.end(time.Duration(i)*time.Second, nil)
+		}
+		for q, expected := range map[float64]time.Duration{
+			0:   time.Second,
+			50:  50 * time.Second,
+			95:  95 * time.Second,
+			100: 100 * time.Second,
+		} {
+			actual, ok := peer.LatencyPercentile(q)
+			if !ok {
+				t.Fatalf("expected: enough latencies")
+			}
+			if expected != actual {
+				t.Errorf("p%v: expected: %v, actual: %v", q, expected, actual)
+			}
+		}
+	})
+
+	t.Run("moving average", func(t *testing.T) {
+		peer := NewPeer(http.DefaultClient, "http", "0.0.0.0:0", logger)
+		for _, latency := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond} {
+			peer.begin()
+			peer.end(latency, nil)
+		}
+
+		if expected, actual := 130*time.Millisecond, peer.Stats().Latency; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+}
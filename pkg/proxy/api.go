@@ -1,36 +1,95 @@
 package proxy
 
 import (
+	"fmt"
+	"net"
 	"net/http"
+	"net/url"
+	"sync"
 	"time"
 
+	"github.com/SimonRichardson/cmdproxy/pkg/leader"
+	"github.com/SimonRichardson/cmdproxy/pkg/peer"
 	"github.com/SimonRichardson/cmdproxy/pkg/scheduler"
 	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
 )
 
+const defaultKeepAlive = 15 * time.Second
+
 // These are the proxy API URL paths.
 const (
 	APIPathRunQuery    = "/run"
 	APIPathStatusQuery = "/status"
 	APIPathKillQuery   = "/kill"
+	APIPathResultQuery = "/results"
+	APIPathTasksQuery  = "/tasks"
+	APIPathEventsQuery = "/events"
+	APIPathWaitQuery   = "/wait"
+	APIPathPeersQuery  = "/peers"
+	APIPathLeaderQuery = "/leader"
+
+	APIPathRegisterQuery   = "/register"
+	APIPathDeregisterQuery = "/deregister"
+
+	APIPathAdminPeersAddQuery    = "/admin/peers/add"
+	APIPathAdminPeersDrainQuery  = "/admin/peers/drain"
+	APIPathAdminPeersRemoveQuery = "/admin/peers/remove"
 )
 
+// TaskDefaults are used for the task parameters that aren't supplied when
+// running a task.
+type TaskDefaults struct {
+	Retry   scheduler.RetryPolicy
+	Rolling scheduler.RollingPolicy
+	Canary  scheduler.CanaryPolicy
+	Hedge   scheduler.HedgePolicy
+
+	// RequestTimeout and TaskDeadline are disabled when zero.
+	RequestTimeout time.Duration
+	TaskDeadline   time.Duration
+}
+
+// DefaultTaskDefaults returns the TaskDefaults that the scheduler would use.
+func DefaultTaskDefaults() TaskDefaults {
+	return TaskDefaults{
+		Retry:   scheduler.DefaultRetryPolicy(),
+		Rolling: scheduler.DefaultRollingPolicy(),
+		Canary:  scheduler.DefaultCanaryPolicy(),
+	}
+}
+
 // API serves the proxy API
 type API struct {
 	scheduler *scheduler.Scheduler
+	registry  *peer.Registry
+	election  *leader.Election
+	defaults  TaskDefaults
 	logger    log.Logger
+	stop      chan struct{}
+	closeOnce sync.Once
 }
 
 // NewAPI creates a API with the correct dependencies.
-func NewAPI(scheduler *scheduler.Scheduler, logger log.Logger) *API {
+// When the registry is nil, agents can't register themselves.
+// When the election isn't nil and the proxy isn't leading, the requests that
+// change the tasks, or wait on the tasks changing, are forwarded to the leader.
+func NewAPI(scheduler *scheduler.Scheduler, registry *peer.Registry, election *leader.Election, defaults TaskDefaults, logger log.Logger) *API {
 	return &API{
 		scheduler: scheduler,
+		registry:  registry,
+		election:  election,
+		defaults:  defaults,
 		logger:    logger,
+		stop:      make(chan struct{}),
 	}
 }
 
-// Close out the API
+// Close out the API, which also ends any event streams.
 func (a *API) Close() error {
+	a.closeOnce.Do(func() {
+		close(a.stop)
+	})
 	return nil
 }
 
@@ -41,11 +100,45 @@ func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	method, path := r.Method, r.URL.Path
 	switch {
 	case method == "GET" && path == APIPathRunQuery:
-		a.handleRunQuery(w, r)
+		a.forward(w, r, a.handleRunQuery)
 	case method == "GET" && path == APIPathStatusQuery:
 		a.handleStatusQuery(w, r)
 	case method == "GET" && path == APIPathKillQuery:
-		a.handleKillQuery(w, r)
+		a.forward(w, r, a.handleKillQuery)
+	case method == "GET" && path == APIPathResultQuery:
+		a.handleResultQuery(w, r)
+	case method == "GET" && path == APIPathTasksQuery:
+		a.handleTasksQuery(w, r)
+	case method == "GET" && path == APIPathEventsQuery:
+		a.forward(w, r, a.handleEventsQuery)
+	case method == "GET" && path == APIPathWaitQuery:
+		a.forward(w, r, a.handleWaitQuery)
+	case method == "GET" && path == APIPathPeersQuery:
+		a.handlePeersQuery(w, r)
+	case method == "GET" && path == APIPathLeaderQuery:
+		a.handleLeaderQuery(w, r)
+	case method == "POST" && path == APIPathRegisterQuery && a.registry != nil:
+		a.handleRegisterQuery(w, r, a.registry.Register)
+	case method == "POST" && path == APIPathDeregisterQuery && a.registry != nil:
+		a.handleRegisterQuery(w, r, a.registry.Deregister)
+	case method == "POST" && path == APIPathAdminPeersAddQuery:
+		a.handleAdminPeersQuery(w, r, func(addr string) error {
+			return a.scheduler.Membership().Add(peer.NewPeer(
+				http.DefaultClient,
+				"http",
+				addr,
+				log.With(a.logger, "component", "peer"),
+			))
+		})
+	case method == "POST" && path == APIPathAdminPeersDrainQuery:
+		a.handleAdminPeersQuery(w, r, a.scheduler.Membership().Drain)
+	case method == "POST" && path == APIPathAdminPeersRemoveQuery:
+		a.handleAdminPeersQuery(w, r, a.scheduler.Membership().Remove)
+	case path == APIPathAdminPeersAddQuery,
+		path == APIPathAdminPeersDrainQuery,
+		path == APIPathAdminPeersRemoveQuery:
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
 	default:
 		http.NotFound(w, r)
 	}
@@ -56,13 +149,38 @@ func (a *API) handleRunQuery(w http.ResponseWriter, r *http.Request) {
 	begin := time.Now()
 
 	// Valdiate user input.
-	var qp RunQueryParams
+	qp := RunQueryParams{
+		Retry:          a.defaults.Retry,
+		Rolling:        a.defaults.Rolling,
+		Canary:         a.defaults.Canary,
+		Hedge:          a.defaults.Hedge,
+		RequestTimeout: scheduler.Duration(a.defaults.RequestTimeout),
+		TaskDeadline:   scheduler.Duration(a.defaults.TaskDeadline),
+	}
 	if err := qp.DecodeFrom(r.URL, queryRequired); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if err := qp.Retry.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := qp.Rolling.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := qp.Canary.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := qp.Hedge.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	if qp.ClientID < 0 || qp.ClientID >= len(a.scheduler.Peers()) {
+	// Draining peers don't take any new tasks, so they're left out.
+	active := a.scheduler.Membership().Active()
+	if qp.ClientID < 0 || qp.ClientID >= len(active) {
 		http.Error(w, "Invalid client ID.", http.StatusBadRequest)
 		return
 	}
@@ -73,17 +191,43 @@ func (a *API) handleRunQuery(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if qp.Quorum > len(active) {
+		http.Error(w, "Invalid quorum.", http.StatusBadRequest)
+		return
+	}
+
+	if !qp.Selector.Empty() && len(qp.Selector.Select(active)) == 0 {
+		http.Error(w, "Selector matches no agents.", http.StatusBadRequest)
+		return
+	}
+
 	// Write the information to the peers.
 	task := scheduler.NewTask(modeType, qp.ClientID, qp.Info, qp.FailOnError)
-	a.scheduler.Register(task)
+	task.SetRetryPolicy(qp.Retry)
+	task.SetRequestTimeout(time.Duration(qp.RequestTimeout))
+	task.SetDeadline(time.Duration(qp.TaskDeadline))
+	task.SetQuorum(qp.Quorum)
+	task.SetRollingPolicy(qp.Rolling)
+	task.SetCanaryPolicy(qp.Canary)
+	task.SetSelector(qp.Selector)
+	task.SetKey(qp.Key)
+	task.SetHedgePolicy(qp.Hedge)
+	if qp.Balance != "" {
+		task.SetBalance(qp.Balance)
+	}
+	if err := a.scheduler.Register(task); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	// We'll collect responese into a single RunQueryResult
 	qr := RunQueryResult{Params: qp}
+	qr.Task = newTaskResult(task)
 	qr.Records = task.ID()
 
 	// Finish
 	qr.Duration = time.Since(begin).String()
-	qr.EncodeTo(w)
+	qr.EncodeTo(w, negotiateFormat(r))
 }
 
 func (a *API) handleStatusQuery(w http.ResponseWriter, r *http.Request) {
@@ -105,11 +249,12 @@ func (a *API) handleStatusQuery(w http.ResponseWriter, r *http.Request) {
 
 	// We'll collect responese into a single QueryResult
 	qr := QueryResult{Params: qp}
+	qr.Task = newTaskResult(task)
 	qr.Records = string(task.Status())
 
 	// Finish
 	qr.Duration = time.Since(begin).String()
-	qr.EncodeTo(w)
+	qr.EncodeTo(w, negotiateFormat(r))
 }
 
 func (a *API) handleKillQuery(w http.ResponseWriter, r *http.Request) {
@@ -134,11 +279,392 @@ func (a *API) handleKillQuery(w http.ResponseWriter, r *http.Request) {
 
 	// We'll collect responese into a single QueryResult
 	qr := QueryResult{Params: qp}
+	qr.Task = newTaskResult(task)
 	qr.Records = string(task.Status())
 
 	// Finish
 	qr.Duration = time.Since(begin).String()
-	qr.EncodeTo(w)
+	qr.EncodeTo(w, negotiateFormat(r))
+}
+
+func (a *API) handleWaitQuery(w http.ResponseWriter, r *http.Request) {
+	// useful metrics
+	begin := time.Now()
+
+	// Valdiate user input.
+	var qp WaitQueryParams
+	if err := qp.DecodeFrom(r.URL, queryRequired); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	task, ok := a.scheduler.Get(qp.TaskID)
+	if !ok {
+		http.Error(w, "no task found", http.StatusNotFound)
+		return
+	}
+
+	// Block until the task has finished, or we've waited long enough.
+	timeout := time.NewTimer(time.Duration(qp.Timeout))
+	defer timeout.Stop()
+
+	select {
+	case <-task.Done():
+	case <-timeout.C:
+	case <-r.Context().Done():
+		return
+	case <-a.stop:
+	}
+
+	// We'll collect responese into a single WaitQueryResult
+	qr := WaitQueryResult{Params: qp}
+	qr.Task = newTaskResult(task)
+	qr.Done = scheduler.TaskStatusType(qr.Task.Status).Terminal()
+	qr.Records = qr.Task.Status
+
+	// Finish
+	qr.Duration = time.Since(begin).String()
+	qr.EncodeTo(w, negotiateFormat(r))
+}
+
+func (a *API) handleResultQuery(w http.ResponseWriter, r *http.Request) {
+	// useful metrics
+	begin := time.Now()
+
+	// Valdiate user input.
+	var qp QueryParams
+	if err := qp.DecodeFrom(r.URL, queryRequired); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	task, ok := a.scheduler.Get(qp.TaskID)
+	if !ok {
+		http.Error(w, "no task found", http.StatusNotFound)
+		return
+	}
+
+	// We'll collect responese into a single ResultQueryResult
+	qr := ResultQueryResult{Params: qp}
+	qr.Records = task.Attempts()
+
+	// Finish
+	qr.Duration = time.Since(begin).String()
+	qr.EncodeTo(w, negotiateFormat(r))
+}
+
+func (a *API) handleTasksQuery(w http.ResponseWriter, r *http.Request) {
+	// useful metrics
+	begin := time.Now()
+
+	// Valdiate user input.
+	var qp TasksQueryParams
+	if err := qp.DecodeFrom(r.URL, queryOptional); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tasks, cursor, err := a.scheduler.Query(qp.TaskQuery())
+	if err == scheduler.ErrInvalidCursor {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// We'll collect responese into a single TasksQueryResult
+	qr := TasksQueryResult{Params: qp}
+	qr.Records = make([]TaskResult, len(tasks))
+	for k, v := range tasks {
+		qr.Records[k] = newTaskResult(v)
+	}
+	qr.Cursor = cursor
+
+	// Finish
+	qr.Duration = time.Since(begin).String()
+	qr.EncodeTo(w, negotiateFormat(r))
+}
+
+func (a *API) handlePeersQuery(w http.ResponseWriter, r *http.Request) {
+	// useful metrics
+	begin := time.Now()
+
+	// We'll collect responese into a single PeersQueryResult
+	qr := PeersQueryResult{}
+	qr.Records = a.peerResults()
+
+	// Finish
+	qr.Duration = time.Since(begin).String()
+	qr.EncodeTo(w, negotiateFormat(r))
+}
+
+func (a *API) handleLeaderQuery(w http.ResponseWriter, r *http.Request) {
+	// useful metrics
+	begin := time.Now()
+
+	// We'll collect responese into a single LeaderQueryResult
+	qr := LeaderQueryResult{Leading: true}
+	if a.election != nil {
+		qr.Leader = a.election.Leader()
+		qr.Candidate = a.election.Candidate()
+		qr.Leading = a.election.Leading()
+	}
+
+	// Finish
+	qr.Duration = time.Since(begin).String()
+	qr.EncodeTo(w, negotiateFormat(r))
+}
+
+// forward the request to the leader, unless the proxy is leading, in which
+// case the handler is used.
+func (a *API) forward(w http.ResponseWriter, r *http.Request, handler http.HandlerFunc) {
+	if a.election == nil || a.election.Leading() {
+		handler(w, r)
+		return
+	}
+
+	// Don't forward a request that's already been forwarded, as the proxies
+	// don't agree on who's leading yet.
+	leader := a.election.Leader()
+	if leader == "" || r.Header.Get(httpHeaderForwarded) != "" {
+		http.Error(w, "no leader", http.StatusServiceUnavailable)
+		return
+	}
+
+	u, err := url.Parse(leader)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	u.Path, u.RawQuery = r.URL.Path, r.URL.RawQuery
+
+	req, err := http.NewRequest(r.Method, u.String(), nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	req = req.WithContext(r.Context())
+	req.Header.Set("Accept", r.Header.Get("Accept"))
+	req.Header.Set(httpHeaderForwarded, a.election.Candidate())
+
+	level.Debug(a.logger).Log("path", r.URL.Path, "leader", leader)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(resp.StatusCode)
+
+	// Flush as the body is copied, so that streamed events aren't held back.
+	flusher, _ := w.(http.Flusher)
+	b := make([]byte, 32*1024)
+	for {
+		n, err := resp.Body.Read(b)
+		if n > 0 {
+			if _, err := w.Write(b[:n]); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// handleAdminPeersQuery changes the membership of the peers using the change
+// function, then returns the peers for the new membership.
+func (a *API) handleAdminPeersQuery(w http.ResponseWriter, r *http.Request, change func(string) error) {
+	// useful metrics
+	begin := time.Now()
+
+	// Valdiate user input.
+	var qp PeerQueryParams
+	if err := qp.DecodeFrom(r.URL, queryRequired); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch err := change(qp.Addr); err {
+	case nil:
+	case peer.ErrPeerNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case peer.ErrPeerExists:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	level.Info(a.logger).Log("path", r.URL.Path, "peer", qp.Addr)
+
+	// We'll collect responese into a single PeersQueryResult
+	qr := PeersQueryResult{}
+	qr.Records = a.peerResults()
+
+	// Finish
+	qr.Duration = time.Since(begin).String()
+	qr.EncodeTo(w, negotiateFormat(r))
+}
+
+// handleRegisterQuery changes the registration of an agent. Agents that are
+// bound to every interface are registered using the address that the request
+// came from.
+func (a *API) handleRegisterQuery(w http.ResponseWriter, r *http.Request, change func(string) error) {
+	// useful metrics
+	begin := time.Now()
+
+	// Valdiate user input.
+	var qp PeerQueryParams
+	if err := qp.DecodeFrom(r.URL, queryRequired); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	qp.Addr = remoteAddr(qp.Addr, r.RemoteAddr)
+
+	switch err := change(qp.Addr); err {
+	case nil:
+	case peer.ErrPeerNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	level.Debug(a.logger).Log("path", r.URL.Path, "peer", qp.Addr)
+
+	// We'll collect responese into a single PeersQueryResult
+	qr := PeersQueryResult{}
+	qr.Records = a.peerResults()
+
+	// Finish
+	qr.Duration = time.Since(begin).String()
+	qr.EncodeTo(w, negotiateFormat(r))
+}
+
+func (a *API) peerResults() []PeerResult {
+	var (
+		membership = a.scheduler.Membership()
+		peers      = membership.Peers()
+		res        = make([]PeerResult, len(peers))
+	)
+	for k, v := range peers {
+		res[k] = newPeerResult(v, membership.Draining(v.Addr()))
+	}
+	return res
+}
+
+func (a *API) handleEventsQuery(w http.ResponseWriter, r *http.Request) {
+	// Valdiate user input.
+	var qp QueryParams
+	if err := qp.DecodeFrom(r.URL, queryOptional); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	// Subscribe before looking at the task, so no events are missed.
+	events, cancel := a.scheduler.Subscribe(qp.TaskID)
+	defer cancel()
+
+	var task *scheduler.Task
+	if qp.TaskID != "" {
+		if task, ok = a.scheduler.Get(qp.TaskID); !ok {
+			http.Error(w, "no task found", http.StatusNotFound)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", mimeEventStream)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	// Send the current status of the task straight away, so the client knows
+	// where the task is up to.
+	if task != nil {
+		status := task.Status()
+		if err := encodeEvent(w, scheduler.Event{
+			Type:   scheduler.EventTypeStatus,
+			TaskID: task.ID(),
+			Status: status,
+			Time:   time.Now(),
+		}); err != nil {
+			return
+		}
+		flusher.Flush()
+
+		if status.Terminal() {
+			return
+		}
+	}
+
+	keepAlive := time.NewTicker(defaultKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := encodeEvent(w, e); err != nil {
+				level.Debug(a.logger).Log("err", err)
+				return
+			}
+			flusher.Flush()
+
+			// Once the task has finished, there is nothing else to stream.
+			if task != nil && e.Type == scheduler.EventTypeStatus && e.Status.Terminal() {
+				return
+			}
+
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case <-r.Context().Done():
+			return
+
+		case <-a.stop:
+			return
+		}
+	}
+}
+
+// remoteAddr replaces an unspecified host (i.e. 0.0.0.0 or ::) of the address
+// with the host of the remote address.
+func remoteAddr(addr, remote string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if ip := net.ParseIP(host); host != "" && (ip == nil || !ip.IsUnspecified()) {
+		return addr
+	}
+
+	remoteHost, _, err := net.SplitHostPort(remote)
+	if err != nil {
+		return addr
+	}
+	return net.JoinHostPort(remoteHost, port)
 }
 
 type interceptingWriter struct {
@@ -150,3 +676,9 @@ func (iw *interceptingWriter) WriteHeader(code int) {
 	iw.code = code
 	iw.ResponseWriter.WriteHeader(code)
 }
+
+func (iw *interceptingWriter) Flush() {
+	if flusher, ok := iw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
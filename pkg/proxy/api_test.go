@@ -1,27 +1,43 @@
 package proxy
 
 import (
+	"bufio"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"testing/quick"
+	"time"
 
 	"io/ioutil"
 
+	"github.com/SimonRichardson/cmdproxy/pkg/leader"
 	"github.com/SimonRichardson/cmdproxy/pkg/peer"
 	"github.com/SimonRichardson/cmdproxy/pkg/scheduler"
 	"github.com/SimonRichardson/cmdproxy/pkg/test"
 	"github.com/go-kit/kit/log"
 )
 
+// event mirrors the JSON of a scheduler.Event.
+type event struct {
+	TaskID string `json:"task_id"`
+	Status string `json:"status"`
+}
+
 func TestAPI(t *testing.T) {
 	var (
 		logger    = log.NewNopLogger()
 		scheduler = scheduler.NewScheduler([]*peer.Peer{
 			peer.NewPeer(http.DefaultClient, "tcp", "0.0.0.0:0", logger),
-		}, logger)
-		api    = NewAPI(scheduler, logger)
+		}, scheduler.NewMemoryTaskStore(), 1, logger)
+		registry = peer.NewRegistry(peer.NewReconciler(scheduler.Membership(), func(addr string) *peer.Peer {
+			return peer.NewPeer(http.DefaultClient, "http", addr, logger)
+		}), time.Minute, logger)
+		api    = NewAPI(scheduler, registry, nil, DefaultTaskDefaults(), logger)
 		server = httptest.NewServer(api)
 		url    = server.URL
 	)
@@ -41,6 +57,52 @@ func TestAPI(t *testing.T) {
 		}
 	})
 
+	t.Run("run quorum", func(t *testing.T) {
+		for query, code := range map[string]int{
+			"quorum=1": http.StatusOK,
+			"quorum=2": http.StatusBadRequest,
+		} {
+			resp, err := http.Get(fmt.Sprintf("%s/run?client_id=0&info=a&mode=quorum&%s", url, query))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected, actual := code, resp.StatusCode; expected != actual {
+				t.Errorf("%s: expected: %d, actual: %d", query, expected, actual)
+			}
+		}
+	})
+
+	t.Run("run canary", func(t *testing.T) {
+		for query, code := range map[string]int{
+			"canary.fraction=0.5": http.StatusOK,
+			"canary.fraction=2":   http.StatusBadRequest,
+			"canary.interval=0s":  http.StatusBadRequest,
+		} {
+			resp, err := http.Get(fmt.Sprintf("%s/run?client_id=0&info=a&mode=canary&%s", url, query))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected, actual := code, resp.StatusCode; expected != actual {
+				t.Errorf("%s: expected: %d, actual: %d", query, expected, actual)
+			}
+		}
+	})
+
+	t.Run("run selector", func(t *testing.T) {
+		for query, code := range map[string]int{
+			"selector=zone!%3Da": http.StatusOK,
+			"selector=zone%3Da":  http.StatusBadRequest,
+		} {
+			resp, err := http.Get(fmt.Sprintf("%s/run?client_id=0&info=a&mode=parallel&%s", url, query))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected, actual := code, resp.StatusCode; expected != actual {
+				t.Errorf("%s: expected: %d, actual: %d", query, expected, actual)
+			}
+		}
+	})
+
 	t.Run("status", func(t *testing.T) {
 		fn := func(s test.ASCII) bool {
 			resp, err := http.Get(fmt.Sprintf("%s/run?client_id=0&info=%s&mode=parallel&failonerror=false", url, s.String()))
@@ -101,6 +163,434 @@ func TestAPI(t *testing.T) {
 		}
 	})
 
+	t.Run("results", func(t *testing.T) {
+		resp, err := http.Get(fmt.Sprintf("%s/run?client_id=0&info=hello&mode=parallel&failonerror=false", url))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		bytes, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		taskID := string(bytes)
+		resp, err = http.Get(fmt.Sprintf("%s/results?task_id=%s", url, taskID))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("expected: %v, actual: %v", http.StatusOK, resp.StatusCode)
+		}
+
+		resp, err = http.Get(fmt.Sprintf("%s/results?task_id=bad", url))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("expected: %v, actual: %v", http.StatusNotFound, resp.StatusCode)
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		req, err := http.NewRequest("GET", fmt.Sprintf("%s/run?client_id=0&info=hello&mode=parallel&failonerror=false", url), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept", "application/json")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var run RunQueryResult
+		if err := json.NewDecoder(resp.Body).Decode(&run); err != nil {
+			t.Fatal(err)
+		}
+		if run.Task.ID == "" || run.Params.Info != "hello" || run.Task.Mode != "parallel" {
+			t.Errorf("unexpected result: %v", run)
+		}
+
+		for _, path := range []string{"status", "kill"} {
+			resp, err = http.Get(fmt.Sprintf("%s/%s?task_id=%s&format=json", url, path, run.Task.ID))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected, actual := "application/json; charset=utf-8", resp.Header.Get("Content-Type"); expected != actual {
+				t.Errorf("expected: %s, actual: %s", expected, actual)
+			}
+
+			var qr QueryResult
+			if err := json.NewDecoder(resp.Body).Decode(&qr); err != nil {
+				t.Fatal(err)
+			}
+			if qr.Task.ID != run.Task.ID || qr.Params.TaskID != run.Task.ID || qr.Task.Status == "" {
+				t.Errorf("unexpected result: %v", qr)
+			}
+		}
+	})
+
+	t.Run("tasks", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			if _, err := http.Get(fmt.Sprintf("%s/run?client_id=0&info=tasks&mode=sequential", url)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var (
+			cursor string
+			seen   int
+		)
+		for {
+			resp, err := http.Get(fmt.Sprintf("%s/tasks?mode=sequential&limit=2&format=json&cursor=%s", url, cursor))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("expected: %v, actual: %v", http.StatusOK, resp.StatusCode)
+			}
+
+			var qr TasksQueryResult
+			if err := json.NewDecoder(resp.Body).Decode(&qr); err != nil {
+				t.Fatal(err)
+			}
+			for _, v := range qr.Records {
+				if v.Mode != "sequential" {
+					t.Errorf("expected: sequential, actual: %s", v.Mode)
+				}
+			}
+			seen += len(qr.Records)
+
+			if qr.Cursor == "" {
+				break
+			}
+			cursor = qr.Cursor
+		}
+
+		if expected, actual := 3, seen; expected != actual {
+			t.Errorf("expected: %d, actual: %d", expected, actual)
+		}
+
+		resp, err := http.Get(fmt.Sprintf("%s/tasks?cursor=bad", url))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected: %v, actual: %v", http.StatusBadRequest, resp.StatusCode)
+		}
+	})
+
+	t.Run("events", func(t *testing.T) {
+		resp, err := http.Get(fmt.Sprintf("%s/events", url))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if expected, actual := "text/event-stream", resp.Header.Get("Content-Type"); expected != actual {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+
+		run, err := http.Get(fmt.Sprintf("%s/run?client_id=0&info=events&mode=sequential", url))
+		if err != nil {
+			t.Fatal(err)
+		}
+		bytes, err := ioutil.ReadAll(run.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		taskID := string(bytes)
+
+		// Find the pending event for the task.
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if !strings.HasPrefix(line, "data: ") {
+				continue
+			}
+
+			var e event
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e); err != nil {
+				t.Fatal(err)
+			}
+			if e.TaskID == taskID {
+				if e.Status != "pending" {
+					t.Errorf("expected: pending, actual: %s", e.Status)
+				}
+				break
+			}
+		}
+	})
+
+	t.Run("task events", func(t *testing.T) {
+		run, err := http.Get(fmt.Sprintf("%s/run?client_id=0&info=events&mode=sequential", url))
+		if err != nil {
+			t.Fatal(err)
+		}
+		bytes, err := ioutil.ReadAll(run.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		taskID := string(bytes)
+
+		resp, err := http.Get(fmt.Sprintf("%s/events?task_id=%s", url, taskID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if _, err := http.Get(fmt.Sprintf("%s/kill?task_id=%s", url, taskID)); err != nil {
+			t.Fatal(err)
+		}
+
+		// The stream should finish once the task has been cancelled.
+		var statuses []string
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if !strings.HasPrefix(line, "data: ") {
+				continue
+			}
+
+			var e event
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e); err != nil {
+				t.Fatal(err)
+			}
+			statuses = append(statuses, e.Status)
+		}
+
+		if expected, actual := "pending,cancelled", strings.Join(statuses, ","); expected != actual {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+
+		resp, err = http.Get(fmt.Sprintf("%s/events?task_id=bad", url))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("expected: %v, actual: %v", http.StatusNotFound, resp.StatusCode)
+		}
+	})
+
+	t.Run("wait", func(t *testing.T) {
+		run, err := http.Get(fmt.Sprintf("%s/run?client_id=0&info=wait&mode=sequential", url))
+		if err != nil {
+			t.Fatal(err)
+		}
+		bytes, err := ioutil.ReadAll(run.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		taskID := string(bytes)
+
+		// Nothing runs the task, so waiting should time out.
+		resp, err := http.Get(fmt.Sprintf("%s/wait?task_id=%s&timeout=10ms", url, taskID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := "false", resp.Header.Get("X-Proxy-Done"); expected != actual {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			http.Get(fmt.Sprintf("%s/kill?task_id=%s", url, taskID))
+		}()
+
+		resp, err = http.Get(fmt.Sprintf("%s/wait?task_id=%s&timeout=5s", url, taskID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := "true", resp.Header.Get("X-Proxy-Done"); expected != actual {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+		bytes, err = ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := "cancelled", string(bytes); expected != actual {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+
+		resp, err = http.Get(fmt.Sprintf("%s/wait?task_id=%s&timeout=bad", url, taskID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected: %v, actual: %v", http.StatusBadRequest, resp.StatusCode)
+		}
+	})
+
+	t.Run("leader", func(t *testing.T) {
+		resp, err := http.Get(fmt.Sprintf("%s/leader", url))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := "leader= candidate= leading=true\n", string(b); expected != actual {
+			t.Errorf("expected: %q, actual: %q", expected, actual)
+		}
+	})
+
+	t.Run("peers", func(t *testing.T) {
+		resp, err := http.Get(fmt.Sprintf("%s/peers?format=json", url))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var qr PeersQueryResult
+		if err := json.NewDecoder(resp.Body).Decode(&qr); err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := 1, len(qr.Records); expected != actual {
+			t.Fatalf("expected: %d, actual: %d", expected, actual)
+		}
+		if expected, actual := "0.0.0.0:0", qr.Records[0].Addr; expected != actual {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+		if !qr.Records[0].Healthy {
+			t.Errorf("expected: true, actual: %v", qr.Records[0].Healthy)
+		}
+		if expected, actual := peer.BreakerStateClosed, qr.Records[0].Breaker.State; expected != actual {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+
+		resp, err = http.Get(fmt.Sprintf("%s/peers", url))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := "addr=0.0.0.0:0 healthy=true", string(b); !strings.HasPrefix(actual, expected) {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+	})
+
+	t.Run("admin peers", func(t *testing.T) {
+		for _, v := range []struct {
+			path string
+			addr string
+			code int
+		}{
+			{"add", "127.0.0.1:1", http.StatusOK},
+			{"add", "127.0.0.1:1", http.StatusConflict},
+			{"add", "bad", http.StatusBadRequest},
+			{"drain", "127.0.0.1:1", http.StatusOK},
+			{"drain", "127.0.0.1:2", http.StatusNotFound},
+		} {
+			resp, err := http.Post(fmt.Sprintf("%s/admin/peers/%s?addr=%s", url, v.path, v.addr), "text/plain", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected, actual := v.code, resp.StatusCode; expected != actual {
+				t.Errorf("%s %s: expected: %v, actual: %v", v.path, v.addr, expected, actual)
+			}
+		}
+
+		// The admin routes only change the agents when posted to.
+		for _, v := range []string{"add", "drain", "remove"} {
+			resp, err := http.Get(fmt.Sprintf("%s/admin/peers/%s?addr=127.0.0.1:3", url, v))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected, actual := http.StatusMethodNotAllowed, resp.StatusCode; expected != actual {
+				t.Errorf("%s: expected: %v, actual: %v", v, expected, actual)
+			}
+		}
+
+		// The client ID and quorum are validated against the active agents,
+		// which leaves out the drained agent.
+		for _, query := range []string{"client_id=1&mode=parallel", "client_id=0&mode=quorum&quorum=2"} {
+			resp, err := http.Get(fmt.Sprintf("%s/run?info=hello&%s", url, query))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected, actual := http.StatusBadRequest, resp.StatusCode; expected != actual {
+				t.Errorf("%s: expected: %v, actual: %v", query, expected, actual)
+			}
+		}
+
+		resp, err := http.Post(fmt.Sprintf("%s/admin/peers/remove?addr=127.0.0.1:1&format=json", url), "text/plain", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var qr PeersQueryResult
+		if err := json.NewDecoder(resp.Body).Decode(&qr); err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := 1, len(qr.Records); expected != actual {
+			t.Errorf("expected: %d, actual: %d", expected, actual)
+		}
+
+		resp, err = http.Get(fmt.Sprintf("%s/run?client_id=1&info=hello&mode=parallel", url))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := http.StatusBadRequest, resp.StatusCode; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("register", func(t *testing.T) {
+		resp, err := http.Post(fmt.Sprintf("%s/register?addr=0.0.0.0:9999&format=json", url), "text/plain", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := http.StatusOK, resp.StatusCode; expected != actual {
+			t.Fatalf("expected: %v, actual: %v", expected, actual)
+		}
+
+		var qr PeersQueryResult
+		if err := json.NewDecoder(resp.Body).Decode(&qr); err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := 2, len(qr.Records); expected != actual {
+			t.Fatalf("expected: %d, actual: %d", expected, actual)
+		}
+		if expected, actual := "127.0.0.1:9999", qr.Records[1].Addr; expected != actual {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+
+		for _, code := range []int{http.StatusOK, http.StatusNotFound} {
+			resp, err = http.Post(fmt.Sprintf("%s/deregister?addr=0.0.0.0:9999", url), "text/plain", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected, actual := code, resp.StatusCode; expected != actual {
+				t.Errorf("expected: %v, actual: %v", expected, actual)
+			}
+		}
+		if expected, actual := 1, len(scheduler.Peers()); expected != actual {
+			t.Errorf("expected: %d, actual: %d", expected, actual)
+		}
+	})
+
+	t.Run("register disabled", func(t *testing.T) {
+		server := httptest.NewServer(NewAPI(scheduler, nil, nil, DefaultTaskDefaults(), logger))
+		defer server.Close()
+
+		for _, path := range []string{"register", "deregister"} {
+			resp, err := http.Post(fmt.Sprintf("%s/%s?addr=0.0.0.0:9999", server.URL, path), "text/plain", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected, actual := http.StatusNotFound, resp.StatusCode; expected != actual {
+				t.Errorf("expected: %v, actual: %v", expected, actual)
+			}
+		}
+		if expected, actual := 1, len(scheduler.Peers()); expected != actual {
+			t.Errorf("expected: %d, actual: %d", expected, actual)
+		}
+	})
+
 	t.Run("not found", func(t *testing.T) {
 		resp, err := http.Get(fmt.Sprintf("%s/bad", url))
 		if err != nil {
@@ -112,3 +602,250 @@ func TestAPI(t *testing.T) {
 		}
 	})
 }
+
+func TestAPILeader(t *testing.T) {
+	t.Parallel()
+
+	var (
+		logger = log.NewNopLogger()
+		lock   = leader.NewMemoryLock()
+	)
+
+	// newProxy creates the API of a proxy, which campaigns to lead using the
+	// shared lock.
+	newProxy := func() (*scheduler.Scheduler, *leader.Election, *httptest.Server) {
+		var (
+			scheduler = scheduler.NewScheduler([]*peer.Peer{
+				peer.NewPeer(http.DefaultClient, "tcp", "0.0.0.0:0", logger),
+			}, scheduler.NewMemoryTaskStore(), 1, logger)
+			registry = peer.NewRegistry(peer.NewReconciler(scheduler.Membership(), func(addr string) *peer.Peer {
+				return peer.NewPeer(http.DefaultClient, "http", addr, logger)
+			}), time.Minute, logger)
+			server = httptest.NewUnstartedServer(nil)
+		)
+		election := leader.NewElection(lock, "http://"+server.Listener.Addr().String(), time.Minute, nil, logger)
+		server.Config.Handler = NewAPI(scheduler, registry, election, DefaultTaskDefaults(), logger)
+		server.Start()
+		return scheduler, election, server
+	}
+
+	leading, leadingElection, leadingServer := newProxy()
+	defer leadingServer.Close()
+	_, followingElection, followingServer := newProxy()
+	defer followingServer.Close()
+
+	// The follower doesn't know who's leading yet.
+	resp, err := http.Get(fmt.Sprintf("%s/run?client_id=0&info=a&mode=parallel&failonerror=false", followingServer.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := http.StatusServiceUnavailable, resp.StatusCode; expected != actual {
+		t.Errorf("expected: %d, actual: %d", expected, actual)
+	}
+
+	leadingElection.Campaign()
+	followingElection.Campaign()
+
+	t.Run("leader", func(t *testing.T) {
+		resp, err := http.Get(fmt.Sprintf("%s/leader?format=json", followingServer.URL))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var qr LeaderQueryResult
+		if err := json.NewDecoder(resp.Body).Decode(&qr); err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := leadingServer.URL, qr.Leader; expected != actual {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+		if expected, actual := followingServer.URL, qr.Candidate; expected != actual {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+		if qr.Leading {
+			t.Errorf("expected: false, actual: %t", qr.Leading)
+		}
+	})
+
+	t.Run("forward", func(t *testing.T) {
+		resp, err := http.Get(fmt.Sprintf("%s/run?client_id=0&info=a&mode=parallel&failonerror=false", followingServer.URL))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := http.StatusOK, resp.StatusCode; expected != actual {
+			t.Fatalf("expected: %d, actual: %d", expected, actual)
+		}
+
+		id := strings.TrimSpace(string(b))
+		task, ok := leading.Get(id)
+		if !ok {
+			t.Fatalf("expected: task on the leader, actual: %s", id)
+		}
+
+		resp, err = http.Get(fmt.Sprintf("%s/kill?task_id=%s", followingServer.URL, id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := http.StatusOK, resp.StatusCode; expected != actual {
+			t.Errorf("expected: %d, actual: %d", expected, actual)
+		}
+		if expected, actual := scheduler.TaskStatusTypeCancelled, task.Status(); expected != actual {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+	})
+
+	t.Run("forwarded", func(t *testing.T) {
+		req, err := http.NewRequest("GET", fmt.Sprintf("%s/run?client_id=0&info=a&mode=parallel&failonerror=false", followingServer.URL), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(httpHeaderForwarded, "other")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := http.StatusServiceUnavailable, resp.StatusCode; expected != actual {
+			t.Errorf("expected: %d, actual: %d", expected, actual)
+		}
+	})
+}
+
+func TestAPILeaderSharedStore(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "tasks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		logger = log.NewNopLogger()
+		lock   = leader.NewMemoryLock()
+		path   = filepath.Join(dir, "tasks.log")
+		agent  = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(50 * time.Millisecond)
+			w.WriteHeader(http.StatusOK)
+		}))
+		addr = strings.Replace(agent.URL, "http://", "", 1)
+	)
+	defer agent.Close()
+
+	// newProxy creates the API of a proxy, which campaigns to lead using the
+	// shared lock and keeps the tasks in the shared log.
+	newProxy := func(owner string) (*scheduler.Scheduler, *leader.Election, *httptest.Server) {
+		store, err := scheduler.NewSharedFileTaskStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scheduler := scheduler.NewScheduler([]*peer.Peer{
+			peer.NewPeer(http.DefaultClient, "http", addr, logger),
+		}, store, 1, logger)
+		if err := scheduler.Share(owner, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+
+		var (
+			registry = peer.NewRegistry(peer.NewReconciler(scheduler.Membership(), func(addr string) *peer.Peer {
+				return peer.NewPeer(http.DefaultClient, "http", addr, logger)
+			}), time.Minute, logger)
+			server = httptest.NewUnstartedServer(nil)
+		)
+		election := leader.NewElection(lock, "http://"+server.Listener.Addr().String(), time.Minute, nil, logger)
+		server.Config.Handler = NewAPI(scheduler, registry, election, DefaultTaskDefaults(), logger)
+		server.Start()
+		return scheduler, election, server
+	}
+
+	leading, leadingElection, leadingServer := newProxy("leading")
+	defer leadingServer.Close()
+	_, followingElection, followingServer := newProxy("following")
+	defer followingServer.Close()
+
+	// Only the leader runs the scheduler.
+	go leading.Run()
+	defer leading.Stop()
+
+	leadingElection.Campaign()
+	followingElection.Campaign()
+
+	run, err := http.Get(fmt.Sprintf("%s/run?client_id=0&info=a&mode=sequential", followingServer.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(run.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	taskID := strings.TrimSpace(string(b))
+
+	t.Run("wait", func(t *testing.T) {
+		resp, err := http.Get(fmt.Sprintf("%s/wait?task_id=%s&timeout=5s&format=json", followingServer.URL, taskID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var qr WaitQueryResult
+		if err := json.NewDecoder(resp.Body).Decode(&qr); err != nil {
+			t.Fatal(err)
+		}
+		if !qr.Done {
+			t.Errorf("expected: done, actual: %s", qr.Task.Status)
+		}
+		if expected, actual := string(scheduler.TaskStatusTypeCompleted), qr.Task.Status; expected != actual {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+	})
+
+	t.Run("events", func(t *testing.T) {
+		resp, err := http.Get(fmt.Sprintf("%s/events?task_id=%s", followingServer.URL, taskID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		// The stream of the finished task only has the current status.
+		var statuses []string
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if !strings.HasPrefix(line, "data: ") {
+				continue
+			}
+
+			var e event
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e); err != nil {
+				t.Fatal(err)
+			}
+			statuses = append(statuses, e.Status)
+		}
+
+		if expected, actual := "completed", strings.Join(statuses, ","); expected != actual {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+	})
+}
+
+func TestRemoteAddr(t *testing.T) {
+	t.Parallel()
+
+	for _, testcase := range []struct {
+		addr, remote, expected string
+	}{
+		{"0.0.0.0:8080", "10.0.0.1:5000", "10.0.0.1:8080"},
+		{"[::]:8080", "10.0.0.1:5000", "10.0.0.1:8080"},
+		{":8080", "[::1]:5000", "[::1]:8080"},
+		{"10.0.0.2:8080", "10.0.0.1:5000", "10.0.0.2:8080"},
+		{"agent:8080", "10.0.0.1:5000", "agent:8080"},
+	} {
+		if actual := remoteAddr(testcase.addr, testcase.remote); testcase.expected != actual {
+			t.Errorf("%s: expected: %s, actual: %s", testcase.addr, testcase.expected, actual)
+		}
+	}
+}
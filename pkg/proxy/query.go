@@ -1,11 +1,21 @@
 package proxy
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
+	"mime"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/SimonRichardson/cmdproxy/pkg/peer"
+	"github.com/SimonRichardson/cmdproxy/pkg/scheduler"
+	"github.com/go-logfmt/logfmt"
 	"github.com/pkg/errors"
 )
 
@@ -15,6 +25,17 @@ type RunQueryParams struct {
 	Info        string `json:"info"`
 	Mode        string `json:"mode"`
 	FailOnError bool   `json:"failonerror"`
+
+	Retry          scheduler.RetryPolicy   `json:"retry"`
+	RequestTimeout scheduler.Duration      `json:"request_timeout"`
+	TaskDeadline   scheduler.Duration      `json:"task_deadline"`
+	Quorum         int                     `json:"quorum"`
+	Rolling        scheduler.RollingPolicy `json:"rolling"`
+	Canary         scheduler.CanaryPolicy  `json:"canary"`
+	Selector       peer.Selector           `json:"selector,omitempty"`
+	Key            string                  `json:"key,omitempty"`
+	Balance        peer.BalanceType        `json:"balance,omitempty"`
+	Hedge          scheduler.HedgePolicy   `json:"hedge"`
 }
 
 // DecodeFrom populates a RunQueryParams from a URL.
@@ -47,6 +68,123 @@ func (qp *RunQueryParams) DecodeFrom(u *url.URL, rb queryBehaviour) error {
 		}
 	}
 
+	// Optional retry policy, which overrides any existing values.
+	if attempts := u.Query().Get("retry.attempts"); attempts != "" {
+		if qp.Retry.MaxAttempts, err = strconv.Atoi(attempts); err != nil {
+			return errors.New("Error reading/parsing 'retry.attempts' query.")
+		}
+	}
+	if backoff := u.Query().Get("retry.backoff"); backoff != "" {
+		if qp.Retry.InitialBackoff, err = scheduler.ParseDuration(backoff); err != nil {
+			return errors.New("Error reading/parsing 'retry.backoff' query.")
+		}
+	}
+	if multiplier := u.Query().Get("retry.multiplier"); multiplier != "" {
+		if qp.Retry.Multiplier, err = strconv.ParseFloat(multiplier, 64); err != nil {
+			return errors.New("Error reading/parsing 'retry.multiplier' query.")
+		}
+	}
+	if max := u.Query().Get("retry.max-backoff"); max != "" {
+		if qp.Retry.MaxBackoff, err = scheduler.ParseDuration(max); err != nil {
+			return errors.New("Error reading/parsing 'retry.max-backoff' query.")
+		}
+	}
+	if jitter := u.Query().Get("retry.jitter"); jitter != "" {
+		if qp.Retry.Jitter, err = strconv.ParseFloat(jitter, 64); err != nil {
+			return errors.New("Error reading/parsing 'retry.jitter' query.")
+		}
+	}
+	if codes, ok := u.Query()["retry.codes"]; ok {
+		if qp.Retry.RetryableCodes, err = scheduler.ParseStatusCodes(strings.Join(codes, ",")); err != nil {
+			return errors.New("Error reading/parsing 'retry.codes' query.")
+		}
+	}
+
+	// Optional timeouts, which override any existing values.
+	if timeout := u.Query().Get("request.timeout"); timeout != "" {
+		if qp.RequestTimeout, err = scheduler.ParseDuration(timeout); err != nil || qp.RequestTimeout < 0 {
+			return errors.New("Error reading/parsing 'request.timeout' query.")
+		}
+	}
+	if deadline := u.Query().Get("task.deadline"); deadline != "" {
+		if qp.TaskDeadline, err = scheduler.ParseDuration(deadline); err != nil || qp.TaskDeadline < 0 {
+			return errors.New("Error reading/parsing 'task.deadline' query.")
+		}
+	}
+
+	// Optional rolling policy, which overrides any existing values.
+	if batch := u.Query().Get("rolling.batch"); batch != "" {
+		if qp.Rolling.BatchSize, err = strconv.Atoi(batch); err != nil {
+			return errors.New("Error reading/parsing 'rolling.batch' query.")
+		}
+	}
+	if pause := u.Query().Get("rolling.pause"); pause != "" {
+		if qp.Rolling.Pause, err = scheduler.ParseDuration(pause); err != nil {
+			return errors.New("Error reading/parsing 'rolling.pause' query.")
+		}
+	}
+	if unavailable := u.Query().Get("rolling.max-unavailable"); unavailable != "" {
+		if qp.Rolling.MaxUnavailable, err = strconv.Atoi(unavailable); err != nil {
+			return errors.New("Error reading/parsing 'rolling.max-unavailable' query.")
+		}
+	}
+
+	// Optional canary policy, which overrides any existing values.
+	if fraction := u.Query().Get("canary.fraction"); fraction != "" {
+		if qp.Canary.Fraction, err = strconv.ParseFloat(fraction, 64); err != nil {
+			return errors.New("Error reading/parsing 'canary.fraction' query.")
+		}
+	}
+	if soak := u.Query().Get("canary.soak"); soak != "" {
+		if qp.Canary.Soak, err = scheduler.ParseDuration(soak); err != nil {
+			return errors.New("Error reading/parsing 'canary.soak' query.")
+		}
+	}
+	if interval := u.Query().Get("canary.interval"); interval != "" {
+		if qp.Canary.Interval, err = scheduler.ParseDuration(interval); err != nil {
+			return errors.New("Error reading/parsing 'canary.interval' query.")
+		}
+	}
+
+	// Optional selector, which defaults to every agent.
+	if selector := u.Query().Get("selector"); selector != "" {
+		if qp.Selector, err = peer.ParseSelector(selector); err != nil {
+			return errors.New("Error reading/parsing 'selector' query.")
+		}
+	}
+
+	// Optional routing key, which defaults to the info.
+	qp.Key = u.Query().Get("key")
+	if qp.Key == "" {
+		qp.Key = qp.Info
+	}
+
+	// Optional balance type, which defaults to round-robin.
+	if balance := u.Query().Get("balance"); balance != "" {
+		if qp.Balance, err = peer.ParseBalanceType(balance); err != nil {
+			return errors.New("Error reading/parsing 'balance' query.")
+		}
+	}
+
+	// Optional hedge policy, which overrides any existing values.
+	if delay := u.Query().Get("hedge.delay"); delay != "" {
+		if qp.Hedge.Delay, err = scheduler.ParseDuration(delay); err != nil {
+			return errors.New("Error reading/parsing 'hedge.delay' query.")
+		}
+	}
+	if percentile := u.Query().Get("hedge.percentile"); percentile != "" {
+		if qp.Hedge.Percentile, err = strconv.ParseFloat(percentile, 64); err != nil {
+			return errors.New("Error reading/parsing 'hedge.percentile' query.")
+		}
+	}
+
+	// Optional quorum, which defaults to a majority of the agents.
+	if quorum := u.Query().Get("quorum"); quorum != "" {
+		if qp.Quorum, err = strconv.Atoi(quorum); err != nil || qp.Quorum < 0 {
+			return errors.New("Error reading/parsing 'quorum' query.")
+		}
+	}
+
 	return nil
 }
 
@@ -54,19 +192,25 @@ func (qp *RunQueryParams) DecodeFrom(u *url.URL, rb queryBehaviour) error {
 type RunQueryResult struct {
 	Params   RunQueryParams `json:"query"`
 	Duration string         `json:"duration"`
+	Task     TaskResult     `json:"task"`
 
-	Records string
+	Records string `json:"-"`
 }
 
 // EncodeTo encodes the QueryResult to the HTTP response writer.
-func (qr *RunQueryResult) EncodeTo(w http.ResponseWriter) {
+func (qr *RunQueryResult) EncodeTo(w http.ResponseWriter, f format) {
 	w.Header().Set(httpHeaderClientID, strconv.Itoa(qr.Params.ClientID))
 	w.Header().Set(httpHeaderInfo, qr.Params.Info)
 	w.Header().Set(httpHeaderMode, qr.Params.Mode)
 	w.Header().Set(httpHeaderFailOnError, strconv.FormatBool(qr.Params.FailOnError))
 	w.Header().Set(httpHeaderDuration, qr.Duration)
 
-	fmt.Fprintf(w, qr.Records)
+	if f == formatJSON {
+		encodeJSON(w, qr)
+		return
+	}
+
+	fmt.Fprint(w, qr.Records)
 }
 
 // QueryParams defines all the dimensions of a query.
@@ -89,18 +233,449 @@ func (qp *QueryParams) DecodeFrom(u *url.URL, rb queryBehaviour) error {
 type QueryResult struct {
 	Params   QueryParams `json:"query"`
 	Duration string      `json:"duration"`
+	Task     TaskResult  `json:"task"`
 
-	Records string
+	Records string `json:"-"`
 }
 
 // EncodeTo encodes the QueryResult to the HTTP response writer.
-func (qr *QueryResult) EncodeTo(w http.ResponseWriter) {
+func (qr *QueryResult) EncodeTo(w http.ResponseWriter, f format) {
+	w.Header().Set(httpHeaderTaskID, qr.Params.TaskID)
+	w.Header().Set(httpHeaderDuration, qr.Duration)
+	if qr.Task.Phase != "" {
+		w.Header().Set(httpHeaderPhase, qr.Task.Phase)
+	}
+
+	if f == formatJSON {
+		encodeJSON(w, qr)
+		return
+	}
+
+	fmt.Fprint(w, qr.Records)
+}
+
+// WaitQueryParams defines all the dimensions of a wait query.
+type WaitQueryParams struct {
+	TaskID  string             `json:"task_id"`
+	Timeout scheduler.Duration `json:"timeout"`
+}
+
+// DecodeFrom populates a WaitQueryParams from a URL.
+func (qp *WaitQueryParams) DecodeFrom(u *url.URL, rb queryBehaviour) error {
+	// Required depending on the query behaviour
+	qp.TaskID = u.Query().Get("task_id")
+	if qp.TaskID == "" && rb == queryRequired {
+		return errors.New("Error reading/parsing 'task_id' (required) query.")
+	}
+
+	// Optional
+	qp.Timeout = defaultWaitTimeout
+	if timeout := u.Query().Get("timeout"); timeout != "" {
+		var err error
+		if qp.Timeout, err = scheduler.ParseDuration(timeout); err != nil || qp.Timeout <= 0 || qp.Timeout > maxWaitTimeout {
+			return errors.Errorf("Error reading/parsing 'timeout' query (max %s).", maxWaitTimeout)
+		}
+	}
+
+	return nil
+}
+
+// WaitQueryResult contains the task once it has finished, or the timeout has
+// elapsed.
+type WaitQueryResult struct {
+	Params   WaitQueryParams `json:"query"`
+	Duration string          `json:"duration"`
+	Task     TaskResult      `json:"task"`
+	Done     bool            `json:"done"`
+
+	Records string `json:"-"`
+}
+
+// EncodeTo encodes the WaitQueryResult to the HTTP response writer.
+func (qr *WaitQueryResult) EncodeTo(w http.ResponseWriter, f format) {
+	w.Header().Set(httpHeaderTaskID, qr.Params.TaskID)
+	w.Header().Set(httpHeaderDone, strconv.FormatBool(qr.Done))
+	w.Header().Set(httpHeaderDuration, qr.Duration)
+
+	if f == formatJSON {
+		encodeJSON(w, qr)
+		return
+	}
+
+	fmt.Fprint(w, qr.Records)
+}
+
+// ResultQueryResult contains the attempts made against each peer for a task.
+type ResultQueryResult struct {
+	Params   QueryParams `json:"query"`
+	Duration string      `json:"duration"`
+
+	Records []scheduler.Attempt `json:"records"`
+}
+
+// EncodeTo encodes the ResultQueryResult to the HTTP response writer.
+// When encoding as text, each attempt is written as a logfmt line.
+func (qr *ResultQueryResult) EncodeTo(w http.ResponseWriter, f format) {
 	w.Header().Set(httpHeaderTaskID, qr.Params.TaskID)
 	w.Header().Set(httpHeaderDuration, qr.Duration)
 
-	fmt.Fprintf(w, qr.Records)
+	if f == formatJSON {
+		encodeJSON(w, qr)
+		return
+	}
+
+	enc := logfmt.NewEncoder(w)
+	for _, v := range qr.Records {
+		enc.EncodeKeyvals(
+			"peer", v.Peer,
+			"start", v.Start.Format(time.RFC3339Nano),
+			"end", v.End.Format(time.RFC3339Nano),
+			"status_code", v.StatusCode,
+			"agent_duration", v.AgentDuration,
+			"error", v.Error,
+		)
+		enc.EndRecord()
+	}
+}
+
+// TasksQueryParams defines all the dimensions of a tasks query.
+type TasksQueryParams struct {
+	Status   string    `json:"status,omitempty"`
+	Mode     string    `json:"mode,omitempty"`
+	ClientID *int      `json:"client_id,omitempty"`
+	Since    time.Time `json:"since"`
+	Until    time.Time `json:"until"`
+	Cursor   string    `json:"cursor,omitempty"`
+	Limit    int       `json:"limit"`
+	Order    string    `json:"order"`
+}
+
+// DecodeFrom populates a TasksQueryParams from a URL.
+// Every parameter of a tasks query is optional.
+func (qp *TasksQueryParams) DecodeFrom(u *url.URL, rb queryBehaviour) error {
+	var (
+		err   error
+		query = u.Query()
+	)
+
+	if qp.Status = query.Get("status"); qp.Status != "" {
+		if _, err = scheduler.ParseTaskStatusType(qp.Status); err != nil {
+			return errors.New("Error reading/parsing 'status' query.")
+		}
+	}
+
+	if qp.Mode = query.Get("mode"); qp.Mode != "" {
+		if _, err = scheduler.ParseModeType(qp.Mode); err != nil {
+			return errors.New("Error reading/parsing 'mode' query.")
+		}
+	}
+
+	if clientID := query.Get("client_id"); clientID != "" {
+		id, err := strconv.Atoi(clientID)
+		if err != nil {
+			return errors.New("Error reading/parsing 'client_id' query.")
+		}
+		qp.ClientID = &id
+	}
+
+	if since := query.Get("since"); since != "" {
+		if qp.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return errors.New("Error reading/parsing 'since' query.")
+		}
+	}
+
+	if until := query.Get("until"); until != "" {
+		if qp.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			return errors.New("Error reading/parsing 'until' query.")
+		}
+	}
+
+	qp.Cursor = query.Get("cursor")
+
+	qp.Limit = defaultTasksLimit
+	if limit := query.Get("limit"); limit != "" {
+		if qp.Limit, err = strconv.Atoi(limit); err != nil || qp.Limit < 1 || qp.Limit > maxTasksLimit {
+			return errors.Errorf("Error reading/parsing 'limit' query (1-%d).", maxTasksLimit)
+		}
+	}
+
+	qp.Order = string(scheduler.SortOrderAsc)
+	if order := query.Get("order"); order != "" {
+		if _, err = scheduler.ParseSortOrder(order); err != nil {
+			return errors.New("Error reading/parsing 'order' query.")
+		}
+		qp.Order = order
+	}
+
+	return nil
+}
+
+// TaskQuery converts the params into a query for the scheduler.
+func (qp *TasksQueryParams) TaskQuery() scheduler.TaskQuery {
+	return scheduler.TaskQuery{
+		Status:   scheduler.TaskStatusType(qp.Status),
+		Mode:     scheduler.ModeType(qp.Mode),
+		ClientID: qp.ClientID,
+		Since:    qp.Since,
+		Until:    qp.Until,
+		Cursor:   qp.Cursor,
+		Limit:    qp.Limit,
+		Order:    scheduler.SortOrder(qp.Order),
+	}
+}
+
+// TasksQueryResult contains the tasks matching the query.
+type TasksQueryResult struct {
+	Params   TasksQueryParams `json:"query"`
+	Duration string           `json:"duration"`
+	Cursor   string           `json:"cursor,omitempty"`
+
+	Records []TaskResult `json:"records"`
+}
+
+// EncodeTo encodes the TasksQueryResult to the HTTP response writer.
+// When encoding as text, each task is written as a logfmt line.
+func (qr *TasksQueryResult) EncodeTo(w http.ResponseWriter, f format) {
+	w.Header().Set(httpHeaderCursor, qr.Cursor)
+	w.Header().Set(httpHeaderDuration, qr.Duration)
+
+	if f == formatJSON {
+		encodeJSON(w, qr)
+		return
+	}
+
+	enc := logfmt.NewEncoder(w)
+	for _, v := range qr.Records {
+		enc.EncodeKeyvals(
+			"id", v.ID,
+			"status", v.Status,
+			"mode", v.Mode,
+			"client_id", v.ClientID,
+			"created", v.Created.Format(time.RFC3339Nano),
+		)
+		enc.EndRecord()
+	}
+}
+
+// PeerQueryParams defines all the dimensions of a peer query.
+type PeerQueryParams struct {
+	Addr string `json:"addr"`
+}
+
+// DecodeFrom populates a PeerQueryParams from a URL.
+func (qp *PeerQueryParams) DecodeFrom(u *url.URL, rb queryBehaviour) error {
+	// Required depending on the query behaviour
+	qp.Addr = u.Query().Get("addr")
+	if qp.Addr == "" && rb == queryRequired {
+		return errors.New("Error reading/parsing 'addr' (required) query.")
+	}
+	if qp.Addr != "" {
+		if _, _, err := net.SplitHostPort(qp.Addr); err != nil {
+			return errors.New("Error reading/parsing 'addr' (host:port) query.")
+		}
+	}
+
+	return nil
+}
+
+// PeersQueryResult contains the health of every peer.
+type PeersQueryResult struct {
+	Duration string `json:"duration"`
+
+	Records []PeerResult `json:"records"`
+}
+
+// EncodeTo encodes the PeersQueryResult to the HTTP response writer.
+// When encoding as text, each peer is written as a logfmt line.
+func (qr *PeersQueryResult) EncodeTo(w http.ResponseWriter, f format) {
+	w.Header().Set(httpHeaderDuration, qr.Duration)
+
+	if f == formatJSON {
+		encodeJSON(w, qr)
+		return
+	}
+
+	enc := logfmt.NewEncoder(w)
+	for _, v := range qr.Records {
+		var lastCheck string
+		if !v.LastCheck.IsZero() {
+			lastCheck = v.LastCheck.Format(time.RFC3339Nano)
+		}
+		enc.EncodeKeyvals(
+			"addr", v.Addr,
+			"healthy", v.Healthy,
+			"draining", v.Draining,
+			"successes", v.Successes,
+			"failures", v.Failures,
+			"last_check", lastCheck,
+			"error", v.Error,
+			"labels", v.Labels.String(),
+			"outstanding", v.Outstanding,
+			"latency", v.Latency,
+			"breaker", v.Breaker.State,
+		)
+		enc.EndRecord()
+	}
+}
+
+// LeaderQueryResult contains the leader of the proxies.
+type LeaderQueryResult struct {
+	Duration string `json:"duration"`
+
+	// Leader and Candidate are empty when there's no election.
+	Leader    string `json:"leader"`
+	Candidate string `json:"candidate"`
+	Leading   bool   `json:"leading"`
+}
+
+// EncodeTo encodes the LeaderQueryResult to the HTTP response writer.
+// When encoding as text, the leader is written as a logfmt line.
+func (qr *LeaderQueryResult) EncodeTo(w http.ResponseWriter, f format) {
+	w.Header().Set(httpHeaderDuration, qr.Duration)
+
+	if f == formatJSON {
+		encodeJSON(w, qr)
+		return
+	}
+
+	enc := logfmt.NewEncoder(w)
+	enc.EncodeKeyvals(
+		"leader", qr.Leader,
+		"candidate", qr.Candidate,
+		"leading", qr.Leading,
+	)
+	enc.EndRecord()
+}
+
+// PeerResult is the structured representation of a peer.Peer.
+type PeerResult struct {
+	Addr        string             `json:"addr"`
+	Draining    bool               `json:"draining"`
+	Labels      peer.Labels        `json:"labels,omitempty"`
+	Breaker     peer.Breaker       `json:"breaker"`
+	Outstanding int                `json:"outstanding"`
+	Latency     scheduler.Duration `json:"latency"`
+	peer.Health
+}
+
+func newPeerResult(p *peer.Peer, draining bool) PeerResult {
+	stats := p.Stats()
+	return PeerResult{
+		Addr:        p.Addr(),
+		Draining:    draining,
+		Labels:      p.Labels(),
+		Breaker:     p.Breaker(),
+		Outstanding: stats.Outstanding,
+		Latency:     scheduler.Duration(stats.Latency),
+		Health:      p.Health(),
+	}
+}
+
+// TaskResult is the structured representation of a scheduler.Task.
+type TaskResult struct {
+	ID          string                  `json:"id"`
+	Status      string                  `json:"status"`
+	Mode        string                  `json:"mode"`
+	ClientID    int                     `json:"client_id"`
+	Info        string                  `json:"info"`
+	FailOnError bool                    `json:"failonerror"`
+	Retry       scheduler.RetryPolicy   `json:"retry"`
+	Timeout     scheduler.Duration      `json:"request_timeout"`
+	Deadline    scheduler.Duration      `json:"task_deadline"`
+	Quorum      int                     `json:"quorum,omitempty"`
+	Rolling     scheduler.RollingPolicy `json:"rolling"`
+	Canary      scheduler.CanaryPolicy  `json:"canary"`
+	Selector    peer.Selector           `json:"selector,omitempty"`
+	Key         string                  `json:"key,omitempty"`
+	Balance     string                  `json:"balance,omitempty"`
+	Hedge       scheduler.HedgePolicy   `json:"hedge"`
+	Phase       string                  `json:"phase,omitempty"`
+	Created     time.Time               `json:"created"`
+	Attempts    []scheduler.Attempt     `json:"attempts"`
+}
+
+func newTaskResult(task *scheduler.Task) TaskResult {
+	return TaskResult{
+		ID:          task.ID(),
+		Status:      string(task.Status()),
+		Mode:        string(task.Mode()),
+		ClientID:    task.ClientID(),
+		Info:        task.Info(),
+		FailOnError: task.FailOnError(),
+		Retry:       task.RetryPolicy(),
+		Timeout:     scheduler.Duration(task.RequestTimeout()),
+		Deadline:    scheduler.Duration(task.Deadline()),
+		Quorum:      task.Quorum(),
+		Rolling:     task.RollingPolicy(),
+		Canary:      task.CanaryPolicy(),
+		Selector:    task.Selector(),
+		Key:         task.Key(),
+		Balance:     string(task.Balance()),
+		Hedge:       task.HedgePolicy(),
+		Phase:       string(task.Phase()),
+		Created:     task.Created(),
+		Attempts:    task.Attempts(),
+	}
+}
+
+// format defines how a result is encoded to the HTTP response writer.
+type format int
+
+const (
+	formatText format = iota
+	formatJSON
+)
+
+// negotiateFormat selects the format of the response from the request.
+// The format query takes precedence over the Accept header and plain text is
+// used when neither asks for JSON.
+func negotiateFormat(r *http.Request) format {
+	switch r.URL.Query().Get("format") {
+	case "json":
+		return formatJSON
+	case "text":
+		return formatText
+	}
+
+	for _, v := range r.Header["Accept"] {
+		for _, part := range strings.Split(v, ",") {
+			mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err == nil && mediaType == mimeJSON {
+				return formatJSON
+			}
+		}
+	}
+	return formatText
 }
 
+func encodeJSON(w http.ResponseWriter, v interface{}) {
+	// Encode to a buffer first, so that nothing has been written if it fails.
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", mimeJSON+"; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// encodeEvent writes the event as a Server-Sent Event.
+func encodeEvent(w io.Writer, e scheduler.Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, b)
+	return err
+}
+
+const (
+	mimeJSON        = "application/json"
+	mimeEventStream = "text/event-stream"
+)
+
 const (
 	httpHeaderClientID    = "X-Proxy-ClientID"
 	httpHeaderInfo        = "X-Proxy-Info"
@@ -108,6 +683,18 @@ const (
 	httpHeaderFailOnError = "X-Proxy-FailOnError"
 	httpHeaderTaskID      = "X-Proxy-TaskID"
 	httpHeaderDuration    = "X-Proxy-Duration"
+	httpHeaderPhase       = "X-Proxy-Phase"
+	httpHeaderCursor      = "X-Proxy-Cursor"
+	httpHeaderDone        = "X-Proxy-Done"
+	httpHeaderForwarded   = "X-Proxy-Forwarded"
+)
+
+const (
+	defaultTasksLimit = 100
+	maxTasksLimit     = 1000
+
+	defaultWaitTimeout = scheduler.Duration(30 * time.Second)
+	maxWaitTimeout     = scheduler.Duration(5 * time.Minute)
 )
 
 type queryBehaviour int
@@ -1,11 +1,19 @@
 package proxy
 
 import (
+	"encoding/json"
 	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
 	"testing/quick"
+	"time"
 
+	"github.com/SimonRichardson/cmdproxy/pkg/peer"
+	"github.com/SimonRichardson/cmdproxy/pkg/scheduler"
 	"github.com/SimonRichardson/cmdproxy/pkg/test"
 )
 
@@ -60,4 +68,445 @@ func TestRunQueryParams(t *testing.T) {
 			t.Errorf("expected error")
 		}
 	})
+
+	t.Run("decode retry", func(t *testing.T) {
+		var (
+			qp     = RunQueryParams{Retry: scheduler.DefaultRetryPolicy()}
+			u, err = url.Parse("http://example.com?client_id=0&info=hello&mode=world&retry.attempts=3&retry.backoff=1s&retry.multiplier=1.5&retry.max-backoff=5s&retry.codes=500,503")
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := qp.DecodeFrom(u, queryRequired); err != nil {
+			t.Fatal(err)
+		}
+
+		if qp.Retry.MaxAttempts != 3 ||
+			qp.Retry.InitialBackoff != scheduler.Duration(time.Second) ||
+			qp.Retry.Multiplier != 1.5 ||
+			qp.Retry.MaxBackoff != scheduler.Duration(5*time.Second) ||
+			qp.Retry.Jitter != scheduler.DefaultRetryPolicy().Jitter ||
+			scheduler.FormatStatusCodes(qp.Retry.RetryableCodes) != "500,503" {
+			t.Errorf("unexpected retry policy: %v", qp.Retry)
+		}
+	})
+
+	t.Run("decode timeouts", func(t *testing.T) {
+		var (
+			qp     RunQueryParams
+			u, err = url.Parse("http://example.com?client_id=0&info=hello&mode=world&request.timeout=2s&task.deadline=1m")
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := qp.DecodeFrom(u, queryRequired); err != nil {
+			t.Fatal(err)
+		}
+
+		if expected, actual := scheduler.Duration(2*time.Second), qp.RequestTimeout; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := scheduler.Duration(time.Minute), qp.TaskDeadline; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("decode quorum", func(t *testing.T) {
+		var (
+			qp     RunQueryParams
+			u, err = url.Parse("http://example.com?client_id=0&info=hello&mode=quorum&quorum=2")
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := qp.DecodeFrom(u, queryRequired); err != nil {
+			t.Fatal(err)
+		}
+
+		if expected, actual := 2, qp.Quorum; expected != actual {
+			t.Errorf("expected: %d, actual: %d", expected, actual)
+		}
+	})
+
+	t.Run("decode canary", func(t *testing.T) {
+		var (
+			qp     RunQueryParams
+			u, err = url.Parse("http://example.com?client_id=0&info=hello&mode=canary&canary.fraction=0.25&canary.soak=1m&canary.interval=5s")
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := qp.DecodeFrom(u, queryRequired); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := scheduler.CanaryPolicy{Fraction: 0.25, Soak: scheduler.Duration(time.Minute), Interval: scheduler.Duration(5 * time.Second)}
+		if actual := qp.Canary; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("decode selector", func(t *testing.T) {
+		var (
+			qp     RunQueryParams
+			u, err = url.Parse("http://example.com?client_id=0&info=hello&mode=parallel&selector=zone%3Da,role!%3Ddb")
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := qp.DecodeFrom(u, queryRequired); err != nil {
+			t.Fatal(err)
+		}
+
+		if expected, actual := "zone=a,role!=db", qp.Selector.String(); expected != actual {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+	})
+
+	t.Run("decode key", func(t *testing.T) {
+		for query, expected := range map[string]string{
+			"":        "hello",
+			"&key=id": "id",
+		} {
+			var (
+				qp     RunQueryParams
+				u, err = url.Parse("http://example.com?client_id=0&info=hello&mode=hashed" + query)
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := qp.DecodeFrom(u, queryRequired); err != nil {
+				t.Fatal(err)
+			}
+
+			if actual := qp.Key; expected != actual {
+				t.Errorf("expected: %s, actual: %s", expected, actual)
+			}
+		}
+	})
+
+	t.Run("decode balance", func(t *testing.T) {
+		var (
+			qp     RunQueryParams
+			u, err = url.Parse("http://example.com?client_id=0&info=hello&mode=one&balance=least-outstanding")
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := qp.DecodeFrom(u, queryRequired); err != nil {
+			t.Fatal(err)
+		}
+
+		if expected, actual := peer.BalanceTypeLeastOutstanding, qp.Balance; expected != actual {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+	})
+
+	t.Run("decode hedge", func(t *testing.T) {
+		var (
+			qp     RunQueryParams
+			u, err = url.Parse("http://example.com?client_id=0&info=hello&mode=one&hedge.delay=50ms&hedge.percentile=95")
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := qp.DecodeFrom(u, queryRequired); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := scheduler.HedgePolicy{Delay: scheduler.Duration(50 * time.Millisecond), Percentile: 95}
+		if actual := qp.Hedge; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("decode rolling", func(t *testing.T) {
+		var (
+			qp     RunQueryParams
+			u, err = url.Parse("http://example.com?client_id=0&info=hello&mode=rolling&rolling.batch=2&rolling.pause=1s&rolling.max-unavailable=1")
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := qp.DecodeFrom(u, queryRequired); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := scheduler.RollingPolicy{BatchSize: 2, Pause: scheduler.Duration(time.Second), MaxUnavailable: 1}
+		if actual := qp.Rolling; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("decode invalid retry", func(t *testing.T) {
+		for _, query := range []string{
+			"retry.attempts=bad",
+			"retry.backoff=bad",
+			"retry.multiplier=bad",
+			"retry.max-backoff=bad",
+			"retry.jitter=bad",
+			"retry.codes=bad",
+			"request.timeout=bad",
+			"request.timeout=-1s",
+			"task.deadline=bad",
+			"quorum=bad",
+			"rolling.batch=bad",
+			"rolling.pause=bad",
+			"rolling.max-unavailable=bad",
+			"canary.fraction=bad",
+			"canary.soak=bad",
+			"canary.interval=bad",
+			"selector=bad",
+			"balance=bad",
+			"hedge.delay=bad",
+			"hedge.percentile=bad",
+			"quorum=-1",
+		} {
+			var (
+				qp     RunQueryParams
+				u, err = url.Parse("http://example.com?client_id=0&info=hello&mode=world&" + query)
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := qp.DecodeFrom(u, queryRequired); err == nil {
+				t.Errorf("%s: expected error", query)
+			}
+		}
+	})
+}
+
+func TestResultQueryResult(t *testing.T) {
+	t.Parallel()
+
+	t.Run("encode", func(t *testing.T) {
+		var (
+			rec = httptest.NewRecorder()
+			qr  = ResultQueryResult{
+				Params: QueryParams{TaskID: "abc"},
+				Records: []scheduler.Attempt{
+					{Peer: "a:80", StatusCode: 200, AgentDuration: "1ms"},
+					{Peer: "b:80", StatusCode: 500, Error: "500 Internal Server Error"},
+				},
+			}
+		)
+		qr.EncodeTo(rec, formatText)
+
+		if expected, actual := "abc", rec.Header().Get(httpHeaderTaskID); expected != actual {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+
+		lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+		if expected, actual := 2, len(lines); expected != actual {
+			t.Fatalf("expected: %d, actual: %d", expected, actual)
+		}
+		if !strings.HasPrefix(lines[0], "peer=a:80 ") || !strings.Contains(lines[0], "status_code=200") {
+			t.Errorf("unexpected line: %s", lines[0])
+		}
+		if !strings.Contains(lines[1], `error="500 Internal Server Error"`) {
+			t.Errorf("unexpected line: %s", lines[1])
+		}
+	})
+}
+
+func TestEncodeJSON(t *testing.T) {
+	t.Parallel()
+
+	t.Run("durations", func(t *testing.T) {
+		var (
+			rec = httptest.NewRecorder()
+			qr  = WaitQueryResult{
+				Params: WaitQueryParams{TaskID: "abc", Timeout: scheduler.Duration(time.Second)},
+				Task: TaskResult{
+					Retry:   scheduler.RetryPolicy{InitialBackoff: scheduler.Duration(100 * time.Millisecond)},
+					Timeout: scheduler.Duration(2 * time.Second),
+				},
+			}
+		)
+		qr.EncodeTo(rec, formatJSON)
+
+		body := rec.Body.String()
+		for _, v := range []string{`"timeout":"1s"`, `"initial_backoff":"100ms"`, `"request_timeout":"2s"`} {
+			if !strings.Contains(body, v) {
+				t.Errorf("expected: %s, actual: %s", v, body)
+			}
+		}
+
+		var res WaitQueryResult
+		if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := scheduler.Duration(time.Second), res.Params.Timeout; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		encodeJSON(rec, math.NaN())
+
+		if expected, actual := http.StatusInternalServerError, rec.Code; expected != actual {
+			t.Errorf("expected: %d, actual: %d", expected, actual)
+		}
+		if expected, actual := mimeJSON, rec.Header().Get("Content-Type"); strings.HasPrefix(actual, expected) {
+			t.Errorf("expected: not %s, actual: %s", expected, actual)
+		}
+	})
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	t.Parallel()
+
+	for _, testcase := range []struct {
+		url    string
+		accept string
+		format format
+	}{
+		{"http://example.com", "", formatText},
+		{"http://example.com", "text/plain", formatText},
+		{"http://example.com", "application/json", formatJSON},
+		{"http://example.com", "text/html, application/json;q=0.9", formatJSON},
+		{"http://example.com?format=json", "", formatJSON},
+		{"http://example.com?format=text", "application/json", formatText},
+	} {
+		r := httptest.NewRequest("GET", testcase.url, nil)
+		if testcase.accept != "" {
+			r.Header.Set("Accept", testcase.accept)
+		}
+
+		if expected, actual := testcase.format, negotiateFormat(r); expected != actual {
+			t.Errorf("(%q, %q): expected: %v, actual: %v", testcase.url, testcase.accept, expected, actual)
+		}
+	}
+}
+
+func TestTasksQueryParams(t *testing.T) {
+	t.Parallel()
+
+	t.Run("decode", func(t *testing.T) {
+		var (
+			qp     TasksQueryParams
+			u, err = url.Parse("http://example.com?status=pending&mode=parallel&client_id=2&since=2017-05-12T12:00:00Z&cursor=abc&limit=10&order=desc")
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := qp.DecodeFrom(u, queryOptional); err != nil {
+			t.Fatal(err)
+		}
+
+		q := qp.TaskQuery()
+		if q.Status != scheduler.TaskStatusTypePending ||
+			q.Mode != scheduler.ModeTypeParallel ||
+			q.ClientID == nil || *q.ClientID != 2 ||
+			q.Since.IsZero() || !q.Until.IsZero() ||
+			q.Cursor != "abc" ||
+			q.Limit != 10 ||
+			q.Order != scheduler.SortOrderDesc {
+			t.Errorf("unexpected query: %v", q)
+		}
+	})
+
+	t.Run("decode defaults", func(t *testing.T) {
+		var (
+			qp     TasksQueryParams
+			u, err = url.Parse("http://example.com")
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := qp.DecodeFrom(u, queryOptional); err != nil {
+			t.Fatal(err)
+		}
+
+		if qp.Limit != defaultTasksLimit || qp.Order != string(scheduler.SortOrderAsc) || qp.ClientID != nil {
+			t.Errorf("unexpected params: %v", qp)
+		}
+	})
+
+	t.Run("decode invalid", func(t *testing.T) {
+		for _, query := range []string{
+			"status=bad",
+			"mode=bad",
+			"client_id=bad",
+			"since=bad",
+			"until=bad",
+			"limit=0",
+			"limit=100000",
+			"order=bad",
+		} {
+			var (
+				qp     TasksQueryParams
+				u, err = url.Parse("http://example.com?" + query)
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := qp.DecodeFrom(u, queryOptional); err == nil {
+				t.Errorf("%s: expected error", query)
+			}
+		}
+	})
+}
+
+func TestWaitQueryParams(t *testing.T) {
+	t.Parallel()
+
+	for _, testcase := range []struct {
+		query   string
+		timeout scheduler.Duration
+		valid   bool
+	}{
+		{"task_id=abc", defaultWaitTimeout, true},
+		{"task_id=abc&timeout=1s", scheduler.Duration(time.Second), true},
+		{"task_id=abc&timeout=bad", 0, false},
+		{"task_id=abc&timeout=-1s", 0, false},
+		{"task_id=abc&timeout=1h", 0, false},
+		{"timeout=1s", 0, false},
+	} {
+		var (
+			qp     WaitQueryParams
+			u, err = url.Parse("http://example.com?" + testcase.query)
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = qp.DecodeFrom(u, queryRequired)
+		if testcase.valid != (err == nil) {
+			t.Errorf("%s: unexpected error: %v", testcase.query, err)
+			continue
+		}
+		if testcase.valid && qp.Timeout != testcase.timeout {
+			t.Errorf("%s: expected: %s, actual: %s", testcase.query, testcase.timeout, qp.Timeout)
+		}
+	}
+}
+
+func TestPeerQueryParams(t *testing.T) {
+	t.Parallel()
+
+	for _, testcase := range []struct {
+		query string
+		valid bool
+	}{
+		{"addr=127.0.0.1:8080", true},
+		{"addr=localhost:8080", true},
+		{"addr=[::1]:8080", true},
+		{"addr=localhost", false},
+		{"", false},
+	} {
+		var (
+			qp     PeerQueryParams
+			u, err = url.Parse("http://example.com?" + testcase.query)
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = qp.DecodeFrom(u, queryRequired)
+		if testcase.valid != (err == nil) {
+			t.Errorf("%s: unexpected error: %v", testcase.query, err)
+		}
+	}
 }
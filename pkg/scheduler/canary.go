@@ -0,0 +1,58 @@
+package scheduler
+
+import (
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CanaryPolicy defines how a Task is tried out on a few peers, before it's
+// promoted to the rest of the peers, when in canary mode.
+type CanaryPolicy struct {
+	// Fraction of the peers that are sent the Task info first, where zero
+	// means a single peer.
+	Fraction float64 `json:"fraction"`
+
+	// Soak is how long the canary peers have to stay healthy for, before the
+	// Task is promoted.
+	Soak Duration `json:"soak"`
+
+	// Interval between each health check of the canary peers, whilst soaking.
+	Interval Duration `json:"interval"`
+}
+
+// DefaultCanaryPolicy tries out the Task on a single peer, checking it every
+// 5 seconds for 30 seconds.
+func DefaultCanaryPolicy() CanaryPolicy {
+	return CanaryPolicy{
+		Soak:     Duration(30 * time.Second),
+		Interval: Duration(5 * time.Second),
+	}
+}
+
+// Validate the CanaryPolicy, to make sure that it's usable.
+func (p CanaryPolicy) Validate() error {
+	switch {
+	case p.Fraction < 0 || p.Fraction > 1:
+		return errors.New("canary fraction must be between 0 and 1")
+	case p.Soak < 0:
+		return errors.New("canary soak must not be negative")
+	case p.Interval <= 0:
+		return errors.New("canary interval must be positive")
+	}
+	return nil
+}
+
+// Size returns how many of the peers are canaries, which is always at least
+// one peer.
+func (p CanaryPolicy) Size(peers int) int {
+	n := int(math.Ceil(p.Fraction * float64(peers)))
+	if n < 1 {
+		n = 1
+	}
+	if n > peers {
+		n = peers
+	}
+	return n
+}
@@ -0,0 +1,49 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCanaryPolicy(t *testing.T) {
+	t.Parallel()
+
+	t.Run("validate", func(t *testing.T) {
+		if err := DefaultCanaryPolicy().Validate(); err != nil {
+			t.Errorf("expected: nil, actual: %v", err)
+		}
+
+		for _, fn := range []func(*CanaryPolicy){
+			func(p *CanaryPolicy) { p.Fraction = -0.1 },
+			func(p *CanaryPolicy) { p.Fraction = 1.1 },
+			func(p *CanaryPolicy) { p.Soak = Duration(-time.Second) },
+			func(p *CanaryPolicy) { p.Interval = 0 },
+		} {
+			policy := DefaultCanaryPolicy()
+			fn(&policy)
+			if err := policy.Validate(); err == nil {
+				t.Errorf("expected: error, actual: %v", err)
+			}
+		}
+	})
+
+	t.Run("size", func(t *testing.T) {
+		for _, testcase := range []struct {
+			fraction float64
+			peers    int
+			size     int
+		}{
+			{0, 5, 1},
+			{0.2, 5, 1},
+			{0.3, 5, 2},
+			{0.5, 4, 2},
+			{1, 3, 3},
+			{0.5, 0, 0},
+		} {
+			policy := CanaryPolicy{Fraction: testcase.fraction}
+			if expected, actual := testcase.size, policy.Size(testcase.peers); expected != actual {
+				t.Errorf("expected: %d, actual: %d", expected, actual)
+			}
+		}
+	})
+}
@@ -0,0 +1,51 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Duration is a time.Duration that's encoded to JSON as a string (e.g. "1.5s"),
+// rather than as integer nanoseconds. Integer nanoseconds can still be decoded,
+// so that tasks stored before are still read.
+type Duration time.Duration
+
+// String returns the Duration formatted like a time.Duration (e.g. "1.5s").
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// MarshalJSON encodes the Duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON decodes the Duration from either a string or integer
+// nanoseconds.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var n int64
+	if err := json.Unmarshal(b, &n); err == nil {
+		*d = Duration(n)
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return errors.Errorf("invalid duration %s", b)
+	}
+	res, err := ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = res
+	return nil
+}
+
+// ParseDuration parses a Duration from a string, in the same way as
+// time.ParseDuration.
+func ParseDuration(s string) (Duration, error) {
+	d, err := time.ParseDuration(s)
+	return Duration(d), err
+}
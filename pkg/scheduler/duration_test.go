@@ -0,0 +1,82 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestDuration(t *testing.T) {
+	t.Parallel()
+
+	t.Run("marshal", func(t *testing.T) {
+		b, err := json.Marshal(Duration(1500 * time.Millisecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := `"1.5s"`, string(b); expected != actual {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+	})
+
+	t.Run("string", func(t *testing.T) {
+		if expected, actual := "1.5s", Duration(1500*time.Millisecond).String(); expected != actual {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+	})
+
+	t.Run("unmarshal", func(t *testing.T) {
+		for _, testcase := range []struct {
+			input    string
+			expected time.Duration
+			valid    bool
+		}{
+			{`"1.5s"`, 1500 * time.Millisecond, true},
+			{`1500000000`, 1500 * time.Millisecond, true},
+			{`"1.5"`, 0, false},
+			{`true`, 0, false},
+		} {
+			var d Duration
+			err := json.Unmarshal([]byte(testcase.input), &d)
+			if testcase.valid != (err == nil) {
+				t.Errorf("%s: unexpected error: %v", testcase.input, err)
+				continue
+			}
+			if expected, actual := testcase.expected, time.Duration(d); testcase.valid && expected != actual {
+				t.Errorf("%s: expected: %v, actual: %v", testcase.input, expected, actual)
+			}
+		}
+	})
+
+	t.Run("policies", func(t *testing.T) {
+		var (
+			canary = CanaryPolicy{Fraction: 0.5, Soak: Duration(time.Minute), Interval: Duration(time.Second)}
+			res    CanaryPolicy
+		)
+		b, err := json.Marshal(canary)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := `{"fraction":0.5,"soak":"1m0s","interval":"1s"}`, string(b); expected != actual {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+		if err := json.Unmarshal(b, &res); err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := canary, res; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+
+		// Policies that were stored with integer nanoseconds are still read.
+		var retry RetryPolicy
+		if err := json.Unmarshal([]byte(`{"max_attempts":2,"initial_backoff":100000000}`), &retry); err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := Duration(100*time.Millisecond), retry.InitialBackoff; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := 2, retry.MaxAttempts; expected != actual {
+			t.Errorf("expected: %d, actual: %d", expected, actual)
+		}
+	})
+}
@@ -0,0 +1,103 @@
+package scheduler
+
+import (
+	"sync"
+	"time"
+)
+
+// EventType defines what changed with in a Task.
+type EventType string
+
+const (
+	// EventTypeStatus is sent when the status of a Task changes.
+	EventTypeStatus EventType = "status"
+
+	// EventTypePhase is sent when the phase of a Task changes.
+	EventTypePhase EventType = "phase"
+
+	// EventTypeAttempt is sent when a request to a peer has completed.
+	EventTypeAttempt EventType = "attempt"
+)
+
+// Event describes a change with in a Task.
+type Event struct {
+	ID      uint64         `json:"id"`
+	Type    EventType      `json:"type"`
+	TaskID  string         `json:"task_id"`
+	Status  TaskStatusType `json:"status"`
+	Phase   TaskPhaseType  `json:"phase,omitempty"`
+	Attempt *Attempt       `json:"attempt,omitempty"`
+	Time    time.Time      `json:"time"`
+}
+
+// eventBufferSize defines how many events a subscriber can fall behind before
+// it's disconnected.
+const eventBufferSize = 128
+
+// broadcaster fans events out to all the subscribers.
+type broadcaster struct {
+	mutex       sync.Mutex
+	seq         uint64
+	subscribers map[*subscriber]struct{}
+}
+
+type subscriber struct {
+	taskID string
+	events chan Event
+}
+
+func newBroadcaster() *broadcaster {
+	return &broadcaster{
+		mutex:       sync.Mutex{},
+		subscribers: make(map[*subscriber]struct{}),
+	}
+}
+
+// subscribe to the events of a task, or all the tasks if the taskID is empty.
+// The returned channel is closed once the subscription is cancelled or if the
+// subscriber falls too far behind.
+func (b *broadcaster) subscribe(taskID string) (<-chan Event, func()) {
+	sub := &subscriber{
+		taskID: taskID,
+		events: make(chan Event, eventBufferSize),
+	}
+
+	b.mutex.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mutex.Unlock()
+
+	return sub.events, func() {
+		b.mutex.Lock()
+		b.remove(sub)
+		b.mutex.Unlock()
+	}
+}
+
+func (b *broadcaster) publish(e Event) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.seq++
+	e.ID = b.seq
+
+	for sub := range b.subscribers {
+		if sub.taskID != "" && sub.taskID != e.TaskID {
+			continue
+		}
+
+		select {
+		case sub.events <- e:
+		default:
+			// The subscriber can't keep up, so rather than block every other
+			// subscriber, disconnect it.
+			b.remove(sub)
+		}
+	}
+}
+
+func (b *broadcaster) remove(sub *subscriber) {
+	if _, ok := b.subscribers[sub]; ok {
+		delete(b.subscribers, sub)
+		close(sub.events)
+	}
+}
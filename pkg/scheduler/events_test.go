@@ -0,0 +1,68 @@
+package scheduler
+
+import (
+	"testing"
+)
+
+func TestBroadcaster(t *testing.T) {
+	t.Parallel()
+
+	t.Run("publish", func(t *testing.T) {
+		var (
+			b              = newBroadcaster()
+			all, cancelAll = b.subscribe("")
+			one, cancelOne = b.subscribe("a")
+		)
+		defer cancelAll()
+		defer cancelOne()
+
+		b.publish(Event{Type: EventTypeStatus, TaskID: "a", Status: TaskStatusTypePending})
+		b.publish(Event{Type: EventTypeStatus, TaskID: "b", Status: TaskStatusTypePending})
+
+		for _, expected := range []string{"a", "b"} {
+			if e := <-all; e.TaskID != expected {
+				t.Errorf("expected: %s, actual: %s", expected, e.TaskID)
+			}
+		}
+
+		if e := <-one; e.TaskID != "a" || e.ID != 1 {
+			t.Errorf("unexpected event: %v", e)
+		}
+		select {
+		case e := <-one:
+			t.Errorf("unexpected event: %v", e)
+		default:
+		}
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		b := newBroadcaster()
+		events, cancel := b.subscribe("")
+		cancel()
+		cancel()
+
+		if _, ok := <-events; ok {
+			t.Errorf("expected: closed, actual: open")
+		}
+
+		b.publish(Event{Type: EventTypeStatus, TaskID: "a"})
+	})
+
+	t.Run("slow subscriber", func(t *testing.T) {
+		b := newBroadcaster()
+		events, cancel := b.subscribe("")
+		defer cancel()
+
+		for i := 0; i < eventBufferSize+1; i++ {
+			b.publish(Event{Type: EventTypeStatus, TaskID: "a"})
+		}
+
+		var count int
+		for range events {
+			count++
+		}
+		if expected, actual := eventBufferSize, count; expected != actual {
+			t.Errorf("expected: %d, actual: %d", expected, actual)
+		}
+	})
+}
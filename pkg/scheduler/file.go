@@ -0,0 +1,560 @@
+package scheduler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultCompactSize is the size of a shared log, before it's compacted.
+	defaultCompactSize = 1 << 20
+
+	// compactGrowth is how many times larger the shared log has to grow, from
+	// when it was last compacted, before it's compacted again.
+	compactGrowth = 2
+)
+
+// FileTaskStore keeps all the Tasks in memory, but also appends every change to
+// a log file on disk. Opening the same file again replays the log, so Tasks
+// survive a restart of the Scheduler.
+type FileTaskStore struct {
+	mutex  sync.Mutex
+	memory *MemoryTaskStore
+	path   string
+	file   *os.File
+	shared bool
+	offset int64
+
+	// When shared, the log is compacted once it's at least compactSize and
+	// it has grown enough since it was compacted (at the compacted size).
+	compactSize int64
+	compacted   int64
+
+	// replacing is set once the log has been compacted by another store, so
+	// the compacted log replaces the Tasks of this store as it's replayed.
+	replacing bool
+}
+
+// NewFileTaskStore opens (or creates) the log file found at the path and
+// replays it.
+// Any Task that was still requesting when the log was last written is set back
+// to pending, so that it can be resumed by the Scheduler.
+func NewFileTaskStore(path string) (*FileTaskStore, error) {
+	memory := NewMemoryTaskStore()
+	if err := replay(path, memory); err != nil {
+		return nil, err
+	}
+
+	// Compact the log, so it only holds one entry per Task.
+	if err := compact(path, memory); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileTaskStore{
+		mutex:  sync.Mutex{},
+		memory: memory,
+		path:   path,
+		file:   file,
+	}, nil
+}
+
+// NewSharedFileTaskStore opens (or creates) the log file found at the path,
+// which can be shared by the schedulers of many proxies at once.
+// The log file is locked for every change, which is only made once the changes
+// appended by the other stores have been replayed, so every store sees the
+// same Tasks. Unlike NewFileTaskStore, requesting Tasks are left alone, as
+// another proxy could still be executing them, and the log is only compacted
+// once it has grown enough.
+func NewSharedFileTaskStore(path string) (*FileTaskStore, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &FileTaskStore{
+		mutex:       sync.Mutex{},
+		memory:      NewMemoryTaskStore(),
+		path:        path,
+		file:        file,
+		shared:      true,
+		compactSize: defaultCompactSize,
+	}
+	if err := store.sync(func() error { return nil }); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// Put a new Task into the store.
+func (s *FileTaskStore) Put(task *Task) error {
+	return s.sync(func() error {
+		if err := s.memory.Put(task); err != nil {
+			return err
+		}
+
+		record := task.record()
+		return s.append(entry{Op: opPut, Task: &record})
+	})
+}
+
+// Get a Task by an ID.
+func (s *FileTaskStore) Get(id string) (*Task, error) {
+	if err := s.refresh(); err != nil {
+		return nil, err
+	}
+	return s.memory.Get(id)
+}
+
+// List all the Tasks in the order that they were put into the store.
+func (s *FileTaskStore) List() ([]*Task, error) {
+	if err := s.refresh(); err != nil {
+		return nil, err
+	}
+	return s.memory.List()
+}
+
+// Query the Tasks, returning the Tasks that match and a cursor for the next
+// page of Tasks.
+func (s *FileTaskStore) Query(q TaskQuery) ([]*Task, string, error) {
+	if err := s.refresh(); err != nil {
+		return nil, "", err
+	}
+	return s.memory.Query(q)
+}
+
+// UpdateStatus of a Task, that's already been put into the store.
+// When shared, a Task that's finished (i.e. cancelled by another proxy) keeps
+// the status it first finished with.
+func (s *FileTaskStore) UpdateStatus(id string, status TaskStatusType) error {
+	return s.sync(func() error {
+		if current, ok := s.memory.status(id); ok && s.shared && current.Terminal() {
+			task, err := s.memory.Get(id)
+			if err != nil {
+				return err
+			}
+			task.SetStatus(current)
+			return nil
+		}
+
+		if err := s.memory.UpdateStatus(id, status); err != nil {
+			return err
+		}
+
+		return s.append(entry{Op: opStatus, ID: id, Status: status})
+	})
+}
+
+// UpdatePhase of a Task, that's already been put into the store.
+func (s *FileTaskStore) UpdatePhase(id string, phase TaskPhaseType) error {
+	return s.sync(func() error {
+		if err := s.memory.UpdatePhase(id, phase); err != nil {
+			return err
+		}
+
+		return s.append(entry{Op: opPhase, ID: id, Phase: phase})
+	})
+}
+
+// AddAttempt to a Task, that's already been put into the store.
+func (s *FileTaskStore) AddAttempt(id string, attempt Attempt) error {
+	return s.sync(func() error {
+		if err := s.memory.AddAttempt(id, attempt); err != nil {
+			return err
+		}
+
+		return s.append(entry{Op: opAttempt, ID: id, Attempt: &attempt})
+	})
+}
+
+// Claim a lease on a Task for the owner, if the Task isn't finished and isn't
+// leased by any owner.
+func (s *FileTaskStore) Claim(id, owner string, ttl time.Duration) error {
+	return s.sync(func() error {
+		lease, err := s.memory.claim(id, owner, ttl, time.Now())
+		if err != nil {
+			return err
+		}
+
+		return s.append(entry{Op: opLease, ID: id, Lease: &lease})
+	})
+}
+
+// Renew the lease on a Task held by the owner.
+func (s *FileTaskStore) Renew(id, owner string, ttl time.Duration) error {
+	return s.sync(func() error {
+		lease, err := s.memory.renew(id, owner, ttl, time.Now())
+		if err != nil {
+			return err
+		}
+
+		return s.append(entry{Op: opLease, ID: id, Lease: &lease})
+	})
+}
+
+// Release the lease on a Task held by the owner.
+func (s *FileTaskStore) Release(id, owner string) error {
+	return s.sync(func() error {
+		if _, err := s.memory.Get(id); err != nil {
+			return err
+		}
+		if s.memory.lease(id).Owner != owner {
+			return nil
+		}
+
+		if err := s.memory.setLease(id, Lease{}); err != nil {
+			return err
+		}
+		return s.append(entry{Op: opLease, ID: id, Lease: &Lease{}})
+	})
+}
+
+// Close the store.
+func (s *FileTaskStore) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.file.Close()
+}
+
+// refresh replays any changes made by the other stores sharing the log file.
+// The log file is only read (holding a shared lock) if it has changed since it
+// was last read.
+func (s *FileTaskStore) refresh() error {
+	if !s.shared {
+		return nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if changed, err := s.changed(); err != nil || !changed {
+		return err
+	}
+
+	if err := s.lock(lockFileShared); err != nil {
+		return err
+	}
+	defer func() { unlockFile(s.file) }()
+
+	return s.tail()
+}
+
+// sync calls the function whilst holding the store (and when shared, the log
+// file) lock. When shared, the changes made by the other stores are replayed
+// before the function is called and the log is compacted afterwards, if it
+// has grown enough.
+func (s *FileTaskStore) sync(fn func() error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.shared {
+		return fn()
+	}
+
+	if err := s.lock(lockFile); err != nil {
+		return err
+	}
+	defer func() { unlockFile(s.file) }()
+
+	if err := s.tail(); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+
+	// Skip over anything this store appended.
+	info, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+	s.offset = info.Size()
+
+	if s.offset >= s.compactSize && s.offset >= compactGrowth*s.compacted {
+		return s.compact()
+	}
+	return nil
+}
+
+// changed defines if the log file has been appended to or compacted by another
+// store, since it was last read.
+// It expects the store lock to be held.
+func (s *FileTaskStore) changed() (bool, error) {
+	info, err := s.file.Stat()
+	if err != nil {
+		return false, err
+	}
+	if info.Size() != s.offset {
+		return true, nil
+	}
+
+	current, err := os.Stat(s.path)
+	if err != nil {
+		return false, err
+	}
+	return !os.SameFile(info, current), nil
+}
+
+// lock the log file using the locker. If another store compacted the log file
+// before the lock was taken, then the compacted log file is opened and locked
+// instead, which is then replayed from the start.
+// It expects the store lock to be held.
+func (s *FileTaskStore) lock(locker func(*os.File) error) error {
+	for {
+		if err := locker(s.file); err != nil {
+			return errors.Wrapf(err, "lock task log %q", s.path)
+		}
+
+		info, err := s.file.Stat()
+		if err != nil {
+			unlockFile(s.file)
+			return err
+		}
+		current, err := os.Stat(s.path)
+		if err != nil {
+			unlockFile(s.file)
+			return err
+		}
+		if os.SameFile(info, current) {
+			return nil
+		}
+
+		file, err := os.OpenFile(s.path, os.O_RDWR|os.O_APPEND, 0644)
+		unlockFile(s.file)
+		if err != nil {
+			return err
+		}
+		s.file.Close()
+		s.file, s.offset, s.compacted, s.replacing = file, 0, current.Size(), true
+	}
+}
+
+// compact the shared log file, which is replaced by a log file holding one
+// entry per Task (and lease). The other stores open the compacted log file
+// once they next lock the log file.
+// It expects the locks to be held.
+func (s *FileTaskStore) compact() error {
+	if err := compact(s.path, s.memory); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(s.path, os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	// Closing the replaced log file also releases the lock on it.
+	unlockFile(s.file)
+	s.file.Close()
+	s.file, s.offset, s.compacted = file, info.Size(), info.Size()
+	return nil
+}
+
+// tail replays every complete entry that was appended to the log file since
+// the last time it was read.
+// It expects the locks to be held.
+func (s *FileTaskStore) tail() error {
+	info, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() <= s.offset {
+		s.replacing = false
+		return nil
+	}
+	// Anything appended after the compacted log is replayed as usual.
+	defer func() { s.replacing = false }()
+
+	b := make([]byte, info.Size()-s.offset)
+	if _, err := s.file.ReadAt(b, s.offset); err != nil && err != io.EOF {
+		return err
+	}
+
+	for {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			// A partially written line is ignored, as it was never committed.
+			return nil
+		}
+
+		if err := apply(s.path, s.memory, b[:i+1], s.replacing); err != nil {
+			return err
+		}
+		s.offset += int64(i + 1)
+		b = b[i+1:]
+	}
+}
+
+func (s *FileTaskStore) append(e entry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.file.Write(append(b, '\n'))
+	return err
+}
+
+type opType string
+
+const (
+	opPut     opType = "put"
+	opStatus  opType = "status"
+	opPhase   opType = "phase"
+	opAttempt opType = "attempt"
+	opLease   opType = "lease"
+)
+
+// entry defines a single line with in the log file.
+type entry struct {
+	Op      opType         `json:"op"`
+	Task    *taskRecord    `json:"task,omitempty"`
+	ID      string         `json:"id,omitempty"`
+	Status  TaskStatusType `json:"status,omitempty"`
+	Phase   TaskPhaseType  `json:"phase,omitempty"`
+	Attempt *Attempt       `json:"attempt,omitempty"`
+	Lease   *Lease         `json:"lease,omitempty"`
+}
+
+func replay(path string, memory *MemoryTaskStore) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// A partially written line is ignored, as it was never committed.
+			break
+		} else if err != nil {
+			return err
+		}
+
+		if err := apply(path, memory, line, false); err != nil {
+			return err
+		}
+	}
+
+	tasks, err := memory.List()
+	if err != nil {
+		return err
+	}
+	for _, v := range tasks {
+		if v.Status() == TaskStatusTypeRequesting {
+			if err := memory.UpdateStatus(v.ID(), TaskStatusTypePending); err != nil {
+				return err
+			}
+		}
+
+		// Nobody else can be holding a lease, as the log isn't shared.
+		if err := memory.setLease(v.ID(), Lease{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply a single line of the log file to the memory store. When replacing, a
+// put replaces any Task that's already in the memory store.
+func apply(path string, memory *MemoryTaskStore, line []byte, replacing bool) error {
+	var e entry
+	if err := json.Unmarshal(line, &e); err != nil {
+		return errors.Wrapf(err, "corrupt task log %q", path)
+	}
+
+	switch e.Op {
+	case opPut:
+		if e.Task == nil {
+			return errors.Errorf("corrupt task log %q: missing task", path)
+		}
+		if replacing {
+			memory.replace(newTaskFromRecord(*e.Task))
+			return nil
+		}
+		return memory.Put(newTaskFromRecord(*e.Task))
+	case opStatus:
+		return memory.UpdateStatus(e.ID, e.Status)
+	case opPhase:
+		return memory.UpdatePhase(e.ID, e.Phase)
+	case opAttempt:
+		if e.Attempt == nil {
+			return errors.Errorf("corrupt task log %q: missing attempt", path)
+		}
+		return memory.AddAttempt(e.ID, *e.Attempt)
+	case opLease:
+		if e.Lease == nil {
+			return errors.Errorf("corrupt task log %q: missing lease", path)
+		}
+		return memory.setLease(e.ID, *e.Lease)
+	default:
+		return errors.Errorf("corrupt task log %q: unknown op %q", path, e.Op)
+	}
+}
+
+func compact(path string, memory *MemoryTaskStore) error {
+	tasks, err := memory.List()
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp"))
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	for _, v := range tasks {
+		record := v.record()
+		entries := []entry{{Op: opPut, Task: &record}}
+		if lease := memory.lease(v.ID()); lease.Owner != "" {
+			entries = append(entries, entry{Op: opLease, ID: v.ID(), Lease: &lease})
+		}
+
+		for _, e := range entries {
+			b, err := json.Marshal(e)
+			if err != nil {
+				file.Close()
+				return err
+			}
+			writer.Write(append(b, '\n'))
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
@@ -0,0 +1,324 @@
+package scheduler
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileTaskStore(t *testing.T) {
+	t.Parallel()
+
+	t.Run("reopen", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "tasks")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "tasks.log")
+		store, err := NewFileTaskStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var (
+			pending    = NewTask(ModeTypeSequential, 1, "a", true)
+			requesting = NewTask(ModeTypeParallel, 0, "b", false)
+			completed  = NewTask(ModeTypeSequential, 0, "c", false)
+		)
+		for _, v := range []*Task{pending, requesting, completed} {
+			if err := store.Put(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := store.UpdateStatus(requesting.ID(), TaskStatusTypeRequesting); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.UpdateStatus(completed.ID(), TaskStatusTypeCompleted); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.AddAttempt(completed.ID(), Attempt{Peer: "a", StatusCode: 200}); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.UpdatePhase(completed.ID(), TaskPhaseTypePromoted); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		store, err = NewFileTaskStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+
+		tasks, err := store.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := 3, len(tasks); expected != actual {
+			t.Fatalf("expected: %d, actual: %d", expected, actual)
+		}
+
+		for _, testcase := range []struct {
+			task   *Task
+			status TaskStatusType
+		}{
+			{pending, TaskStatusTypePending},
+			{requesting, TaskStatusTypePending},
+			{completed, TaskStatusTypeCompleted},
+		} {
+			task, err := store.Get(testcase.task.ID())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected, actual := testcase.task.record(), task.record(); expected.ID != actual.ID ||
+				expected.Mode != actual.Mode ||
+				expected.ClientID != actual.ClientID ||
+				expected.Info != actual.Info ||
+				expected.FailOnError != actual.FailOnError {
+				t.Errorf("expected: %v, actual: %v", expected, actual)
+			}
+			if expected, actual := testcase.status, task.Status(); expected != actual {
+				t.Errorf("expected: %s, actual: %s", expected, actual)
+			}
+		}
+
+		task, err := store.Get(completed.ID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attempts := task.Attempts(); len(attempts) != 1 || attempts[0].Peer != "a" {
+			t.Errorf("unexpected attempts: %v", attempts)
+		}
+		if expected, actual := TaskPhaseTypePromoted, task.Phase(); expected != actual {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+	})
+
+	t.Run("partial write", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "tasks")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "tasks.log")
+		if err := ioutil.WriteFile(path, []byte(`{"op":"put","task":{"id":"a"`), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		store, err := NewFileTaskStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+
+		tasks, err := store.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := 0, len(tasks); expected != actual {
+			t.Errorf("expected: %d, actual: %d", expected, actual)
+		}
+	})
+
+	t.Run("corrupt", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "tasks")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "tasks.log")
+		if err := ioutil.WriteFile(path, []byte("bad\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := NewFileTaskStore(path); err == nil {
+			t.Errorf("expected: error, actual: %v", err)
+		}
+	})
+
+	t.Run("shared", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "tasks")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "tasks.log")
+		a, err := NewSharedFileTaskStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer a.Close()
+
+		b, err := NewSharedFileTaskStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer b.Close()
+
+		task := NewTask(ModeTypeSequential, 0, "info", false)
+		if err := a.Put(task); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.UpdateStatus(task.ID(), TaskStatusTypeRequesting); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.AddAttempt(task.ID(), Attempt{Peer: "a", StatusCode: 200}); err != nil {
+			t.Fatal(err)
+		}
+
+		tsk, err := b.Get(task.ID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := TaskStatusTypeRequesting, tsk.Status(); expected != actual {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+		if expected, actual := 1, len(tsk.Attempts()); expected != actual {
+			t.Errorf("expected: %d, actual: %d", expected, actual)
+		}
+
+		// Only one of the stores can claim the task at once.
+		if err := a.Claim(task.ID(), "a", time.Minute); err != nil {
+			t.Error(err)
+		}
+		if err := b.Claim(task.ID(), "b", time.Minute); err != ErrTaskClaimed {
+			t.Errorf("expected: %v, actual: %v", ErrTaskClaimed, err)
+		}
+		if err := a.Release(task.ID(), "a"); err != nil {
+			t.Error(err)
+		}
+		if err := b.Claim(task.ID(), "b", time.Minute); err != nil {
+			t.Error(err)
+		}
+		if err := a.Renew(task.ID(), "a", time.Minute); err != ErrLeaseLost {
+			t.Errorf("expected: %v, actual: %v", ErrLeaseLost, err)
+		}
+
+		// A finished task can't be set back to requesting.
+		if err := b.UpdateStatus(task.ID(), TaskStatusTypeCancelled); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.UpdateStatus(task.ID(), TaskStatusTypeRequesting); err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := TaskStatusTypeCancelled, task.Status(); expected != actual {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+
+		// Nor can it finish again, so the cancel wins.
+		if err := a.UpdateStatus(task.ID(), TaskStatusTypeCompleted); err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := TaskStatusTypeCancelled, task.Status(); expected != actual {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+
+		// Reopening keeps the leases, as the other store is still around.
+		c, err := NewSharedFileTaskStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+
+		if expected, actual := "b", c.memory.lease(task.ID()).Owner; expected != actual {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+		if tsk, err := c.Get(task.ID()); err != nil || tsk.Status() != TaskStatusTypeCancelled {
+			t.Errorf("expected: %s, actual: %v", TaskStatusTypeCancelled, err)
+		}
+	})
+
+	t.Run("shared compact", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "tasks")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "tasks.log")
+		a, err := NewSharedFileTaskStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer a.Close()
+
+		b, err := NewSharedFileTaskStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer b.Close()
+
+		task := NewTask(ModeTypeSequential, 0, "info", false)
+		if err := a.Put(task); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.Claim(task.ID(), "a", time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		tsk, err := b.Get(task.ID())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Compact the log, as soon as anything else is appended.
+		a.compactSize = 1
+		if err := a.AddAttempt(task.ID(), Attempt{Peer: "a", StatusCode: 200}); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.UpdateStatus(task.ID(), TaskStatusTypeCompleted); err != nil {
+			t.Fatal(err)
+		}
+
+		log, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := 0, bytes.Count(log, []byte(`"op":"attempt"`)); expected != actual {
+			t.Errorf("expected: %d, actual: %d", expected, actual)
+		}
+
+		// The other store replays the compacted log into the same task.
+		if _, err := b.Get(task.ID()); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-tsk.Done():
+		default:
+			t.Errorf("expected: done, actual: %s", tsk.Status())
+		}
+		if expected, actual := 1, len(tsk.Attempts()); expected != actual {
+			t.Errorf("expected: %d, actual: %d", expected, actual)
+		}
+		if expected, actual := "a", b.memory.lease(task.ID()).Owner; expected != actual {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+
+		// Both stores carry on appending to the compacted log.
+		if err := b.AddAttempt(task.ID(), Attempt{Peer: "b", StatusCode: 200}); err != nil {
+			t.Fatal(err)
+		}
+		if tsk, err := a.Get(task.ID()); err != nil || len(tsk.Attempts()) != 2 {
+			t.Errorf("expected: 2 attempts, actual: %v", err)
+		}
+
+		c, err := NewSharedFileTaskStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+
+		if tsk, err := c.Get(task.ID()); err != nil || tsk.Status() != TaskStatusTypeCompleted || len(tsk.Attempts()) != 2 {
+			t.Errorf("expected: %s with 2 attempts, actual: %v", TaskStatusTypeCompleted, err)
+		}
+	})
+}
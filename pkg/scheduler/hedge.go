@@ -0,0 +1,88 @@
+package scheduler
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/SimonRichardson/cmdproxy/pkg/peer"
+	"github.com/pkg/errors"
+)
+
+// HedgePolicy defines when a duplicate request is sent to another peer, if the
+// request to a peer hasn't answered in time, when in one or quorum mode.
+type HedgePolicy struct {
+	// Delay is how long to wait for an answer before hedging.
+	Delay Duration `json:"delay"`
+
+	// Percentile (between 0 and 100) of the recent latencies of the peer to
+	// wait for before hedging, which takes precedence over the Delay once the
+	// peer has answered enough requests.
+	Percentile float64 `json:"percentile"`
+}
+
+// Enabled defines if requests are hedged at all.
+func (p HedgePolicy) Enabled() bool {
+	return p.Delay > 0 || p.Percentile > 0
+}
+
+// Validate the HedgePolicy, to make sure that it's usable.
+func (p HedgePolicy) Validate() error {
+	switch {
+	case p.Delay < 0:
+		return errors.New("hedge delay must not be negative")
+	case p.Percentile < 0 || p.Percentile > 100:
+		return errors.New("hedge percentile must be between 0 and 100")
+	}
+	return nil
+}
+
+// Threshold returns how long to wait for an answer from the peer before
+// hedging, which is false if the request to the peer isn't hedged.
+func (p HedgePolicy) Threshold(pr *peer.Peer) (time.Duration, bool) {
+	if p.Percentile > 0 {
+		if d, ok := pr.LatencyPercentile(p.Percentile); ok {
+			return d, true
+		}
+	}
+	if p.Delay > 0 {
+		return time.Duration(p.Delay), true
+	}
+	return 0, false
+}
+
+// leg is a request to a single peer whilst hedging, which is cancelled once
+// another request has won.
+type leg struct {
+	mutex   sync.Mutex
+	ctx     context.Context
+	stop    context.CancelFunc
+	request *peer.Request
+}
+
+func newLeg(ctx context.Context) *leg {
+	ctx, stop := context.WithCancel(ctx)
+	return &leg{
+		mutex: sync.Mutex{},
+		ctx:   ctx,
+		stop:  stop,
+	}
+}
+
+// track the request that's currently in flight.
+func (l *leg) track(r *peer.Request) {
+	l.mutex.Lock()
+	l.request = r
+	l.mutex.Unlock()
+}
+
+// cancel the request in flight, along with any retries.
+func (l *leg) cancel() {
+	l.mutex.Lock()
+	if l.request != nil {
+		l.request.Cancel()
+	}
+	l.mutex.Unlock()
+
+	l.stop()
+}
@@ -0,0 +1,89 @@
+package scheduler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SimonRichardson/cmdproxy/pkg/peer"
+	"github.com/go-kit/kit/log"
+)
+
+func TestHedgePolicy(t *testing.T) {
+	t.Parallel()
+
+	t.Run("validate", func(t *testing.T) {
+		if err := (HedgePolicy{}).Validate(); err != nil {
+			t.Errorf("expected: nil, actual: %v", err)
+		}
+
+		for _, policy := range []HedgePolicy{
+			{Delay: Duration(-time.Second)},
+			{Percentile: -1},
+			{Percentile: 101},
+		} {
+			if err := policy.Validate(); err == nil {
+				t.Errorf("expected: error, actual: %v", err)
+			}
+		}
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		for policy, expected := range map[HedgePolicy]bool{
+			{}:                             false,
+			{Delay: Duration(time.Second)}: true,
+			{Percentile: 95}:               true,
+		} {
+			if actual := policy.Enabled(); expected != actual {
+				t.Errorf("%v: expected: %t, actual: %t", policy, expected, actual)
+			}
+		}
+	})
+
+	t.Run("threshold", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+
+		p := peer.NewPeer(http.DefaultClient, "http", strings.Replace(server.URL, "http://", "", 1), log.NewNopLogger())
+
+		// Without enough latencies, the percentile falls back to the delay.
+		if _, ok := (HedgePolicy{Percentile: 95}).Threshold(p); ok {
+			t.Errorf("expected: no threshold")
+		}
+		if expected, actual := time.Second, threshold(t, HedgePolicy{Delay: Duration(time.Second), Percentile: 95}, p); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+
+		for i := 0; i < 20; i++ {
+			req, err := p.NewRequest("info")
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := req.Do()
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+		}
+
+		expected, ok := p.LatencyPercentile(95)
+		if !ok {
+			t.Fatal("expected: enough latencies")
+		}
+		if actual := threshold(t, HedgePolicy{Delay: Duration(time.Second), Percentile: 95}, p); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+}
+
+func threshold(t *testing.T, policy HedgePolicy, p *peer.Peer) time.Duration {
+	d, ok := policy.Threshold(p)
+	if !ok {
+		t.Fatal("expected: threshold")
+	}
+	return d
+}
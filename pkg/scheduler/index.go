@@ -0,0 +1,224 @@
+package scheduler
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidCursor is returned when a TaskQuery cursor doesn't match any Task.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// SortOrder defines the order in which Tasks are listed, by the time they were
+// created.
+type SortOrder string
+
+const (
+	// SortOrderAsc lists the oldest Tasks first.
+	SortOrderAsc SortOrder = "asc"
+
+	// SortOrderDesc lists the newest Tasks first.
+	SortOrderDesc SortOrder = "desc"
+)
+
+// ParseSortOrder takes a string and validates it against known SortOrders
+func ParseSortOrder(s string) (SortOrder, error) {
+	switch s {
+	case string(SortOrderAsc):
+		return SortOrderAsc, nil
+	case string(SortOrderDesc):
+		return SortOrderDesc, nil
+	default:
+		return SortOrder(""), errors.New("invalid sort order")
+	}
+}
+
+// TaskQuery filters the Tasks with in a TaskStore. Zero values don't filter
+// the Tasks.
+type TaskQuery struct {
+	Status   TaskStatusType
+	Mode     ModeType
+	ClientID *int
+
+	// Since and Until define the range of creation times (inclusive).
+	Since, Until time.Time
+
+	// Cursor is the ID of the last Task from the previous page.
+	Cursor string
+	Limit  int
+	Order  SortOrder
+}
+
+// index keeps a series of lookups for the Tasks, so that queries don't have to
+// scan every Task.
+// The index isn't safe for concurrent use, so the owner must guard it.
+type index struct {
+	tasks    []*Task
+	position map[string]int
+	status   map[string]TaskStatusType
+	statuses map[TaskStatusType]map[int]struct{}
+	modes    map[ModeType][]int
+	clients  map[int][]int
+}
+
+func newIndex() *index {
+	return &index{
+		tasks:    make([]*Task, 0),
+		position: make(map[string]int),
+		status:   make(map[string]TaskStatusType),
+		statuses: make(map[TaskStatusType]map[int]struct{}),
+		modes:    make(map[ModeType][]int),
+		clients:  make(map[int][]int),
+	}
+}
+
+func (i *index) get(id string) (*Task, bool) {
+	if pos, ok := i.position[id]; ok {
+		return i.tasks[pos], true
+	}
+	return nil, false
+}
+
+func (i *index) insert(task *Task) {
+	var (
+		id     = task.ID()
+		pos    = len(i.tasks)
+		status = task.Status()
+	)
+	i.tasks = append(i.tasks, task)
+	i.position[id] = pos
+	i.status[id] = status
+	i.addStatus(status, pos)
+	i.modes[task.Mode()] = append(i.modes[task.Mode()], pos)
+	i.clients[task.ClientID()] = append(i.clients[task.ClientID()], pos)
+}
+
+// update moves the task with in the status lookup, as it's the only field of a
+// Task that can change.
+func (i *index) update(id string, status TaskStatusType) {
+	pos, ok := i.position[id]
+	if !ok {
+		return
+	}
+
+	if current := i.status[id]; current != status {
+		delete(i.statuses[current], pos)
+		i.addStatus(status, pos)
+		i.status[id] = status
+	}
+}
+
+func (i *index) addStatus(status TaskStatusType, pos int) {
+	set, ok := i.statuses[status]
+	if !ok {
+		set = make(map[int]struct{})
+		i.statuses[status] = set
+	}
+	set[pos] = struct{}{}
+}
+
+// query returns the Tasks matching the TaskQuery and a cursor for the next
+// page. The cursor is empty when there are no more pages.
+func (i *index) query(q TaskQuery) ([]*Task, string, error) {
+	start := -1
+	if q.Cursor != "" {
+		pos, ok := i.position[q.Cursor]
+		if !ok {
+			return nil, "", ErrInvalidCursor
+		}
+		start = pos
+	}
+
+	// Start from the smallest lookup, then check the rest of the filters on
+	// each of the candidates.
+	candidates := i.candidates(q)
+	if q.Order == SortOrderDesc {
+		for l, r := 0, len(candidates)-1; l < r; l, r = l+1, r-1 {
+			candidates[l], candidates[r] = candidates[r], candidates[l]
+		}
+	}
+
+	var (
+		res  []*Task
+		next string
+	)
+	for _, pos := range candidates {
+		if start >= 0 {
+			if q.Order == SortOrderDesc && pos >= start {
+				continue
+			} else if q.Order != SortOrderDesc && pos <= start {
+				continue
+			}
+		}
+
+		task := i.tasks[pos]
+		if !i.match(task, q) {
+			continue
+		}
+
+		if q.Limit > 0 && len(res) == q.Limit {
+			next = res[len(res)-1].ID()
+			break
+		}
+		res = append(res, task)
+	}
+	return res, next, nil
+}
+
+// candidates returns the positions (in ascending order) of the smallest
+// lookup that matches the query.
+func (i *index) candidates(q TaskQuery) []int {
+	var (
+		res    []int
+		sorted = true
+		size   = len(i.tasks)
+	)
+	if q.Mode != "" && len(i.modes[q.Mode]) < size {
+		res, size = i.modes[q.Mode], len(i.modes[q.Mode])
+	}
+	if q.ClientID != nil && len(i.clients[*q.ClientID]) < size {
+		res, size = i.clients[*q.ClientID], len(i.clients[*q.ClientID])
+	}
+	if q.Status != "" && len(i.statuses[q.Status]) < size {
+		res, sorted = make([]int, 0, len(i.statuses[q.Status])), false
+		for pos := range i.statuses[q.Status] {
+			res = append(res, pos)
+		}
+	}
+
+	if res == nil && size == len(i.tasks) {
+		res = make([]int, len(i.tasks))
+		for k := range res {
+			res[k] = k
+		}
+		return res
+	}
+
+	// Make a copy, so the lookups aren't modified by the caller.
+	positions := make([]int, len(res))
+	copy(positions, res)
+	if !sorted {
+		sort.Ints(positions)
+	}
+	return positions
+}
+
+func (i *index) match(task *Task, q TaskQuery) bool {
+	if q.Status != "" && i.status[task.ID()] != q.Status {
+		return false
+	}
+	if q.Mode != "" && task.Mode() != q.Mode {
+		return false
+	}
+	if q.ClientID != nil && task.ClientID() != *q.ClientID {
+		return false
+	}
+	if !q.Since.IsZero() && task.Created().Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && task.Created().After(q.Until) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,135 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSortOrder(t *testing.T) {
+	t.Parallel()
+
+	for _, v := range []SortOrder{SortOrderAsc, SortOrderDesc} {
+		order, err := ParseSortOrder(string(v))
+		if err != nil {
+			t.Errorf("expected: nil, actual: %v", err)
+		}
+		if order != v {
+			t.Errorf("expected: %v, actual: %v", v, order)
+		}
+	}
+
+	if _, err := ParseSortOrder("bad"); err == nil {
+		t.Errorf("expected: error, actual: %v", err)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	t.Parallel()
+
+	ids := func(tasks []*Task) []string {
+		res := make([]string, len(tasks))
+		for k, v := range tasks {
+			res[k] = v.ID()
+		}
+		return res
+	}
+
+	equal := func(a, b []string) bool {
+		if len(a) != len(b) {
+			return false
+		}
+		for k := range a {
+			if a[k] != b[k] {
+				return false
+			}
+		}
+		return true
+	}
+
+	// Create a series of tasks, spread over clients and modes.
+	var (
+		idx   = newIndex()
+		tasks = make([]*Task, 6)
+		now   = time.Now()
+	)
+	for k := range tasks {
+		mode := ModeTypeSequential
+		if k%2 == 1 {
+			mode = ModeTypeParallel
+		}
+		tasks[k] = NewTask(mode, k%3, "info", false)
+		tasks[k].created = now.Add(time.Duration(k) * time.Second)
+		idx.insert(tasks[k])
+	}
+	idx.update(tasks[1].ID(), TaskStatusTypeCompleted)
+	idx.update(tasks[4].ID(), TaskStatusTypeCompleted)
+
+	clientID := 1
+
+	for _, testcase := range []struct {
+		name     string
+		query    TaskQuery
+		expected []*Task
+	}{
+		{"all", TaskQuery{}, tasks},
+		{"desc", TaskQuery{Order: SortOrderDesc}, []*Task{tasks[5], tasks[4], tasks[3], tasks[2], tasks[1], tasks[0]}},
+		{"status", TaskQuery{Status: TaskStatusTypeCompleted}, []*Task{tasks[1], tasks[4]}},
+		{"status desc", TaskQuery{Status: TaskStatusTypeCompleted, Order: SortOrderDesc}, []*Task{tasks[4], tasks[1]}},
+		{"mode", TaskQuery{Mode: ModeTypeParallel}, []*Task{tasks[1], tasks[3], tasks[5]}},
+		{"client", TaskQuery{ClientID: &clientID}, []*Task{tasks[1], tasks[4]}},
+		{"mode and status", TaskQuery{Mode: ModeTypeSequential, Status: TaskStatusTypeCompleted}, []*Task{tasks[4]}},
+		{"since", TaskQuery{Since: now.Add(4 * time.Second)}, []*Task{tasks[4], tasks[5]}},
+		{"until", TaskQuery{Until: now.Add(time.Second)}, []*Task{tasks[0], tasks[1]}},
+		{"no match", TaskQuery{Status: TaskStatusTypeCancelled}, nil},
+	} {
+		res, next, err := idx.query(testcase.query)
+		if err != nil {
+			t.Errorf("%s: %v", testcase.name, err)
+		}
+		if next != "" {
+			t.Errorf("%s: expected: empty cursor, actual: %s", testcase.name, next)
+		}
+		if expected, actual := ids(testcase.expected), ids(res); !equal(expected, actual) {
+			t.Errorf("%s: expected: %v, actual: %v", testcase.name, expected, actual)
+		}
+	}
+
+	t.Run("pagination", func(t *testing.T) {
+		for _, order := range []SortOrder{SortOrderAsc, SortOrderDesc} {
+			var (
+				cursor string
+				res    []*Task
+			)
+			for {
+				page, next, err := idx.query(TaskQuery{Cursor: cursor, Limit: 4, Order: order})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(page) > 4 {
+					t.Fatalf("expected: <= 4, actual: %d", len(page))
+				}
+				res = append(res, page...)
+				if next == "" {
+					break
+				}
+				cursor = next
+			}
+
+			expected := ids(tasks)
+			if order == SortOrderDesc {
+				for l, r := 0, len(expected)-1; l < r; l, r = l+1, r-1 {
+					expected[l], expected[r] = expected[r], expected[l]
+				}
+			}
+			if actual := ids(res); !equal(expected, actual) {
+				t.Errorf("%s: expected: %v, actual: %v", order, expected, actual)
+			}
+		}
+	})
+
+	t.Run("invalid cursor", func(t *testing.T) {
+		if _, _, err := idx.query(TaskQuery{Cursor: "bad"}); err != ErrInvalidCursor {
+			t.Errorf("expected: %v, actual: %v", ErrInvalidCursor, err)
+		}
+	})
+}
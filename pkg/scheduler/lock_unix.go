@@ -0,0 +1,25 @@
+//go:build !windows
+// +build !windows
+
+package scheduler
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive lock on the file, which is shared with other
+// processes (and other opens of the same file).
+func lockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+}
+
+// lockFileShared takes a shared lock on the file, which only excludes the
+// exclusive locks.
+func lockFileShared(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_SH)
+}
+
+func unlockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,23 @@
+//go:build windows
+// +build windows
+
+package scheduler
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// lockFile isn't supported on windows, so the task log can't be shared.
+func lockFile(file *os.File) error {
+	return errors.New("shared task log isn't supported on windows")
+}
+
+func lockFileShared(file *os.File) error {
+	return errors.New("shared task log isn't supported on windows")
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,139 @@
+package scheduler
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RetryPolicy defines how a failed request to a peer is retried.
+type RetryPolicy struct {
+	// MaxAttempts is the total amount of requests made to a peer, so 1 means
+	// the request is never retried.
+	MaxAttempts int `json:"max_attempts"`
+
+	// InitialBackoff is how long to wait before the first retry, with each
+	// subsequent retry waiting Multiplier times longer than the previous one.
+	InitialBackoff Duration `json:"initial_backoff"`
+	Multiplier     float64  `json:"multiplier"`
+
+	// MaxBackoff is the longest that any retry waits for, where zero leaves
+	// the backoff uncapped.
+	MaxBackoff Duration `json:"max_backoff"`
+
+	// Jitter randomly spreads the backoff by up to the fraction (0 to 1) either
+	// side of the backoff.
+	Jitter float64 `json:"jitter"`
+
+	// RetryableCodes are the HTTP status codes that can be retried. Requests
+	// that fail before getting a response are always retryable.
+	RetryableCodes []int `json:"retryable_codes"`
+}
+
+// DefaultRetryPolicy never retries a request.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    1,
+		InitialBackoff: Duration(100 * time.Millisecond),
+		Multiplier:     2,
+		MaxBackoff:     Duration(30 * time.Second),
+		Jitter:         0.2,
+		RetryableCodes: []int{
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		},
+	}
+}
+
+// Validate the RetryPolicy, to make sure that it's usable.
+func (p RetryPolicy) Validate() error {
+	switch {
+	case p.MaxAttempts < 1:
+		return errors.New("retry max attempts must be at least 1")
+	case p.InitialBackoff < 0:
+		return errors.New("retry backoff must not be negative")
+	case p.Multiplier < 1:
+		return errors.New("retry multiplier must be at least 1")
+	case p.MaxBackoff < 0:
+		return errors.New("retry max backoff must not be negative")
+	case p.Jitter < 0 || p.Jitter > 1:
+		return errors.New("retry jitter must be between 0 and 1")
+	}
+	for _, v := range p.RetryableCodes {
+		if v < 100 || v > 599 {
+			return errors.Errorf("retry status code %d is invalid", v)
+		}
+	}
+	return nil
+}
+
+// Retryable defines if the failed attempt can be retried.
+func (p RetryPolicy) Retryable(a Attempt) bool {
+	if a.Success() {
+		return false
+	}
+	if a.StatusCode == 0 {
+		return true
+	}
+	for _, v := range p.RetryableCodes {
+		if v == a.StatusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// Backoff returns how long to wait before making the next attempt, after the
+// number of attempts have already been made, which never exceeds MaxBackoff.
+func (p RetryPolicy) Backoff(attempts int) time.Duration {
+	backoff := p.clamp(float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(attempts-1)))
+	if p.Jitter > 0 {
+		backoff += backoff * p.Jitter * (rand.Float64()*2 - 1)
+	}
+	return time.Duration(p.clamp(backoff))
+}
+
+// maxDuration is the longest time.Duration that a float64 converts back to
+// without overflowing.
+const maxDuration = float64(math.MaxInt64 - 1023)
+
+// clamp the backoff to the MaxBackoff, or to the longest time.Duration if
+// there's no MaxBackoff.
+func (p RetryPolicy) clamp(backoff float64) float64 {
+	if p.MaxBackoff > 0 {
+		return math.Min(backoff, float64(p.MaxBackoff))
+	}
+	return math.Min(backoff, maxDuration)
+}
+
+// ParseStatusCodes takes a comma separated list of HTTP status codes.
+func ParseStatusCodes(s string) ([]int, error) {
+	res := make([]int, 0)
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+
+		code, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, errors.Errorf("%s: invalid status code", v)
+		}
+		res = append(res, code)
+	}
+	return res, nil
+}
+
+// FormatStatusCodes is the inverse of ParseStatusCodes.
+func FormatStatusCodes(codes []int) string {
+	res := make([]string, len(codes))
+	for k, v := range codes {
+		res[k] = strconv.Itoa(v)
+	}
+	return strings.Join(res, ",")
+}
@@ -0,0 +1,128 @@
+package scheduler
+
+import (
+	"net/http"
+	"testing"
+	"testing/quick"
+	"time"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	t.Parallel()
+
+	t.Run("validate", func(t *testing.T) {
+		if err := DefaultRetryPolicy().Validate(); err != nil {
+			t.Errorf("expected: nil, actual: %v", err)
+		}
+
+		for _, fn := range []func(*RetryPolicy){
+			func(p *RetryPolicy) { p.MaxAttempts = 0 },
+			func(p *RetryPolicy) { p.InitialBackoff = Duration(-time.Second) },
+			func(p *RetryPolicy) { p.Multiplier = 0.5 },
+			func(p *RetryPolicy) { p.MaxBackoff = Duration(-time.Second) },
+			func(p *RetryPolicy) { p.Jitter = 2 },
+			func(p *RetryPolicy) { p.RetryableCodes = []int{1000} },
+		} {
+			policy := DefaultRetryPolicy()
+			fn(&policy)
+			if err := policy.Validate(); err == nil {
+				t.Errorf("expected: error, actual: %v", err)
+			}
+		}
+	})
+
+	t.Run("retryable", func(t *testing.T) {
+		policy := DefaultRetryPolicy()
+		for _, testcase := range []struct {
+			attempt   Attempt
+			retryable bool
+		}{
+			{Attempt{StatusCode: http.StatusOK}, false},
+			{Attempt{Error: "connection refused"}, true},
+			{Attempt{StatusCode: http.StatusServiceUnavailable, Error: "503"}, true},
+			{Attempt{StatusCode: http.StatusBadRequest, Error: "400"}, false},
+		} {
+			if expected, actual := testcase.retryable, policy.Retryable(testcase.attempt); expected != actual {
+				t.Errorf("%v: expected: %v, actual: %v", testcase.attempt, expected, actual)
+			}
+		}
+	})
+
+	t.Run("backoff", func(t *testing.T) {
+		policy := RetryPolicy{
+			MaxAttempts:    5,
+			InitialBackoff: Duration(100 * time.Millisecond),
+			Multiplier:     2,
+		}
+		for k, expected := range []time.Duration{
+			100 * time.Millisecond,
+			200 * time.Millisecond,
+			400 * time.Millisecond,
+		} {
+			if actual := policy.Backoff(k + 1); expected != actual {
+				t.Errorf("expected: %s, actual: %s", expected, actual)
+			}
+		}
+	})
+
+	t.Run("backoff cap", func(t *testing.T) {
+		policy := RetryPolicy{
+			MaxAttempts:    50,
+			InitialBackoff: Duration(100 * time.Millisecond),
+			Multiplier:     2,
+			MaxBackoff:     Duration(time.Second),
+			Jitter:         0.5,
+		}
+		for i := 1; i < 50; i++ {
+			if actual := policy.Backoff(i); actual < 0 || actual > time.Second {
+				t.Errorf("%d: expected: at most %s, actual: %s", i, time.Second, actual)
+			}
+		}
+
+		// Without a cap, the backoff still doesn't overflow.
+		policy.MaxBackoff = 0
+		for _, i := range []int{64, 100, 10000} {
+			if actual := policy.Backoff(i); actual <= 0 {
+				t.Errorf("%d: expected: positive, actual: %s", i, actual)
+			}
+		}
+	})
+
+	t.Run("backoff jitter", func(t *testing.T) {
+		fn := func(a uint8) bool {
+			policy := RetryPolicy{
+				MaxAttempts:    5,
+				InitialBackoff: Duration(100 * time.Millisecond),
+				Multiplier:     2,
+				Jitter:         float64(a%100) / 100,
+			}
+
+			var (
+				backoff = policy.Backoff(2)
+				min     = time.Duration(float64(200*time.Millisecond) * (1 - policy.Jitter))
+				max     = time.Duration(float64(200*time.Millisecond) * (1 + policy.Jitter))
+			)
+			return backoff >= min && backoff <= max
+		}
+
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestStatusCodes(t *testing.T) {
+	t.Parallel()
+
+	codes, err := ParseStatusCodes("502, 503,504,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := "502,503,504", FormatStatusCodes(codes); expected != actual {
+		t.Errorf("expected: %s, actual: %s", expected, actual)
+	}
+
+	if _, err := ParseStatusCodes("bad"); err == nil {
+		t.Errorf("expected: error, actual: %v", err)
+	}
+}
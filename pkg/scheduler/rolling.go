@@ -0,0 +1,39 @@
+package scheduler
+
+import "github.com/pkg/errors"
+
+// RollingPolicy defines how a Task is rolled out to the peers in batches, when
+// in rolling mode.
+type RollingPolicy struct {
+	// BatchSize is the amount of peers that are sent the Task info at once,
+	// where each batch has to finish before the next batch starts.
+	BatchSize int `json:"batch_size"`
+
+	// Pause is how long to wait between each batch.
+	Pause Duration `json:"pause"`
+
+	// MaxUnavailable is the amount of peers that can fail before the rollout
+	// is stopped.
+	MaxUnavailable int `json:"max_unavailable"`
+}
+
+// DefaultRollingPolicy rolls out to one peer at a time, without pausing, and
+// stops on the first failure.
+func DefaultRollingPolicy() RollingPolicy {
+	return RollingPolicy{
+		BatchSize: 1,
+	}
+}
+
+// Validate the RollingPolicy, to make sure that it's usable.
+func (p RollingPolicy) Validate() error {
+	switch {
+	case p.BatchSize < 1:
+		return errors.New("rolling batch size must be at least 1")
+	case p.Pause < 0:
+		return errors.New("rolling pause must not be negative")
+	case p.MaxUnavailable < 0:
+		return errors.New("rolling max unavailable must not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRollingPolicy(t *testing.T) {
+	t.Parallel()
+
+	t.Run("validate", func(t *testing.T) {
+		if err := DefaultRollingPolicy().Validate(); err != nil {
+			t.Errorf("expected: nil, actual: %v", err)
+		}
+
+		for _, fn := range []func(*RollingPolicy){
+			func(p *RollingPolicy) { p.BatchSize = 0 },
+			func(p *RollingPolicy) { p.Pause = Duration(-time.Second) },
+			func(p *RollingPolicy) { p.MaxUnavailable = -1 },
+		} {
+			policy := DefaultRollingPolicy()
+			fn(&policy)
+			if err := policy.Validate(); err == nil {
+				t.Errorf("expected: error, actual: %v", err)
+			}
+		}
+	})
+}
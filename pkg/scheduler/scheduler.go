@@ -1,6 +1,7 @@
 package scheduler
 
 import (
+	"context"
 	"net/http"
 	"sync"
 	"time"
@@ -20,6 +21,25 @@ const (
 
 	// ModeTypeParallel serves the agents in a parallel mode.
 	ModeTypeParallel ModeType = "parallel"
+
+	// ModeTypeQuorum serves the agents in a parallel mode, but only until a
+	// quorum of the agents succeed.
+	ModeTypeQuorum ModeType = "quorum"
+
+	// ModeTypeRolling serves the agents in batches, one batch after another.
+	ModeTypeRolling ModeType = "rolling"
+
+	// ModeTypeCanary serves the canary agents first and only serves the rest
+	// of the agents once the canaries have stayed healthy.
+	ModeTypeCanary ModeType = "canary"
+
+	// ModeTypeHashed serves a single agent, which is picked by consistent
+	// hashing of the routing key of the task.
+	ModeTypeHashed ModeType = "hashed"
+
+	// ModeTypeOne serves a single agent, which is picked by the balance type of
+	// the task, falling over to another agent on failure.
+	ModeTypeOne ModeType = "one"
 )
 
 // ParseModeType takes a string and validates it against known ModeTypes
@@ -29,6 +49,16 @@ func ParseModeType(s string) (ModeType, error) {
 		return ModeTypeSequential, nil
 	case string(ModeTypeParallel):
 		return ModeTypeParallel, nil
+	case string(ModeTypeQuorum):
+		return ModeTypeQuorum, nil
+	case string(ModeTypeRolling):
+		return ModeTypeRolling, nil
+	case string(ModeTypeCanary):
+		return ModeTypeCanary, nil
+	case string(ModeTypeHashed):
+		return ModeTypeHashed, nil
+	case string(ModeTypeOne):
+		return ModeTypeOne, nil
 	default:
 		return ModeType(""), errors.New("invalid mode type")
 	}
@@ -36,68 +66,139 @@ func ParseModeType(s string) (ModeType, error) {
 
 // Scheduler runs Tasks against each peer.
 type Scheduler struct {
-	mutex  sync.Mutex
-	peers  []*peer.Peer
-	logger log.Logger
-	tasks  []*Task
-	stop   chan chan struct{}
+	mutex   sync.Mutex
+	peers   *peer.Set
+	store   TaskStore
+	workers int
+	skip    bool
+	breaker peer.BreakerPolicy
+	shared  SharedTaskStore
+	owner   string
+	lease   time.Duration
+	running map[int]struct{}
+	lost    map[string]struct{}
+	balance map[peer.BalanceType]peer.Balancer
+	events  *broadcaster
+	logger  log.Logger
+	stop    chan chan struct{}
 }
 
 // NewScheduler creates a new scheduler, which allows tasks to be mapped over
-// the peer agents.
-func NewScheduler(peers []*peer.Peer, logger log.Logger) *Scheduler {
+// the peer agents. The tasks are kept with in the store and the amount of
+// workers defines how many tasks can be executed at once.
+func NewScheduler(peers []*peer.Peer, store TaskStore, workers int, logger log.Logger) *Scheduler {
+	if workers < 1 {
+		workers = 1
+	}
+
+	// Balancers are kept for the lifetime of the scheduler, so that they can
+	// carry on from where the previous task left off.
+	balance := make(map[peer.BalanceType]peer.Balancer)
+	for _, t := range []peer.BalanceType{
+		peer.BalanceTypeRoundRobin,
+		peer.BalanceTypeLeastOutstanding,
+		peer.BalanceTypePowerOfTwo,
+	} {
+		balancer, err := peer.NewBalancer(t)
+		if err != nil {
+			panic(err)
+		}
+		balance[t] = balancer
+	}
+
 	return &Scheduler{
-		mutex:  sync.Mutex{},
-		peers:  peers,
-		logger: logger,
-		tasks:  make([]*Task, 0),
-		stop:   make(chan chan struct{}),
+		mutex:   sync.Mutex{},
+		peers:   peer.NewSet(peers),
+		store:   store,
+		workers: workers,
+		running: make(map[int]struct{}),
+		lost:    make(map[string]struct{}),
+		balance: balance,
+		events:  newBroadcaster(),
+		logger:  logger,
+		stop:    make(chan chan struct{}),
 	}
 }
 
 // Register a task for the scheduler to work on.
-func (s *Scheduler) Register(task *Task) {
+func (s *Scheduler) Register(task *Task) error {
 	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	task.SetStatus(TaskStatusTypePending)
-	s.tasks = append(s.tasks, task)
-	s.mutex.Unlock()
+	if err := s.store.Put(task); err != nil {
+		return err
+	}
+
+	s.events.publish(Event{
+		Type:   EventTypeStatus,
+		TaskID: task.ID(),
+		Status: TaskStatusTypePending,
+		Time:   time.Now(),
+	})
+	return nil
 }
 
-// Cancel a task, even if it's in mid-flight.
+// Cancel a task, even if it's in mid-flight. Tasks that have already finished
+// are left alone.
 func (s *Scheduler) Cancel(task *Task) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	task.SetStatus(TaskStatusTypeCancelled)
+	if !s.finish(task, TaskStatusTypeCancelled) {
+		return
+	}
 	task.Cancel()
 }
 
 // Get a task by an ID.
 // If no task is found, then it returns false for the boolean.
 func (s *Scheduler) Get(id string) (*Task, bool) {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-
-	for _, v := range s.tasks {
-		if v.ID() == id {
-			return v, true
+	task, err := s.store.Get(id)
+	if err != nil {
+		if err != ErrTaskNotFound {
+			level.Error(s.logger).Log("task", id, "err", err)
 		}
+		return nil, false
 	}
-	return nil, false
+	return task, true
 }
 
 // Run the scheduler, which in turn will execute the following tasks.
-// Tasks scheduled are run as FIFO for both sequential and parallel jobs.
+// Tasks scheduled are run as FIFO for both sequential and parallel jobs, per
+// client ID. A task is only started once the previous task for the same client
+// ID has finished, where as tasks for different client IDs are executed
+// concurrently, up to the amount of workers.
 func (s *Scheduler) Run() {
+	var (
+		wg   sync.WaitGroup
+		work = make(chan *Task)
+	)
+	for i := 0; i < s.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for task := range work {
+				s.execute(task)
+				s.release(task)
+			}
+		}()
+	}
+
 	step := time.NewTicker(10 * time.Millisecond)
 	defer step.Stop()
 
 	for {
 		select {
 		case <-step.C:
-			s.step()
+			s.step(work)
 
 		case q := <-s.stop:
+			// Wait for the workers to finish what they're currently executing.
+			close(work)
+			wg.Wait()
+
 			close(q)
 			return
 		}
@@ -111,28 +212,186 @@ func (s *Scheduler) Stop() {
 	<-q
 }
 
-// Peers return the underlying peers
+// Subscribe to the events of a task, or every task if the id is empty.
+// The events channel is closed when the returned function is called, or if
+// the subscriber falls too far behind the events.
+func (s *Scheduler) Subscribe(id string) (<-chan Event, func()) {
+	return s.events.subscribe(id)
+}
+
+// Query the tasks known to the scheduler, returning the tasks that match and a
+// cursor for the next page of tasks.
+func (s *Scheduler) Query(q TaskQuery) ([]*Task, string, error) {
+	return s.store.Query(q)
+}
+
+// Peers return the underlying peers, including any that are draining.
 func (s *Scheduler) Peers() []*peer.Peer {
+	return s.peers.Peers()
+}
+
+// Membership returns the set of peers, which can be changed whilst the
+// scheduler is running. Tasks that are already executing carry on using the
+// peers that were members when they started.
+func (s *Scheduler) Membership() *peer.Set {
 	return s.peers
 }
 
-func (s *Scheduler) step() {
-	var task *Task
+// SkipUnhealthy defines if the strategies should skip any peer that's
+// currently unhealthy. It should be set before the scheduler is run.
+func (s *Scheduler) SkipUnhealthy(skip bool) {
+	s.mutex.Lock()
+	s.skip = skip
+	s.mutex.Unlock()
+}
+
+// SetBreakerPolicy defines when the circuit breaker of each peer opens, where
+// the requests to a peer with an open circuit are skipped. It should be set
+// before the scheduler is run.
+func (s *Scheduler) SetBreakerPolicy(policy peer.BreakerPolicy) {
+	s.mutex.Lock()
+	s.breaker = policy
+	s.mutex.Unlock()
+}
 
+// BreakerPolicy returns the policy of the circuit breaker of each peer.
+func (s *Scheduler) BreakerPolicy() peer.BreakerPolicy {
 	s.mutex.Lock()
-	for _, v := range s.tasks {
-		if v.Status() == TaskStatusTypePending {
-			task = v
-			break
-		}
+	defer s.mutex.Unlock()
+
+	return s.breaker
+}
+
+// Share the tasks of the store with the schedulers of other proxies, where a
+// task is only executed once the owner has claimed a lease on it. The lease is
+// renewed whilst the task is executing, so if the owner goes away, the task is
+// taken over by another scheduler once the lease expires.
+// It should be called before the scheduler is run.
+func (s *Scheduler) Share(owner string, lease time.Duration) error {
+	shared, ok := s.store.(SharedTaskStore)
+	if !ok {
+		return errors.New("task store can't be shared")
+	}
+	if lease <= 0 {
+		return errors.New("lease must be positive")
 	}
+
+	s.mutex.Lock()
+	s.shared, s.owner, s.lease = shared, owner, lease
 	s.mutex.Unlock()
+	return nil
+}
 
-	// Nothing to work on, we're done.
-	if task == nil {
-		return
+// sharing returns the shared store, the owner and the lease duration, where
+// the store is nil if the scheduler isn't shared.
+func (s *Scheduler) sharing() (SharedTaskStore, string, time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.shared, s.owner, s.lease
+}
+
+// available returns the peers that match the selector, which a task can be
+// sent to.
+func (s *Scheduler) available(selector peer.Selector) []*peer.Peer {
+	s.mutex.Lock()
+	skip := s.skip
+	s.mutex.Unlock()
+
+	peers := selector.Select(s.peers.Active())
+	if !skip {
+		return peers
+	}
+
+	res := make([]*peer.Peer, 0, len(peers))
+	for _, p := range peers {
+		if p.Healthy() {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// step dispatches pending tasks to any free workers. Only the first pending
+// task of each client ID is considered, so that the order for a client ID is
+// preserved.
+// When shared, the requesting tasks are considered first, as they're either
+// being executed by another scheduler or their owner went away, in which case
+// they're taken over.
+func (s *Scheduler) step(work chan<- *Task) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	statuses := []TaskStatusType{TaskStatusTypePending}
+	if s.shared != nil {
+		statuses = []TaskStatusType{TaskStatusTypeRequesting, TaskStatusTypePending}
 	}
 
+	blocked := make(map[int]struct{})
+	for _, status := range statuses {
+		tasks, _, err := s.store.Query(TaskQuery{
+			Status: status,
+			Order:  SortOrderAsc,
+		})
+		if err != nil {
+			level.Error(s.logger).Log("err", err)
+			return
+		}
+
+		for _, task := range tasks {
+			if !s.dispatch(work, task, blocked) {
+				// No free workers, so wait for the next step.
+				return
+			}
+		}
+	}
+}
+
+// dispatch the task to a free worker, if it's the first task of the client ID.
+// It returns false if there are no free workers.
+// It expects the mutex to be held.
+func (s *Scheduler) dispatch(work chan<- *Task, task *Task, blocked map[int]struct{}) bool {
+	clientID := task.ClientID()
+	if _, ok := blocked[clientID]; ok {
+		return true
+	}
+	blocked[clientID] = struct{}{}
+
+	if _, ok := s.running[clientID]; ok {
+		return true
+	}
+
+	if s.shared != nil {
+		if err := s.shared.Claim(task.ID(), s.owner, s.lease); err != nil {
+			if err != ErrTaskClaimed {
+				level.Error(s.logger).Log("task", task.ID(), "err", err)
+			}
+			return true
+		}
+	}
+
+	select {
+	case work <- task:
+		s.running[clientID] = struct{}{}
+		return true
+	default:
+		if s.shared != nil {
+			if err := s.shared.Release(task.ID(), s.owner); err != nil {
+				level.Error(s.logger).Log("task", task.ID(), "err", err)
+			}
+		}
+		return false
+	}
+}
+
+// release allows the next task for the same client ID to be dispatched.
+func (s *Scheduler) release(task *Task) {
+	s.mutex.Lock()
+	delete(s.running, task.ClientID())
+	s.mutex.Unlock()
+}
+
+func (s *Scheduler) execute(task *Task) {
 	// Depending on the task mode, let's pick which strategy to actually use.
 	var strat strategy
 	switch task.Mode() {
@@ -140,128 +399,818 @@ func (s *Scheduler) step() {
 		strat = s.sequential
 	case ModeTypeParallel:
 		strat = s.parallel
+	case ModeTypeQuorum:
+		strat = s.quorum
+	case ModeTypeRolling:
+		strat = s.rolling
+	case ModeTypeCanary:
+		strat = s.canary
+	case ModeTypeHashed:
+		strat = s.hashed
+	case ModeTypeOne:
+		strat = s.one
 	default:
 		panic(errors.New("invalid mode type"))
 	}
 
+	// The context is done once the task is cancelled or the deadline of the
+	// task has passed.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if deadline := task.Deadline(); deadline > 0 {
+		var cancelDeadline context.CancelFunc
+		ctx, cancelDeadline = context.WithTimeout(ctx, deadline)
+		defer cancelDeadline()
+	}
+	task.addCancelFn(cancel)
+
+	// Keep hold of the lease whilst the task is executing.
+	if shared, owner, lease := s.sharing(); shared != nil {
+		done := make(chan struct{})
+		go s.renew(shared, owner, lease, task, cancel, done)
+		defer func() {
+			close(done)
+			if err := shared.Release(task.ID(), owner); err != nil {
+				level.Error(s.logger).Log("task", task.ID(), "err", err)
+			}
+
+			s.mutex.Lock()
+			delete(s.lost, task.ID())
+			s.mutex.Unlock()
+		}()
+	}
+
+	// Only send to the peers that match the selector of the task.
+	selector := task.Selector()
+	if !selector.Empty() && len(selector.Select(s.peers.Active())) == 0 {
+		level.Warn(s.logger).Log("task", task.ID(), "selector", selector, "err", "no peers match the selector")
+		s.fail(ctx, task)
+		return
+	}
+
+	// Don't send to any peers that are known to be unhealthy.
+	peers := s.available(selector)
+	if len(peers) == 0 && s.peers.Len() > 0 {
+		level.Warn(s.logger).Log("task", task.ID(), "err", "no available peers")
+		s.fail(ctx, task)
+		return
+	}
+
 	// Run the strategy over the task.
-	strat(task)
+	strat(ctx, task, peers)
 }
 
-type strategy func(*Task)
+type strategy func(context.Context, *Task, []*peer.Peer)
 
-func (s *Scheduler) sequential(task *Task) {
-	for i := 0; i < len(s.peers); i++ {
-		// Something has changed, before scheduled work or if it's happening
-		// mid-flight between requests.
-		if task.CancelledOrErrored() {
-			return
-		}
+// renew the lease on the task every third of the lease duration, until done.
+// If the lease is lost, the task is halted as another scheduler has taken it
+// over. Renewing the lease also picks up if the task was cancelled by another
+// scheduler, in which case the task is cancelled here too.
+func (s *Scheduler) renew(shared SharedTaskStore, owner string, lease time.Duration, task *Task, cancel context.CancelFunc, done <-chan struct{}) {
+	step := time.NewTicker(lease / 3)
+	defer step.Stop()
 
-		var (
-			peer     = s.peers[(i+task.ClientID())%len(s.peers)]
-			req, err = peer.NewRequest(task.Info())
-		)
-		if err != nil {
-			task.SetStatus(TaskStatusTypeErrored)
-			level.Error(s.logger).Log("task", task.ID(), "err", err)
-			return
-		}
-		task.SetStatus(TaskStatusTypeRequesting)
-		task.addCancelFn(req.Cancel)
+	for {
+		select {
+		case <-step.C:
+			err := shared.Renew(task.ID(), owner, lease)
+			if err == ErrLeaseLost {
+				level.Warn(s.logger).Log("task", task.ID(), "err", err)
 
-		level.Debug(s.logger).Log("task", task.ID(), "request", req.URL())
+				s.mutex.Lock()
+				s.lost[task.ID()] = struct{}{}
+				s.mutex.Unlock()
 
-		// Check that we've got a valid result.
-		resp, err := req.Do()
-		if err != nil {
-			level.Warn(s.logger).Log("err", err)
-			if task.FailOnError() {
-				task.SetStatus(TaskStatusTypeErrored)
+				cancel()
 				return
+			} else if err != nil {
+				level.Error(s.logger).Log("task", task.ID(), "err", err)
+				continue
 			}
-		}
-		if resp == nil {
+
+			if current, err := shared.Get(task.ID()); err == nil && current.CancelledOrErrored() {
+				task.Cancel()
+			}
+
+		case <-done:
 			return
 		}
-		if resp.StatusCode != http.StatusOK {
-			level.Warn(s.logger).Log("status", resp.Status)
-			if task.FailOnError() {
-				task.SetStatus(TaskStatusTypeErrored)
-				return
-			}
+	}
+}
+
+// setStatus updates the status of the task and persists it to the store,
+// unless the task already has the status.
+func (s *Scheduler) setStatus(task *Task, status TaskStatusType) {
+	if !task.changeStatus(status) {
+		return
+	}
+	s.updateStatus(task, status)
+}
+
+// transition changes the status of the task, only if it still has the from
+// status, returning false otherwise.
+func (s *Scheduler) transition(task *Task, from, to TaskStatusType) bool {
+	if !task.transition(from, to) {
+		return false
+	}
+	s.updateStatus(task, to)
+	return true
+}
+
+// finish changes the status of the task to the terminal status, returning
+// false if the task has already finished.
+func (s *Scheduler) finish(task *Task, status TaskStatusType) bool {
+	for {
+		from := task.Status()
+		if from.Terminal() {
+			return false
+		}
+		if s.transition(task, from, status) {
+			return true
 		}
-		level.Debug(s.logger).Log("status", resp.Status)
+	}
+}
+
+// updateStatus persists the status of the task to the store and publishes it.
+func (s *Scheduler) updateStatus(task *Task, status TaskStatusType) {
+	if err := s.store.UpdateStatus(task.ID(), status); err != nil {
+		level.Error(s.logger).Log("task", task.ID(), "err", err)
+	}
+
+	s.events.publish(Event{
+		Type:   EventTypeStatus,
+		TaskID: task.ID(),
+		Status: status,
+		Time:   time.Now(),
+	})
+}
+
+// setPhase updates the phase of the task and persists it to the store.
+func (s *Scheduler) setPhase(task *Task, phase TaskPhaseType) {
+	task.SetPhase(phase)
+	if err := s.store.UpdatePhase(task.ID(), phase); err != nil {
+		level.Error(s.logger).Log("task", task.ID(), "err", err)
+	}
+
+	s.events.publish(Event{
+		Type:   EventTypePhase,
+		TaskID: task.ID(),
+		Status: task.Status(),
+		Phase:  phase,
+		Time:   time.Now(),
+	})
+}
+
+// halted checks if the task can no longer continue. If the deadline of the
+// task has passed, then the task is marked as timed out.
+func (s *Scheduler) halted(ctx context.Context, task *Task) bool {
+	if task.CancelledOrErrored() {
+		return true
+	}
+
+	// Leave the task alone, once it's been taken over by another scheduler.
+	s.mutex.Lock()
+	_, lost := s.lost[task.ID()]
+	s.mutex.Unlock()
+	if lost {
+		return true
+	}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		s.finish(task, TaskStatusTypeTimedOut)
+		return true
+	}
+	return false
+}
+
+// fail marks the task as errored, unless it failed because the deadline of the
+// task has passed.
+func (s *Scheduler) fail(ctx context.Context, task *Task) {
+	if s.halted(ctx, task) {
+		return
+	}
+	s.finish(task, TaskStatusTypeErrored)
+}
+
+// complete marks the task as completed, if it's still requesting.
+func (s *Scheduler) complete(ctx context.Context, task *Task) {
+	if s.halted(ctx, task) {
+		return
 	}
 
 	// Make sure we only change to completed if we're still requesting.
-	if task.Status() == TaskStatusTypeRequesting {
-		task.SetStatus(TaskStatusTypeCompleted)
+	s.transition(task, TaskStatusTypeRequesting, TaskStatusTypeCompleted)
+}
+
+// record persists the attempt of a request against the task.
+func (s *Scheduler) record(task *Task, attempt Attempt) {
+	if err := s.store.AddAttempt(task.ID(), attempt); err != nil {
+		level.Error(s.logger).Log("task", task.ID(), "err", err)
 	}
+
+	s.events.publish(Event{
+		Type:    EventTypeAttempt,
+		TaskID:  task.ID(),
+		Status:  task.Status(),
+		Attempt: &attempt,
+		Time:    attempt.End,
+	})
 }
 
-func (s *Scheduler) parallel(task *Task) {
+// try sends the task info to a peer, retrying failed requests according to
+// the retry policy of the task. The last attempt is returned.
+func (s *Scheduler) try(ctx context.Context, task *Task, p *peer.Peer) Attempt {
+	return s.tryTracked(ctx, task, p, nil)
+}
+
+// tryTracked is the same as try, but every request is passed to track (if it's
+// not nil) before it's sent, so the request in flight can be cancelled.
+func (s *Scheduler) tryTracked(ctx context.Context, task *Task, p *peer.Peer, track func(*peer.Request)) Attempt {
+	policy := task.RetryPolicy()
+	for number := 1; ; number++ {
+		attempt := s.request(ctx, task, p, number, track)
+		if attempt.Skipped || number >= policy.MaxAttempts || !policy.Retryable(attempt) {
+			return attempt
+		}
+
+		backoff := policy.Backoff(number)
+		level.Debug(s.logger).Log("task", task.ID(), "peer", p.Addr(), "attempt", number, "backoff", backoff)
+
+		if !wait(ctx, backoff) || task.CancelledOrErrored() {
+			return attempt
+		}
+	}
+}
+
+// wait for the duration, unless the context is done in the meantime. It
+// returns false if the context is done.
+func wait(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// request sends the task info to a peer and records the outcome as an
+// attempt.
+func (s *Scheduler) request(ctx context.Context, task *Task, p *peer.Peer, number int, track func(*peer.Request)) Attempt {
+	attempt := Attempt{
+		Peer:   p.Addr(),
+		Number: number,
+		Start:  time.Now(),
+	}
+	defer func() {
+		attempt.End = time.Now()
+		s.record(task, attempt)
+	}()
+
+	// Don't send anything to a peer that's known to be failing.
+	breaker := s.BreakerPolicy()
+	permit, ok := p.Allow(breaker)
+	if !ok {
+		level.Debug(s.logger).Log("task", task.ID(), "peer", p.Addr(), "breaker", peer.BreakerStateOpen)
+		attempt.Skipped = true
+		attempt.Error = SkippedCircuitOpen
+		return attempt
+	}
+
+	parent := ctx
+	if timeout := task.RequestTimeout(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	req, err := p.NewRequestContext(ctx, task.Info())
+	if err != nil {
+		level.Error(s.logger).Log("task", task.ID(), "err", err)
+		attempt.Error = err.Error()
+		p.Release(permit)
+		return attempt
+	}
+	defer req.Cancel()
+
+	if track != nil {
+		track(req)
+	}
+
+	level.Debug(s.logger).Log("task", task.ID(), "request", req.URL())
+
+	// Check that we've got a valid result.
+	resp, err := req.Do()
+	s.observe(parent, p, breaker, permit, req, resp, err)
+	if err != nil {
+		level.Warn(s.logger).Log("task", task.ID(), "err", err)
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer resp.Body.Close()
+
+	attempt.StatusCode = resp.StatusCode
+	attempt.AgentDuration = resp.Header.Get(httpHeaderDuration)
+
+	if resp.StatusCode != http.StatusOK {
+		level.Warn(s.logger).Log("task", task.ID(), "status", resp.Status)
+		attempt.Error = resp.Status
+		return attempt
+	}
+	level.Debug(s.logger).Log("task", task.ID(), "status", resp.Status)
+
+	return attempt
+}
+
+// observe the outcome of the request in the circuit breaker of the peer, where
+// only the agent failing to respond (in time) or responding with a server
+// error counts as a failure. Requests that were cancelled, or that stopped
+// because the task itself was cancelled or ran out of time (the context),
+// don't say anything about the peer.
+func (s *Scheduler) observe(ctx context.Context, p *peer.Peer, policy peer.BreakerPolicy, permit peer.Permit, req *peer.Request, resp *http.Response, err error) {
+	if err != nil && (ctx.Err() != nil || req.Err() == context.Canceled) {
+		p.Release(permit)
+		return
+	}
+
+	success := err == nil && resp.StatusCode < http.StatusInternalServerError
+	if !p.Observe(permit, success, policy) {
+		return
+	}
+
+	if state := p.Breaker().State; state == peer.BreakerStateOpen {
+		level.Warn(s.logger).Log("peer", p.Addr(), "breaker", state)
+	} else {
+		level.Info(s.logger).Log("peer", p.Addr(), "breaker", state)
+	}
+}
+
+func (s *Scheduler) sequential(ctx context.Context, task *Task, peers []*peer.Peer) {
+	for i := 0; i < len(peers); i++ {
+		// Something has changed, before scheduled work or if it's happening
+		// mid-flight between requests.
+		if s.halted(ctx, task) {
+			return
+		}
+
+		s.setStatus(task, TaskStatusTypeRequesting)
+
+		p := peers[(i+task.ClientID())%len(peers)]
+		if attempt := s.try(ctx, task, p); !attempt.Success() && task.FailOnError() {
+			s.fail(ctx, task)
+			return
+		}
+	}
+
+	s.complete(ctx, task)
+}
+
+func (s *Scheduler) parallel(ctx context.Context, task *Task, peers []*peer.Peer) {
 	// Wait for everything
 	var wg sync.WaitGroup
-	wg.Add(len(s.peers))
 
 	// Locate if there are any errors.
-	errs := make(chan error)
+	errs := make(chan error, len(peers))
 
-	for i := 0; i < len(s.peers); i++ {
+	for i := 0; i < len(peers); i++ {
 		// Something has changed, before scheduled work or if it's happening
 		// mid-flight between requests.
-		if task.CancelledOrErrored() {
-			return
+		if s.halted(ctx, task) {
+			break
 		}
 
-		p := s.peers[(i+task.ClientID())%len(s.peers)]
-		task.SetStatus(TaskStatusTypeRequesting)
+		p := peers[(i+task.ClientID())%len(peers)]
+		s.setStatus(task, TaskStatusTypeRequesting)
 
+		wg.Add(1)
 		go func(p *peer.Peer, failOnError bool) {
 			defer wg.Done()
 
-			req, err := p.NewRequest(task.Info())
-			if err != nil {
-				level.Error(s.logger).Log("task", task.ID(), "err", err)
-				return
+			if attempt := s.try(ctx, task, p); !attempt.Success() && failOnError {
+				errs <- errors.New(attempt.Error)
 			}
-			task.addCancelFn(req.Cancel)
+		}(p, task.FailOnError())
+	}
 
-			level.Debug(s.logger).Log("task", task.ID(), "request", req.URL())
+	// Every request has to finish before the task does, otherwise the
+	// outstanding requests are cancelled along with the task.
+	wg.Wait()
+	close(errs)
 
-			// Check that we've got a valid result.
-			resp, err := req.Do()
-			if err != nil {
-				level.Warn(s.logger).Log("err", err)
-				if failOnError {
-					errs <- err
-					return
-				}
+	if len(errs) > 0 {
+		s.fail(ctx, task)
+		return
+	}
+
+	s.complete(ctx, task)
+}
+
+// quorum sends the task info to every peer in parallel, but completes the task
+// as soon as the quorum of the peers succeed, cancelling the outstanding
+// requests. The task errors as soon as the quorum can no longer be reached.
+// When hedging, only the quorum of the peers are sent the task info at first
+// and the rest of the peers are kept to hedge or fall over to.
+func (s *Scheduler) quorum(ctx context.Context, task *Task, peers []*peer.Peer) {
+	if s.halted(ctx, task) {
+		return
+	}
+
+	// Default to a majority of the peers.
+	quorum := task.Quorum()
+	if quorum <= 0 {
+		quorum = len(peers)/2 + 1
+	}
+	if quorum > len(peers) {
+		level.Warn(s.logger).Log("task", task.ID(), "quorum", quorum, "peers", len(peers), "err", "quorum can't be reached")
+		s.fail(ctx, task)
+		return
+	}
+
+	s.setStatus(task, TaskStatusTypeRequesting)
+
+	// The outstanding requests are cancelled once the outcome is known.
+	requests, cancel := context.WithCancel(ctx)
+	defer cancel()
+	task.addCancelFn(cancel)
+
+	var (
+		wg      sync.WaitGroup
+		slots   = len(peers)
+		results = make(chan bool, len(peers))
+	)
+	if task.HedgePolicy().Enabled() {
+		var (
+			mutex  sync.Mutex
+			picked int
+		)
+		next := func() *peer.Peer {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			if picked >= len(peers) {
+				return nil
 			}
-			if resp == nil {
-				return
+			p := peers[(picked+task.ClientID())%len(peers)]
+			picked++
+			return p
+		}
+
+		// Each slot only fails once there are no more peers to pick.
+		slots = quorum
+		for i := 0; i < slots; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				results <- s.hedge(requests, task, next)
+			}()
+		}
+	} else {
+		for i := 0; i < len(peers); i++ {
+			p := peers[(i+task.ClientID())%len(peers)]
+
+			wg.Add(1)
+			go func(p *peer.Peer) {
+				defer wg.Done()
+
+				results <- s.try(requests, task, p).Success()
+			}(p)
+		}
+	}
+
+	// Wait for the outstanding requests, so the attempts are recorded before
+	// the task is released.
+	defer wg.Wait()
+
+	var successes, failures int
+	for i := 0; i < slots; i++ {
+		if <-results {
+			successes++
+		} else {
+			failures++
+		}
+
+		switch {
+		case successes >= quorum:
+			cancel()
+			s.complete(ctx, task)
+			return
+		case failures > slots-quorum:
+			cancel()
+			s.fail(ctx, task)
+			return
+		}
+	}
+}
+
+// hedge sends the task info to a peer picked by next, until a request
+// succeeds. If the hedge policy of the task allows, and the request hasn't
+// answered within the threshold, a duplicate request is sent to another picked
+// peer. The first request to succeed wins and any other request in flight is
+// cancelled. Once every request in flight has failed, another peer is picked.
+// It returns false if no request succeeded before next ran out of peers.
+func (s *Scheduler) hedge(ctx context.Context, task *Task, next func() *peer.Peer) bool {
+	type result struct {
+		leg     *leg
+		attempt Attempt
+	}
+
+	var (
+		policy  = task.HedgePolicy()
+		results = make(chan result)
+		legs    = make(map[*leg]struct{})
+		timer   *time.Timer
+		hedging <-chan time.Time
+		success bool
+	)
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	start := func() {
+		// Nothing else should be sent, once the task can no longer continue.
+		if ctx.Err() != nil || task.CancelledOrErrored() {
+			return
+		}
+
+		p := next()
+		if p == nil {
+			return
+		}
+
+		l := newLeg(ctx)
+		legs[l] = struct{}{}
+		go func() {
+			results <- result{leg: l, attempt: s.tryTracked(l.ctx, task, p, l.track)}
+		}()
+
+		// Only the latest request is hedged.
+		if timer != nil {
+			timer.Stop()
+		}
+		hedging = nil
+		if threshold, ok := policy.Threshold(p); ok {
+			timer = time.NewTimer(threshold)
+			hedging = timer.C
+		}
+	}
+
+	start()
+	for len(legs) > 0 {
+		select {
+		case r := <-results:
+			delete(legs, r.leg)
+			r.leg.stop()
+
+			if success {
+				continue
 			}
-			if resp.StatusCode != http.StatusOK {
-				level.Warn(s.logger).Log("status", resp.Status)
-				if failOnError {
-					errs <- err
-					return
+			if r.attempt.Success() {
+				success, hedging = true, nil
+				for l := range legs {
+					l.cancel()
 				}
+				continue
 			}
-			level.Debug(s.logger).Log("status", resp.Status)
-		}(p, task.FailOnError())
+			if len(legs) == 0 {
+				level.Debug(s.logger).Log("task", task.ID(), "peer", r.attempt.Peer, "msg", "falling over to another peer")
+				start()
+			}
+
+		case <-hedging:
+			hedging = nil
+			level.Debug(s.logger).Log("task", task.ID(), "msg", "hedging with another peer")
+			start()
+		}
+	}
+	return success
+}
+
+// rolling sends the task info to the peers in batches, starting from the
+// client ID offset. Each batch is sent in parallel and has to finish before
+// the next batch starts. The rollout stops once more peers have failed than
+// the rolling policy allows to be unavailable.
+func (s *Scheduler) rolling(ctx context.Context, task *Task, peers []*peer.Peer) {
+	var (
+		policy   = task.RollingPolicy()
+		failures int
+	)
+	for start := 0; start < len(peers); start += policy.BatchSize {
+		if start > 0 && policy.Pause > 0 && !wait(ctx, time.Duration(policy.Pause)) {
+			s.fail(ctx, task)
+			return
+		}
+
+		// Something has changed, before scheduled work or if it's happening
+		// mid-flight between batches.
+		if s.halted(ctx, task) {
+			return
+		}
+
+		s.setStatus(task, TaskStatusTypeRequesting)
+
+		end := start + policy.BatchSize
+		if end > len(peers) {
+			end = len(peers)
+		}
+
+		batch := make([]*peer.Peer, 0, end-start)
+		for i := start; i < end; i++ {
+			batch = append(batch, peers[(i+task.ClientID())%len(peers)])
+		}
+
+		if failures += s.batch(ctx, task, batch); failures > policy.MaxUnavailable {
+			level.Warn(s.logger).Log("task", task.ID(), "failures", failures, "err", "rollout stopped")
+			s.fail(ctx, task)
+			return
+		}
 	}
 
-	go func() { wg.Wait(); close(errs) }()
+	s.complete(ctx, task)
+}
 
-	for range errs {
-		task.SetStatus(TaskStatusTypeErrored)
+// canary sends the task info to the canary peers first, starting from the
+// client ID offset, and then checks the health of the canaries until the soak
+// has passed. Only then is the task promoted and sent to the rest of the peers
+// in parallel. If a canary fails, the rest of the peers are skipped.
+func (s *Scheduler) canary(ctx context.Context, task *Task, peers []*peer.Peer) {
+	if s.halted(ctx, task) {
 		return
 	}
 
-	// Make sure we only change to completed if we're still requesting.
-	if task.Status() == TaskStatusTypeRequesting {
-		task.SetStatus(TaskStatusTypeCompleted)
+	// Without any canaries, there's nothing to soak before promoting.
+	if len(peers) == 0 {
+		level.Warn(s.logger).Log("task", task.ID(), "err", "no peers to canary")
+		s.fail(ctx, task)
+		return
+	}
+
+	var (
+		policy  = task.CanaryPolicy()
+		size    = policy.Size(len(peers))
+		ordered = make([]*peer.Peer, len(peers))
+	)
+	for i := range peers {
+		ordered[i] = peers[(i+task.ClientID())%len(peers)]
+	}
+	canaries, rest := ordered[:size], ordered[size:]
+
+	s.setPhase(task, TaskPhaseTypeCanary)
+	s.setStatus(task, TaskStatusTypeRequesting)
+
+	if failures := s.batch(ctx, task, canaries); failures > 0 {
+		level.Warn(s.logger).Log("task", task.ID(), "failures", failures, "err", "canary failed")
+		s.fail(ctx, task)
+		return
+	}
+
+	if s.halted(ctx, task) {
+		return
+	}
+	s.setPhase(task, TaskPhaseTypeSoaking)
+
+	if err := s.soak(ctx, task, canaries, policy); err != nil {
+		level.Warn(s.logger).Log("task", task.ID(), "err", err)
+		s.fail(ctx, task)
+		return
+	}
+
+	if s.halted(ctx, task) {
+		return
+	}
+	s.setPhase(task, TaskPhaseTypePromoted)
+
+	if failures := s.batch(ctx, task, rest); failures > 0 && task.FailOnError() {
+		s.fail(ctx, task)
+		return
+	}
+
+	s.complete(ctx, task)
+}
+
+// hashed sends the task info to the single peer that the routing key of the
+// task maps to, using a consistent hash ring of the peers. Changes to the peers
+// only move the keys of the peers that were added or removed.
+func (s *Scheduler) hashed(ctx context.Context, task *Task, peers []*peer.Peer) {
+	if s.halted(ctx, task) {
+		return
+	}
+
+	p := peer.NewRing(peers, peer.DefaultReplicas).Get(task.Key())
+	if p == nil {
+		level.Warn(s.logger).Log("task", task.ID(), "key", task.Key(), "err", "no peer for the key")
+		s.fail(ctx, task)
+		return
+	}
+
+	s.setStatus(task, TaskStatusTypeRequesting)
+
+	if attempt := s.try(ctx, task, p); !attempt.Success() {
+		s.fail(ctx, task)
+		return
 	}
+
+	s.complete(ctx, task)
 }
+
+// one sends the task info to a single peer, which is picked by the balancer of
+// the task. If the request to the peer fails (or stalls when hedging), then
+// another peer is picked from the peers that haven't been tried yet.
+func (s *Scheduler) one(ctx context.Context, task *Task, peers []*peer.Peer) {
+	if s.halted(ctx, task) {
+		return
+	}
+
+	balancer, ok := s.balance[task.Balance()]
+	if !ok {
+		level.Warn(s.logger).Log("task", task.ID(), "balance", task.Balance(), "err", "invalid balance type")
+		s.fail(ctx, task)
+		return
+	}
+
+	s.setStatus(task, TaskStatusTypeRequesting)
+
+	candidates := make([]*peer.Peer, len(peers))
+	copy(candidates, peers)
+	next := func() *peer.Peer {
+		p := balancer.Pick(candidates)
+		for k, v := range candidates {
+			if v == p {
+				candidates = append(candidates[:k], candidates[k+1:]...)
+				break
+			}
+		}
+		return p
+	}
+
+	if !s.hedge(ctx, task, next) {
+		s.fail(ctx, task)
+		return
+	}
+
+	s.complete(ctx, task)
+}
+
+// soak checks the health of the canaries every interval, until the soak of the
+// canary policy has passed. It returns an error as soon as a canary isn't
+// healthy.
+func (s *Scheduler) soak(ctx context.Context, task *Task, canaries []*peer.Peer, policy CanaryPolicy) error {
+	deadline := time.Now().Add(time.Duration(policy.Soak))
+	for {
+		interval := time.Duration(policy.Interval)
+		if remaining := time.Until(deadline); remaining < interval {
+			interval = remaining
+		}
+		if interval > 0 && !wait(ctx, interval) {
+			return ctx.Err()
+		}
+
+		for _, p := range canaries {
+			if err := s.check(ctx, task, p); err != nil {
+				return errors.Wrapf(err, "canary %s unhealthy", p.Addr())
+			}
+		}
+
+		if !time.Now().Before(deadline) {
+			return nil
+		}
+	}
+}
+
+// check the health of a peer, using the request timeout of the task.
+func (s *Scheduler) check(ctx context.Context, task *Task, p *peer.Peer) error {
+	if timeout := task.RequestTimeout(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return p.Check(ctx)
+}
+
+// batch sends the task info to the peers in parallel, waiting for every
+// request to finish. It returns how many of the peers failed.
+func (s *Scheduler) batch(ctx context.Context, task *Task, peers []*peer.Peer) int {
+	var (
+		wg     sync.WaitGroup
+		failed = make(chan struct{}, len(peers))
+	)
+	for _, p := range peers {
+		wg.Add(1)
+		go func(p *peer.Peer) {
+			defer wg.Done()
+
+			if attempt := s.try(ctx, task, p); !attempt.Success() {
+				failed <- struct{}{}
+			}
+		}(p)
+	}
+	wg.Wait()
+
+	return len(failed)
+}
+
+const httpHeaderDuration = "X-Proxy-Duration"
@@ -1,11 +1,20 @@
 package scheduler
 
 import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"reflect"
 
 	"net/http"
+	"net/http/httptest"
 
 	"github.com/SimonRichardson/cmdproxy/pkg/peer"
 	"github.com/go-kit/kit/log"
@@ -36,6 +45,61 @@ func TestModeType(t *testing.T) {
 		}
 	})
 
+	t.Run("parse quorum", func(t *testing.T) {
+		mode, err := ParseModeType(string(ModeTypeQuorum))
+		if err != nil {
+			t.Errorf("expected: nil, actual: %v", err)
+		}
+
+		if mode != ModeTypeQuorum {
+			t.Errorf("expected: %v, actual: %v", ModeTypeQuorum, mode)
+		}
+	})
+
+	t.Run("parse rolling", func(t *testing.T) {
+		mode, err := ParseModeType(string(ModeTypeRolling))
+		if err != nil {
+			t.Errorf("expected: nil, actual: %v", err)
+		}
+
+		if mode != ModeTypeRolling {
+			t.Errorf("expected: %v, actual: %v", ModeTypeRolling, mode)
+		}
+	})
+
+	t.Run("parse canary", func(t *testing.T) {
+		mode, err := ParseModeType(string(ModeTypeCanary))
+		if err != nil {
+			t.Errorf("expected: nil, actual: %v", err)
+		}
+
+		if mode != ModeTypeCanary {
+			t.Errorf("expected: %v, actual: %v", ModeTypeCanary, mode)
+		}
+	})
+
+	t.Run("parse hashed", func(t *testing.T) {
+		mode, err := ParseModeType(string(ModeTypeHashed))
+		if err != nil {
+			t.Errorf("expected: nil, actual: %v", err)
+		}
+
+		if mode != ModeTypeHashed {
+			t.Errorf("expected: %v, actual: %v", ModeTypeHashed, mode)
+		}
+	})
+
+	t.Run("parse one", func(t *testing.T) {
+		mode, err := ParseModeType(string(ModeTypeOne))
+		if err != nil {
+			t.Errorf("expected: nil, actual: %v", err)
+		}
+
+		if mode != ModeTypeOne {
+			t.Errorf("expected: %v, actual: %v", ModeTypeOne, mode)
+		}
+	})
+
 	t.Run("parse invalid", func(t *testing.T) {
 		_, err := ParseModeType("bad")
 		if err == nil {
@@ -50,10 +114,12 @@ func TestScheduler(t *testing.T) {
 	logger := log.NewNopLogger()
 
 	t.Run("register", func(t *testing.T) {
-		scheduler := NewScheduler(nil, logger)
+		scheduler := NewScheduler(nil, NewMemoryTaskStore(), 1, logger)
 
 		task := NewTask(ModeTypeSequential, 0, "hello", true)
-		scheduler.Register(task)
+		if err := scheduler.Register(task); err != nil {
+			t.Fatal(err)
+		}
 
 		if task.Status() != TaskStatusTypePending {
 			t.Errorf("expected: %v, actual: %v", TaskStatusTypePending, task.Status())
@@ -61,10 +127,12 @@ func TestScheduler(t *testing.T) {
 	})
 
 	t.Run("get", func(t *testing.T) {
-		scheduler := NewScheduler(nil, logger)
+		scheduler := NewScheduler(nil, NewMemoryTaskStore(), 1, logger)
 
 		task := NewTask(ModeTypeSequential, 0, "hello", true)
-		scheduler.Register(task)
+		if err := scheduler.Register(task); err != nil {
+			t.Fatal(err)
+		}
 
 		if tsk, ok := scheduler.Get(task.ID()); !ok || tsk.ID() != task.ID() {
 			t.Errorf("no task found")
@@ -76,10 +144,12 @@ func TestScheduler(t *testing.T) {
 	})
 
 	t.Run("cancel", func(t *testing.T) {
-		scheduler := NewScheduler(nil, logger)
+		scheduler := NewScheduler(nil, NewMemoryTaskStore(), 1, logger)
 
 		task := NewTask(ModeTypeSequential, 0, "hello", true)
-		scheduler.Register(task)
+		if err := scheduler.Register(task); err != nil {
+			t.Fatal(err)
+		}
 		scheduler.Cancel(task)
 
 		if task.Status() != TaskStatusTypeCancelled {
@@ -87,13 +157,1838 @@ func TestScheduler(t *testing.T) {
 		}
 	})
 
+	t.Run("subscribe", func(t *testing.T) {
+		scheduler := NewScheduler(nil, NewMemoryTaskStore(), 1, logger)
+
+		events, cancel := scheduler.Subscribe("")
+		defer cancel()
+
+		task := NewTask(ModeTypeSequential, 0, "hello", true)
+		if err := scheduler.Register(task); err != nil {
+			t.Fatal(err)
+		}
+		scheduler.Cancel(task)
+
+		for _, expected := range []TaskStatusType{TaskStatusTypePending, TaskStatusTypeCancelled} {
+			if e := <-events; e.TaskID != task.ID() || e.Type != EventTypeStatus || e.Status != expected {
+				t.Errorf("unexpected event: %v", e)
+			}
+		}
+	})
+
+	t.Run("unchanged status", func(t *testing.T) {
+		scheduler := NewScheduler(nil, NewMemoryTaskStore(), 1, logger)
+
+		events, cancel := scheduler.Subscribe("")
+		defer cancel()
+
+		task := NewTask(ModeTypeSequential, 0, "hello", true)
+		if err := scheduler.Register(task); err != nil {
+			t.Fatal(err)
+		}
+		scheduler.Cancel(task)
+		scheduler.Cancel(task)
+
+		for _, expected := range []TaskStatusType{TaskStatusTypePending, TaskStatusTypeCancelled} {
+			if e := <-events; e.Status != expected {
+				t.Errorf("expected: %v, actual: %v", expected, e.Status)
+			}
+		}
+		select {
+		case e := <-events:
+			t.Errorf("expected: no event, actual: %v", e)
+		default:
+		}
+	})
+
+	t.Run("cancel completed", func(t *testing.T) {
+		scheduler := NewScheduler(nil, NewMemoryTaskStore(), 1, logger)
+
+		task := NewTask(ModeTypeSequential, 0, "hello", true)
+		if err := scheduler.Register(task); err != nil {
+			t.Fatal(err)
+		}
+		scheduler.setStatus(task, TaskStatusTypeCompleted)
+
+		events, cancel := scheduler.Subscribe("")
+		defer cancel()
+
+		scheduler.Cancel(task)
+
+		if expected, actual := TaskStatusTypeCompleted, task.Status(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		select {
+		case e := <-events:
+			t.Errorf("expected: no event, actual: %v", e)
+		default:
+		}
+	})
+
 	t.Run("peers", func(t *testing.T) {
 		peers := []*peer.Peer{
 			peer.NewPeer(http.DefaultClient, "http", "0.0.0.0:0", logger),
 		}
-		scheduler := NewScheduler(peers, logger)
+		scheduler := NewScheduler(peers, NewMemoryTaskStore(), 1, logger)
 		if !reflect.DeepEqual(scheduler.Peers(), peers) {
 			t.Errorf("expected: true, actual: false")
 		}
 	})
 }
+
+func TestSchedulerRun(t *testing.T) {
+	t.Parallel()
+
+	logger := log.NewNopLogger()
+
+	t.Run("concurrent dispatch", func(t *testing.T) {
+		var (
+			release   = make(chan struct{})
+			store     = NewMemoryTaskStore()
+			scheduler = NewScheduler(newTestPeers(t, respondOnRelease(release)), store, 2, logger)
+		)
+		go scheduler.Run()
+		defer scheduler.Stop()
+
+		var (
+			a = NewTask(ModeTypeSequential, 0, "a", true)
+			b = NewTask(ModeTypeSequential, 1, "b", true)
+		)
+		for _, v := range []*Task{a, b} {
+			if err := scheduler.Register(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Both tasks should be in-flight at the same time.
+		for _, v := range []*Task{a, b} {
+			if !waitForStatus(store, v.ID(), TaskStatusTypeRequesting) {
+				t.Errorf("expected: %v, actual: %v", TaskStatusTypeRequesting, v.Status())
+			}
+		}
+
+		close(release)
+
+		for _, v := range []*Task{a, b} {
+			if !waitForStatus(store, v.ID(), TaskStatusTypeCompleted) {
+				t.Errorf("expected: %v, actual: %v", TaskStatusTypeCompleted, v.Status())
+			}
+		}
+	})
+
+	t.Run("fifo per client", func(t *testing.T) {
+		var (
+			release   = make(chan struct{})
+			store     = NewMemoryTaskStore()
+			scheduler = NewScheduler(newTestPeers(t, respondOnRelease(release)), store, 2, logger)
+		)
+		go scheduler.Run()
+		defer scheduler.Stop()
+
+		var (
+			a = NewTask(ModeTypeSequential, 0, "a", true)
+			b = NewTask(ModeTypeSequential, 0, "b", true)
+		)
+		for _, v := range []*Task{a, b} {
+			if err := scheduler.Register(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if !waitForStatus(store, a.ID(), TaskStatusTypeRequesting) {
+			t.Errorf("expected: %v, actual: %v", TaskStatusTypeRequesting, a.Status())
+		}
+
+		// Even with a free worker, the second task should wait for the first.
+		time.Sleep(50 * time.Millisecond)
+		if expected, actual := TaskStatusTypePending, b.Status(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+
+		close(release)
+
+		for _, v := range []*Task{a, b} {
+			if !waitForStatus(store, v.ID(), TaskStatusTypeCompleted) {
+				t.Errorf("expected: %v, actual: %v", TaskStatusTypeCompleted, v.Status())
+			}
+		}
+	})
+
+	t.Run("workers limit", func(t *testing.T) {
+		var (
+			release   = make(chan struct{})
+			store     = NewMemoryTaskStore()
+			scheduler = NewScheduler(newTestPeers(t, respondOnRelease(release)), store, 1, logger)
+		)
+		go scheduler.Run()
+		defer scheduler.Stop()
+
+		var (
+			a = NewTask(ModeTypeParallel, 0, "a", true)
+			b = NewTask(ModeTypeParallel, 1, "b", true)
+		)
+		for _, v := range []*Task{a, b} {
+			if err := scheduler.Register(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if !waitForStatus(store, a.ID(), TaskStatusTypeRequesting) {
+			t.Errorf("expected: %v, actual: %v", TaskStatusTypeRequesting, a.Status())
+		}
+
+		time.Sleep(50 * time.Millisecond)
+		if expected, actual := TaskStatusTypePending, b.Status(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+
+		close(release)
+
+		for _, v := range []*Task{a, b} {
+			if !waitForStatus(store, v.ID(), TaskStatusTypeCompleted) {
+				t.Errorf("expected: %v, actual: %v", TaskStatusTypeCompleted, v.Status())
+			}
+		}
+	})
+}
+
+func TestSchedulerAttempts(t *testing.T) {
+	t.Parallel()
+
+	logger := log.NewNopLogger()
+
+	// agent responds with the status code, as having taken a millisecond.
+	agent := func(code int) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set(httpHeaderDuration, "1ms")
+			w.WriteHeader(code)
+		}
+	}
+
+	for _, mode := range []ModeType{ModeTypeSequential, ModeTypeParallel} {
+		t.Run(string(mode), func(t *testing.T) {
+			var (
+				ok  = newTestPeer(t, agent(http.StatusOK))
+				bad = newTestPeer(t, agent(http.StatusInternalServerError))
+			)
+
+			var (
+				scheduler = NewScheduler([]*peer.Peer{ok, bad}, NewMemoryTaskStore(), 1, logger)
+				task      = NewTask(mode, 0, "info", false)
+			)
+			if err := scheduler.Register(task); err != nil {
+				t.Fatal(err)
+			}
+			scheduler.execute(task)
+
+			if expected, actual := TaskStatusTypeCompleted, task.Status(); expected != actual {
+				t.Errorf("expected: %v, actual: %v", expected, actual)
+			}
+
+			attempts := task.Attempts()
+			if expected, actual := 2, len(attempts); expected != actual {
+				t.Fatalf("expected: %d, actual: %d", expected, actual)
+			}
+
+			codes := make(map[string]Attempt)
+			for _, v := range attempts {
+				codes[v.Peer] = v
+			}
+
+			if attempt := codes[ok.Addr()]; !attempt.Success() ||
+				attempt.StatusCode != http.StatusOK ||
+				attempt.AgentDuration != "1ms" ||
+				attempt.End.Before(attempt.Start) {
+				t.Errorf("unexpected attempt: %v", attempt)
+			}
+			if attempt := codes[bad.Addr()]; attempt.Success() ||
+				attempt.StatusCode != http.StatusInternalServerError {
+				t.Errorf("unexpected attempt: %v", attempt)
+			}
+		})
+	}
+
+	t.Run("fail on error", func(t *testing.T) {
+		var (
+			bad = newTestPeer(t, agent(http.StatusInternalServerError))
+			ok  = newTestPeer(t, agent(http.StatusOK))
+		)
+
+		var (
+			scheduler = NewScheduler([]*peer.Peer{bad, ok}, NewMemoryTaskStore(), 1, logger)
+			task      = NewTask(ModeTypeSequential, 0, "info", true)
+		)
+		if err := scheduler.Register(task); err != nil {
+			t.Fatal(err)
+		}
+		scheduler.execute(task)
+
+		if expected, actual := TaskStatusTypeErrored, task.Status(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := 1, len(task.Attempts()); expected != actual {
+			t.Errorf("expected: %d, actual: %d", expected, actual)
+		}
+	})
+
+	t.Run("parallel fail on error", func(t *testing.T) {
+		// The slower peer still has to finish, once the other peer failed.
+		var (
+			bad       = newTestPeer(t, agent(http.StatusInternalServerError))
+			slow      = newTestPeer(t, respond(http.StatusOK, 50*time.Millisecond))
+			scheduler = NewScheduler([]*peer.Peer{bad, slow}, NewMemoryTaskStore(), 1, logger)
+			task      = NewTask(ModeTypeParallel, 0, "info", true)
+		)
+		if err := scheduler.Register(task); err != nil {
+			t.Fatal(err)
+		}
+		scheduler.execute(task)
+
+		if expected, actual := TaskStatusTypeErrored, task.Status(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+
+		attempts := task.Attempts()
+		if expected, actual := 2, len(attempts); expected != actual {
+			t.Fatalf("expected: %d, actual: %d", expected, actual)
+		}
+		for _, attempt := range attempts {
+			if attempt.Peer == slow.Addr() && !attempt.Success() {
+				t.Errorf("expected: success, actual: %v", attempt)
+			}
+		}
+	})
+}
+
+func TestSchedulerRetry(t *testing.T) {
+	t.Parallel()
+
+	logger := log.NewNopLogger()
+
+	// flaky fails the first n requests with the code.
+	flaky := func(n, code int) http.HandlerFunc {
+		var (
+			mutex    sync.Mutex
+			requests int
+		)
+		return func(w http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			requests++
+			current := requests
+			mutex.Unlock()
+
+			if current <= n {
+				w.WriteHeader(code)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}
+	}
+
+	policy := RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: Duration(time.Millisecond),
+		Multiplier:     2,
+		RetryableCodes: []int{http.StatusServiceUnavailable},
+	}
+
+	t.Run("retried", func(t *testing.T) {
+		p := newTestPeer(t, flaky(2, http.StatusServiceUnavailable))
+
+		var (
+			scheduler = NewScheduler([]*peer.Peer{p}, NewMemoryTaskStore(), 1, logger)
+			task      = NewTask(ModeTypeSequential, 0, "info", true)
+		)
+		task.SetRetryPolicy(policy)
+		if err := scheduler.Register(task); err != nil {
+			t.Fatal(err)
+		}
+		scheduler.execute(task)
+
+		if expected, actual := TaskStatusTypeCompleted, task.Status(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+
+		attempts := task.Attempts()
+		if expected, actual := 3, len(attempts); expected != actual {
+			t.Fatalf("expected: %d, actual: %d", expected, actual)
+		}
+		for k, v := range attempts {
+			if expected, actual := k+1, v.Number; expected != actual {
+				t.Errorf("expected: %d, actual: %d", expected, actual)
+			}
+		}
+	})
+
+	t.Run("exhausted", func(t *testing.T) {
+		p := newTestPeer(t, flaky(5, http.StatusServiceUnavailable))
+
+		var (
+			scheduler = NewScheduler([]*peer.Peer{p}, NewMemoryTaskStore(), 1, logger)
+			task      = NewTask(ModeTypeParallel, 0, "info", true)
+		)
+		task.SetRetryPolicy(policy)
+		if err := scheduler.Register(task); err != nil {
+			t.Fatal(err)
+		}
+		scheduler.execute(task)
+
+		if expected, actual := TaskStatusTypeErrored, task.Status(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := 3, len(task.Attempts()); expected != actual {
+			t.Errorf("expected: %d, actual: %d", expected, actual)
+		}
+	})
+
+	t.Run("not retryable", func(t *testing.T) {
+		p := newTestPeer(t, flaky(5, http.StatusBadRequest))
+
+		var (
+			scheduler = NewScheduler([]*peer.Peer{p}, NewMemoryTaskStore(), 1, logger)
+			task      = NewTask(ModeTypeSequential, 0, "info", true)
+		)
+		task.SetRetryPolicy(policy)
+		if err := scheduler.Register(task); err != nil {
+			t.Fatal(err)
+		}
+		scheduler.execute(task)
+
+		if expected, actual := 1, len(task.Attempts()); expected != actual {
+			t.Errorf("expected: %d, actual: %d", expected, actual)
+		}
+	})
+}
+
+func TestSchedulerDeadline(t *testing.T) {
+	t.Parallel()
+
+	logger := log.NewNopLogger()
+
+	t.Run("request timeout", func(t *testing.T) {
+		p := newTestPeer(t, respond(http.StatusOK, time.Second))
+
+		var (
+			scheduler = NewScheduler([]*peer.Peer{p}, NewMemoryTaskStore(), 1, logger)
+			task      = NewTask(ModeTypeSequential, 0, "info", true)
+		)
+		task.SetRequestTimeout(10 * time.Millisecond)
+		if err := scheduler.Register(task); err != nil {
+			t.Fatal(err)
+		}
+		scheduler.execute(task)
+
+		if expected, actual := TaskStatusTypeErrored, task.Status(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+
+		attempts := task.Attempts()
+		if expected, actual := 1, len(attempts); expected != actual {
+			t.Fatalf("expected: %d, actual: %d", expected, actual)
+		}
+		if attempts[0].Success() || attempts[0].Error == "" {
+			t.Errorf("expected: failed attempt, actual: %v", attempts[0])
+		}
+	})
+
+	for _, mode := range []ModeType{ModeTypeSequential, ModeTypeParallel} {
+		mode := mode
+		t.Run("task deadline "+string(mode), func(t *testing.T) {
+			p := newTestPeer(t, respond(http.StatusOK, time.Second))
+
+			var (
+				scheduler = NewScheduler([]*peer.Peer{p, p}, NewMemoryTaskStore(), 1, logger)
+				task      = NewTask(mode, 0, "info", false)
+			)
+			task.SetDeadline(20 * time.Millisecond)
+			if err := scheduler.Register(task); err != nil {
+				t.Fatal(err)
+			}
+
+			begin := time.Now()
+			scheduler.execute(task)
+
+			if expected, actual := TaskStatusTypeTimedOut, task.Status(); expected != actual {
+				t.Errorf("expected: %v, actual: %v", expected, actual)
+			}
+			if elapsed := time.Since(begin); elapsed > 500*time.Millisecond {
+				t.Errorf("expected: deadline, actual: %v", elapsed)
+			}
+		})
+	}
+}
+
+func TestSchedulerSkipUnhealthy(t *testing.T) {
+	t.Parallel()
+
+	var (
+		logger  = log.NewNopLogger()
+		healthy = newTestPeer(t, respond(http.StatusOK, 0))
+		dead    = peer.NewPeer(http.DefaultClient, "http", "127.0.0.1:1", logger)
+	)
+
+	// Fail the health checks of the dead peer, until it's unhealthy.
+	checker := peer.NewChecker(peer.NewSet([]*peer.Peer{dead}), peer.HealthPolicy{
+		Timeout:            time.Second,
+		HealthyThreshold:   1,
+		UnhealthyThreshold: 1,
+	}, logger)
+	checker.CheckAll()
+	if dead.Healthy() {
+		t.Fatalf("expected: false, actual: %v", dead.Healthy())
+	}
+
+	t.Run("skip", func(t *testing.T) {
+		var (
+			scheduler = NewScheduler([]*peer.Peer{healthy, dead}, NewMemoryTaskStore(), 1, logger)
+			task      = NewTask(ModeTypeSequential, 0, "info", true)
+		)
+		scheduler.SkipUnhealthy(true)
+		if err := scheduler.Register(task); err != nil {
+			t.Fatal(err)
+		}
+		scheduler.execute(task)
+
+		if expected, actual := TaskStatusTypeCompleted, task.Status(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := 1, len(task.Attempts()); expected != actual {
+			t.Errorf("expected: %d, actual: %d", expected, actual)
+		}
+	})
+
+	t.Run("no skip", func(t *testing.T) {
+		var (
+			scheduler = NewScheduler([]*peer.Peer{healthy, dead}, NewMemoryTaskStore(), 1, logger)
+			task      = NewTask(ModeTypeSequential, 0, "info", true)
+		)
+		if err := scheduler.Register(task); err != nil {
+			t.Fatal(err)
+		}
+		scheduler.execute(task)
+
+		if expected, actual := TaskStatusTypeErrored, task.Status(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := 2, len(task.Attempts()); expected != actual {
+			t.Errorf("expected: %d, actual: %d", expected, actual)
+		}
+	})
+
+	t.Run("no healthy peers", func(t *testing.T) {
+		var (
+			scheduler = NewScheduler([]*peer.Peer{dead}, NewMemoryTaskStore(), 1, logger)
+			task      = NewTask(ModeTypeParallel, 0, "info", false)
+		)
+		scheduler.SkipUnhealthy(true)
+		if err := scheduler.Register(task); err != nil {
+			t.Fatal(err)
+		}
+		scheduler.execute(task)
+
+		if expected, actual := TaskStatusTypeErrored, task.Status(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := 0, len(task.Attempts()); expected != actual {
+			t.Errorf("expected: %d, actual: %d", expected, actual)
+		}
+	})
+}
+
+func TestSchedulerMembership(t *testing.T) {
+	t.Parallel()
+
+	var (
+		logger = log.NewNopLogger()
+
+		mutex    sync.Mutex
+		requests = make(map[string]int)
+		release  = make(chan struct{})
+	)
+
+	// counting records every request and blocks until it's released.
+	counting := func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requests[r.Host]++
+		mutex.Unlock()
+
+		respondOnRelease(release)(w, r)
+	}
+
+	var (
+		peers     = newTestPeers(t, counting, counting)
+		a, b      = peers[0], peers[1]
+		scheduler = NewScheduler(peers, NewMemoryTaskStore(), 1, logger)
+	)
+
+	// Start a task, then change the membership whilst it's in flight.
+	task := NewTask(ModeTypeParallel, 0, "info", true)
+	if err := scheduler.Register(task); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		scheduler.execute(task)
+	}()
+
+	for {
+		mutex.Lock()
+		n := requests[a.Addr()] + requests[b.Addr()]
+		mutex.Unlock()
+		if n == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := scheduler.Membership().Drain(a.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	if err := scheduler.Membership().Remove(b.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	<-done
+
+	if expected, actual := TaskStatusTypeCompleted, task.Status(); expected != actual {
+		t.Errorf("expected: %v, actual: %v", expected, actual)
+	}
+
+	// Nothing is left to send the next task to.
+	next := NewTask(ModeTypeParallel, 0, "info", true)
+	if err := scheduler.Register(next); err != nil {
+		t.Fatal(err)
+	}
+	scheduler.execute(next)
+
+	if expected, actual := TaskStatusTypeErrored, next.Status(); expected != actual {
+		t.Errorf("expected: %v, actual: %v", expected, actual)
+	}
+	if expected, actual := 1, len(scheduler.Peers()); expected != actual {
+		t.Errorf("expected: %d, actual: %d", expected, actual)
+	}
+}
+
+func TestSchedulerShared(t *testing.T) {
+	t.Parallel()
+
+	logger := log.NewNopLogger()
+
+	t.Run("share", func(t *testing.T) {
+		scheduler := NewScheduler(nil, struct{ TaskStore }{NewMemoryTaskStore()}, 1, logger)
+		if err := scheduler.Share("a", time.Second); err == nil {
+			t.Errorf("expected: error, actual: %v", err)
+		}
+
+		scheduler = NewScheduler(nil, NewMemoryTaskStore(), 1, logger)
+		if err := scheduler.Share("a", 0); err == nil {
+			t.Errorf("expected: error, actual: %v", err)
+		}
+		if err := scheduler.Share("a", time.Second); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("claimed", func(t *testing.T) {
+		p := newTestPeer(t, respond(http.StatusOK, 0))
+
+		var (
+			store     = NewMemoryTaskStore()
+			scheduler = NewScheduler([]*peer.Peer{p}, store, 1, logger)
+			task      = NewTask(ModeTypeSequential, 0, "info", true)
+		)
+		if err := scheduler.Share("a", time.Second); err != nil {
+			t.Fatal(err)
+		}
+		if err := scheduler.Register(task); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Claim(task.ID(), "b", time.Minute); err != nil {
+			t.Fatal(err)
+		}
+
+		go scheduler.Run()
+		defer scheduler.Stop()
+
+		time.Sleep(50 * time.Millisecond)
+		if expected, actual := TaskStatusTypePending, task.Status(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("takeover", func(t *testing.T) {
+		p := newTestPeer(t, respond(http.StatusOK, 0))
+
+		var (
+			store     = NewMemoryTaskStore()
+			scheduler = NewScheduler([]*peer.Peer{p}, store, 1, logger)
+			task      = NewTask(ModeTypeSequential, 0, "info", true)
+		)
+		if err := scheduler.Share("a", time.Second); err != nil {
+			t.Fatal(err)
+		}
+		if err := scheduler.Register(task); err != nil {
+			t.Fatal(err)
+		}
+
+		// The owner went away whilst requesting.
+		if err := store.Claim(task.ID(), "b", 20*time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.UpdateStatus(task.ID(), TaskStatusTypeRequesting); err != nil {
+			t.Fatal(err)
+		}
+
+		go scheduler.Run()
+		defer scheduler.Stop()
+
+		if !waitForStatus(store, task.ID(), TaskStatusTypeCompleted) {
+			t.Errorf("expected: %v, actual: %v", TaskStatusTypeCompleted, task.Status())
+		}
+		if lease := store.lease(task.ID()); lease.Owner != "" {
+			t.Errorf("expected: released, actual: %v", lease)
+		}
+	})
+
+	t.Run("lease lost", func(t *testing.T) {
+		p := newTestPeer(t, respond(http.StatusOK, time.Second))
+
+		var (
+			store     = NewMemoryTaskStore()
+			scheduler = NewScheduler([]*peer.Peer{p}, store, 1, logger)
+			task      = NewTask(ModeTypeSequential, 0, "info", true)
+		)
+		if err := scheduler.Share("a", 30*time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+		if err := scheduler.Register(task); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Claim(task.ID(), "a", 30*time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+
+		// Another scheduler takes over the task mid-flight.
+		lease := Lease{Owner: "b", Expires: time.Now().Add(time.Minute)}
+		time.AfterFunc(20*time.Millisecond, func() {
+			store.setLease(task.ID(), lease)
+		})
+
+		begin := time.Now()
+		scheduler.execute(task)
+
+		if elapsed := time.Since(begin); elapsed > 500*time.Millisecond {
+			t.Errorf("expected: lease lost, actual: %v", elapsed)
+		}
+		if expected, actual := TaskStatusTypeRequesting, task.Status(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := lease, store.lease(task.ID()); expected.Owner != actual.Owner {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("file", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "tasks")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		p := newTestPeer(t, respond(http.StatusOK, time.Second))
+
+		path := filepath.Join(dir, "tasks.log")
+		a, err := NewSharedFileTaskStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer a.Close()
+
+		b, err := NewSharedFileTaskStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer b.Close()
+
+		var (
+			running = NewScheduler([]*peer.Peer{p}, a, 1, logger)
+			other   = NewScheduler([]*peer.Peer{p}, b, 1, logger)
+			task    = NewTask(ModeTypeSequential, 0, "info", true)
+		)
+		if err := running.Share("a", 30*time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+		if err := other.Share("b", 30*time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+
+		go running.Run()
+		defer running.Stop()
+
+		// The task is registered with one proxy, but executed by the other.
+		if err := other.Register(task); err != nil {
+			t.Fatal(err)
+		}
+		if !waitForStatus(b, task.ID(), TaskStatusTypeRequesting) {
+			t.Fatalf("expected: %v, actual: %v", TaskStatusTypeRequesting, task.Status())
+		}
+
+		// Cancelling the task with the other proxy, cancels the requests.
+		tsk, ok := other.Get(task.ID())
+		if !ok {
+			t.Fatal("no task found")
+		}
+		other.Cancel(tsk)
+
+		if !waitForStatus(a, task.ID(), TaskStatusTypeCancelled) {
+			t.Errorf("expected: %v, actual: cancelled", TaskStatusTypeCancelled)
+		}
+
+		deadline := time.Now().Add(time.Second)
+		for time.Now().Before(deadline) {
+			if tsk, err := b.Get(task.ID()); err == nil && len(tsk.Attempts()) > 0 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if tsk, err := b.Get(task.ID()); err != nil || len(tsk.Attempts()) != 1 {
+			t.Errorf("expected: 1 attempt, actual: %v", tsk.Attempts())
+		} else if tsk.Attempts()[0].Success() {
+			t.Errorf("expected: failed attempt, actual: %v", tsk.Attempts()[0])
+		}
+	})
+}
+
+func TestSchedulerQuorum(t *testing.T) {
+	t.Parallel()
+
+	logger := log.NewNopLogger()
+
+	var (
+		ok   = newTestPeer(t, respond(http.StatusOK, 0))
+		bad  = newTestPeer(t, respond(http.StatusInternalServerError, 0))
+		slow = newTestPeer(t, respond(http.StatusOK, time.Second))
+	)
+
+	for _, testcase := range []struct {
+		name     string
+		peers    []*peer.Peer
+		quorum   int
+		status   TaskStatusType
+		attempts int
+	}{
+		{"majority", []*peer.Peer{ok, ok, slow}, 0, TaskStatusTypeCompleted, 3},
+		{"quorum", []*peer.Peer{bad, ok, slow}, 1, TaskStatusTypeCompleted, 3},
+		{"all", []*peer.Peer{ok, ok, ok}, 3, TaskStatusTypeCompleted, 3},
+		{"unreachable", []*peer.Peer{bad, bad, slow}, 0, TaskStatusTypeErrored, 3},
+		{"too few peers", []*peer.Peer{ok, ok}, 3, TaskStatusTypeErrored, 0},
+	} {
+		testcase := testcase
+		t.Run(testcase.name, func(t *testing.T) {
+			var (
+				scheduler = NewScheduler(testcase.peers, NewMemoryTaskStore(), 1, logger)
+				task      = NewTask(ModeTypeQuorum, 0, "info", false)
+			)
+			task.SetQuorum(testcase.quorum)
+			if err := scheduler.Register(task); err != nil {
+				t.Fatal(err)
+			}
+
+			begin := time.Now()
+			scheduler.execute(task)
+
+			if expected, actual := testcase.status, task.Status(); expected != actual {
+				t.Errorf("expected: %v, actual: %v", expected, actual)
+			}
+			if elapsed := time.Since(begin); elapsed > 500*time.Millisecond {
+				t.Errorf("expected: outstanding requests cancelled, actual: %v", elapsed)
+			}
+			if expected, actual := testcase.attempts, len(task.Attempts()); expected != actual {
+				t.Errorf("expected: %d, actual: %d", expected, actual)
+			}
+		})
+	}
+}
+
+func TestSchedulerRolling(t *testing.T) {
+	t.Parallel()
+
+	logger := log.NewNopLogger()
+
+	// newPeers creates a peer for each of the status codes.
+	newPeers := func(t *testing.T, codes ...int) []*peer.Peer {
+		handlers := make([]http.HandlerFunc, len(codes))
+		for k, code := range codes {
+			handlers[k] = respond(code, 5*time.Millisecond)
+		}
+		return newTestPeers(t, handlers...)
+	}
+
+	t.Run("batches", func(t *testing.T) {
+		peers := newPeers(t, 200, 200, 200, 200, 200)
+
+		var (
+			scheduler = NewScheduler(peers, NewMemoryTaskStore(), 1, logger)
+			task      = NewTask(ModeTypeRolling, 1, "info", true)
+			pause     = 20 * time.Millisecond
+		)
+		task.SetRollingPolicy(RollingPolicy{BatchSize: 2, Pause: Duration(pause)})
+		if err := scheduler.Register(task); err != nil {
+			t.Fatal(err)
+		}
+		scheduler.execute(task)
+
+		if expected, actual := TaskStatusTypeCompleted, task.Status(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+
+		attempts := task.Attempts()
+		if expected, actual := 5, len(attempts); expected != actual {
+			t.Fatalf("expected: %d, actual: %d", expected, actual)
+		}
+
+		// Each batch starts from the client ID offset, once the previous batch
+		// has finished and the pause has passed.
+		batches := [][]*peer.Peer{
+			{peers[1], peers[2]},
+			{peers[3], peers[4]},
+			{peers[0]},
+		}
+		var (
+			offset   int
+			previous time.Time
+		)
+		for _, batch := range batches {
+			var (
+				addrs = make(map[string]struct{})
+				end   time.Time
+			)
+			for _, v := range attempts[offset : offset+len(batch)] {
+				addrs[v.Peer] = struct{}{}
+				if v.Start.Before(previous.Add(pause)) {
+					t.Errorf("expected: start after %v, actual: %v", previous.Add(pause), v.Start)
+				}
+				if v.End.After(end) {
+					end = v.End
+				}
+			}
+			for _, p := range batch {
+				if _, ok := addrs[p.Addr()]; !ok {
+					t.Errorf("expected: %s in batch, actual: %v", p.Addr(), addrs)
+				}
+			}
+			offset, previous = offset+len(batch), end
+		}
+	})
+
+	for _, testcase := range []struct {
+		name     string
+		codes    []int
+		policy   RollingPolicy
+		status   TaskStatusType
+		attempts int
+	}{
+		{"stopped", []int{200, 500, 200, 200}, RollingPolicy{BatchSize: 1}, TaskStatusTypeErrored, 2},
+		{"budget", []int{200, 500, 200, 200}, RollingPolicy{BatchSize: 1, MaxUnavailable: 1}, TaskStatusTypeCompleted, 4},
+		{"batch over budget", []int{500, 500, 200, 200}, RollingPolicy{BatchSize: 2, MaxUnavailable: 1}, TaskStatusTypeErrored, 2},
+	} {
+		testcase := testcase
+		t.Run(testcase.name, func(t *testing.T) {
+			peers := newPeers(t, testcase.codes...)
+
+			var (
+				scheduler = NewScheduler(peers, NewMemoryTaskStore(), 1, logger)
+				task      = NewTask(ModeTypeRolling, 0, "info", true)
+			)
+			task.SetRollingPolicy(testcase.policy)
+			if err := scheduler.Register(task); err != nil {
+				t.Fatal(err)
+			}
+			scheduler.execute(task)
+
+			if expected, actual := testcase.status, task.Status(); expected != actual {
+				t.Errorf("expected: %v, actual: %v", expected, actual)
+			}
+			if expected, actual := testcase.attempts, len(task.Attempts()); expected != actual {
+				t.Errorf("expected: %d, actual: %d", expected, actual)
+			}
+		})
+	}
+
+	t.Run("cancel whilst paused", func(t *testing.T) {
+		peers := newPeers(t, 200, 200)
+
+		var (
+			scheduler = NewScheduler(peers, NewMemoryTaskStore(), 1, logger)
+			task      = NewTask(ModeTypeRolling, 0, "info", true)
+		)
+		task.SetRollingPolicy(RollingPolicy{BatchSize: 1, Pause: Duration(time.Second)})
+		if err := scheduler.Register(task); err != nil {
+			t.Fatal(err)
+		}
+		time.AfterFunc(50*time.Millisecond, func() {
+			scheduler.Cancel(task)
+		})
+
+		begin := time.Now()
+		scheduler.execute(task)
+
+		if expected, actual := TaskStatusTypeCancelled, task.Status(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if elapsed := time.Since(begin); elapsed > 500*time.Millisecond {
+			t.Errorf("expected: cancelled whilst paused, actual: %v", elapsed)
+		}
+		if expected, actual := 1, len(task.Attempts()); expected != actual {
+			t.Errorf("expected: %d, actual: %d", expected, actual)
+		}
+	})
+}
+
+func TestSchedulerCanary(t *testing.T) {
+	t.Parallel()
+
+	logger := log.NewNopLogger()
+
+	// agent serves the update and health routes with the status codes, whilst
+	// counting the health checks.
+	type agent struct {
+		update, health int
+		checks         int
+	}
+	var mutex sync.Mutex
+	newPeers := func(t *testing.T, agents ...*agent) []*peer.Peer {
+		handlers := make([]http.HandlerFunc, len(agents))
+		for k, a := range agents {
+			a := a
+			handlers[k] = func(w http.ResponseWriter, r *http.Request) {
+				mutex.Lock()
+				defer mutex.Unlock()
+
+				if r.URL.Path == "/health" {
+					a.checks++
+					w.WriteHeader(a.health)
+					return
+				}
+				w.WriteHeader(a.update)
+			}
+		}
+		return newTestPeers(t, handlers...)
+	}
+
+	t.Run("promoted", func(t *testing.T) {
+		agents := []*agent{{200, 200, 0}, {200, 200, 0}, {200, 200, 0}, {200, 200, 0}}
+		peers := newPeers(t, agents...)
+
+		var (
+			scheduler = NewScheduler(peers, NewMemoryTaskStore(), 1, logger)
+			task      = NewTask(ModeTypeCanary, 2, "info", true)
+		)
+		task.SetCanaryPolicy(CanaryPolicy{Fraction: 0.5, Soak: Duration(30 * time.Millisecond), Interval: Duration(10 * time.Millisecond)})
+		if err := scheduler.Register(task); err != nil {
+			t.Fatal(err)
+		}
+
+		events, cancel := scheduler.Subscribe(task.ID())
+		defer cancel()
+
+		scheduler.execute(task)
+
+		if expected, actual := TaskStatusTypeCompleted, task.Status(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := TaskPhaseTypePromoted, task.Phase(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+
+		// The canaries start from the client ID offset and only they are
+		// checked, before the rest are sent the info.
+		attempts := task.Attempts()
+		if expected, actual := 4, len(attempts); expected != actual {
+			t.Fatalf("expected: %d, actual: %d", expected, actual)
+		}
+		canaries := map[string]struct{}{
+			attempts[0].Peer: {},
+			attempts[1].Peer: {},
+		}
+		for _, p := range []*peer.Peer{peers[2], peers[3]} {
+			if _, ok := canaries[p.Addr()]; !ok {
+				t.Errorf("expected: %s to be a canary, actual: %v", p.Addr(), canaries)
+			}
+		}
+		for _, v := range attempts[2:] {
+			if !v.Start.After(attempts[1].End) {
+				t.Errorf("expected: %v after %v", v.Start, attempts[1].End)
+			}
+		}
+		mutex.Lock()
+		for k, a := range agents {
+			if canary := k >= 2; canary != (a.checks > 0) {
+				t.Errorf("expected: checked %t, actual: %d checks", canary, a.checks)
+			}
+		}
+		mutex.Unlock()
+
+		var phases []TaskPhaseType
+		for len(events) > 0 {
+			if e := <-events; e.Type == EventTypePhase {
+				phases = append(phases, e.Phase)
+			}
+		}
+		expected := []TaskPhaseType{TaskPhaseTypeCanary, TaskPhaseTypeSoaking, TaskPhaseTypePromoted}
+		if actual := phases; !reflect.DeepEqual(expected, actual) {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	for _, testcase := range []struct {
+		name   string
+		agents []*agent
+		phase  TaskPhaseType
+	}{
+		{"canary failed", []*agent{{500, 200, 0}, {200, 200, 0}}, TaskPhaseTypeCanary},
+		{"canary unhealthy", []*agent{{200, 500, 0}, {200, 200, 0}}, TaskPhaseTypeSoaking},
+	} {
+		testcase := testcase
+		t.Run(testcase.name, func(t *testing.T) {
+			peers := newPeers(t, testcase.agents...)
+
+			var (
+				scheduler = NewScheduler(peers, NewMemoryTaskStore(), 1, logger)
+				task      = NewTask(ModeTypeCanary, 0, "info", true)
+			)
+			task.SetCanaryPolicy(CanaryPolicy{Soak: Duration(time.Second), Interval: Duration(10 * time.Millisecond)})
+			if err := scheduler.Register(task); err != nil {
+				t.Fatal(err)
+			}
+			scheduler.execute(task)
+
+			if expected, actual := TaskStatusTypeErrored, task.Status(); expected != actual {
+				t.Errorf("expected: %v, actual: %v", expected, actual)
+			}
+			if expected, actual := testcase.phase, task.Phase(); expected != actual {
+				t.Errorf("expected: %v, actual: %v", expected, actual)
+			}
+
+			// The rest of the peers are skipped.
+			if expected, actual := 1, len(task.Attempts()); expected != actual {
+				t.Errorf("expected: %d, actual: %d", expected, actual)
+			}
+		})
+	}
+
+	t.Run("cancel whilst soaking", func(t *testing.T) {
+		peers := newPeers(t, &agent{200, 200, 0}, &agent{200, 200, 0})
+
+		var (
+			scheduler = NewScheduler(peers, NewMemoryTaskStore(), 1, logger)
+			task      = NewTask(ModeTypeCanary, 0, "info", true)
+		)
+		task.SetCanaryPolicy(CanaryPolicy{Soak: Duration(time.Minute), Interval: Duration(time.Second)})
+		if err := scheduler.Register(task); err != nil {
+			t.Fatal(err)
+		}
+		time.AfterFunc(50*time.Millisecond, func() {
+			scheduler.Cancel(task)
+		})
+
+		begin := time.Now()
+		scheduler.execute(task)
+
+		if expected, actual := TaskStatusTypeCancelled, task.Status(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := TaskPhaseTypeSoaking, task.Phase(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if elapsed := time.Since(begin); elapsed > 500*time.Millisecond {
+			t.Errorf("expected: cancelled whilst soaking, actual: %v", elapsed)
+		}
+	})
+
+	t.Run("no peers", func(t *testing.T) {
+		var (
+			scheduler = NewScheduler(nil, NewMemoryTaskStore(), 1, logger)
+			task      = NewTask(ModeTypeCanary, 0, "info", true)
+		)
+		task.SetCanaryPolicy(CanaryPolicy{Soak: Duration(time.Minute), Interval: Duration(time.Second)})
+		if err := scheduler.Register(task); err != nil {
+			t.Fatal(err)
+		}
+
+		begin := time.Now()
+		scheduler.execute(task)
+
+		if expected, actual := TaskStatusTypeErrored, task.Status(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if elapsed := time.Since(begin); elapsed > 500*time.Millisecond {
+			t.Errorf("expected: no soak, actual: %v", elapsed)
+		}
+	})
+}
+
+func TestSchedulerSelector(t *testing.T) {
+	t.Parallel()
+
+	logger := log.NewNopLogger()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	// Every peer talks to the same server, so they're told apart by a path
+	// prefix in the address.
+	peers := newPathPeers(server, "0", "1", "2")
+	for k, zone := range []string{"a", "b", "a"} {
+		peers[k].SetLabels(peer.Labels{"zone": zone})
+	}
+
+	for _, testcase := range []struct {
+		name     string
+		selector string
+		status   TaskStatusType
+		peers    []*peer.Peer
+	}{
+		{"every peer", "", TaskStatusTypeCompleted, peers},
+		{"matching peers", "zone=a", TaskStatusTypeCompleted, []*peer.Peer{peers[0], peers[2]}},
+		{"no matching peers", "zone=c", TaskStatusTypeErrored, nil},
+	} {
+		testcase := testcase
+		t.Run(testcase.name, func(t *testing.T) {
+			selector, err := peer.ParseSelector(testcase.selector)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var (
+				scheduler = NewScheduler(peers, NewMemoryTaskStore(), 1, logger)
+				task      = NewTask(ModeTypeSequential, 0, "info", true)
+			)
+			task.SetSelector(selector)
+			if err := scheduler.Register(task); err != nil {
+				t.Fatal(err)
+			}
+			scheduler.execute(task)
+
+			if expected, actual := testcase.status, task.Status(); expected != actual {
+				t.Errorf("expected: %v, actual: %v", expected, actual)
+			}
+
+			var (
+				attempts = task.Attempts()
+				expected = make([]string, len(testcase.peers))
+				actual   = make([]string, len(attempts))
+			)
+			for k, v := range testcase.peers {
+				expected[k] = v.Addr()
+			}
+			for k, v := range attempts {
+				actual[k] = v.Peer
+			}
+			if !reflect.DeepEqual(expected, actual) {
+				t.Errorf("expected: %v, actual: %v", expected, actual)
+			}
+		})
+	}
+}
+
+func TestSchedulerHashed(t *testing.T) {
+	t.Parallel()
+
+	logger := log.NewNopLogger()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/bad/") {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	peers := newPathPeers(server, "0", "1", "2", "3")
+
+	t.Run("single peer", func(t *testing.T) {
+		var (
+			scheduler = NewScheduler(peers, NewMemoryTaskStore(), 1, logger)
+			ring      = peer.NewRing(peers, peer.DefaultReplicas)
+			used      = make(map[string]struct{})
+		)
+		for i := 0; i < 20; i++ {
+			key := fmt.Sprintf("key-%d", i)
+
+			// The same key always lands on the same peer, whatever the client
+			// ID is.
+			for clientID := 0; clientID < 2; clientID++ {
+				task := NewTask(ModeTypeHashed, clientID, "info", true)
+				task.SetKey(key)
+				if err := scheduler.Register(task); err != nil {
+					t.Fatal(err)
+				}
+				scheduler.execute(task)
+
+				if expected, actual := TaskStatusTypeCompleted, task.Status(); expected != actual {
+					t.Errorf("expected: %v, actual: %v", expected, actual)
+				}
+
+				attempts := task.Attempts()
+				if expected, actual := 1, len(attempts); expected != actual {
+					t.Fatalf("expected: %d, actual: %d", expected, actual)
+				}
+				if expected, actual := ring.Get(key).Addr(), attempts[0].Peer; expected != actual {
+					t.Errorf("expected: %s, actual: %s", expected, actual)
+				}
+				used[attempts[0].Peer] = struct{}{}
+			}
+		}
+
+		// The keys are spread over the peers.
+		if len(used) < 2 {
+			t.Errorf("expected: keys spread over the peers, actual: %v", used)
+		}
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		var (
+			scheduler = NewScheduler(newPathPeers(server, "bad"), NewMemoryTaskStore(), 1, logger)
+			task      = NewTask(ModeTypeHashed, 0, "info", true)
+		)
+		task.SetKey("key")
+		if err := scheduler.Register(task); err != nil {
+			t.Fatal(err)
+		}
+		scheduler.execute(task)
+
+		if expected, actual := TaskStatusTypeErrored, task.Status(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+}
+
+func TestSchedulerOne(t *testing.T) {
+	t.Parallel()
+
+	logger := log.NewNopLogger()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/bad/") {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	t.Run("round-robin", func(t *testing.T) {
+		var (
+			peers     = newPathPeers(server, "0", "1", "2")
+			scheduler = NewScheduler(peers, NewMemoryTaskStore(), 1, logger)
+		)
+		for i := 0; i < 6; i++ {
+			task := NewTask(ModeTypeOne, 0, "info", true)
+			if err := scheduler.Register(task); err != nil {
+				t.Fatal(err)
+			}
+			scheduler.execute(task)
+
+			if expected, actual := TaskStatusTypeCompleted, task.Status(); expected != actual {
+				t.Errorf("expected: %v, actual: %v", expected, actual)
+			}
+
+			// Each task goes to a single peer, taking the peers in turn.
+			attempts := task.Attempts()
+			if expected, actual := 1, len(attempts); expected != actual {
+				t.Fatalf("expected: %d, actual: %d", expected, actual)
+			}
+			if expected, actual := peers[i%3].Addr(), attempts[0].Peer; expected != actual {
+				t.Errorf("expected: %s, actual: %s", expected, actual)
+			}
+		}
+	})
+
+	t.Run("fall over", func(t *testing.T) {
+		var (
+			peers     = newPathPeers(server, "bad/0", "1", "2")
+			scheduler = NewScheduler(peers, NewMemoryTaskStore(), 1, logger)
+			task      = NewTask(ModeTypeOne, 0, "info", true)
+		)
+		if err := scheduler.Register(task); err != nil {
+			t.Fatal(err)
+		}
+		scheduler.execute(task)
+
+		if expected, actual := TaskStatusTypeCompleted, task.Status(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+
+		attempts := task.Attempts()
+		if expected, actual := 2, len(attempts); expected != actual {
+			t.Fatalf("expected: %d, actual: %d", expected, actual)
+		}
+		if expected, actual := peers[0].Addr(), attempts[0].Peer; expected != actual {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+		if attempts[1].Peer == peers[0].Addr() || !attempts[1].Success() {
+			t.Errorf("expected: another peer to succeed, actual: %v", attempts[1])
+		}
+	})
+
+	t.Run("every peer failed", func(t *testing.T) {
+		for _, balance := range []peer.BalanceType{
+			peer.BalanceTypeRoundRobin,
+			peer.BalanceTypeLeastOutstanding,
+			peer.BalanceTypePowerOfTwo,
+		} {
+			var (
+				peers     = newPathPeers(server, "bad/0", "bad/1", "bad/2")
+				scheduler = NewScheduler(peers, NewMemoryTaskStore(), 1, logger)
+				task      = NewTask(ModeTypeOne, 0, "info", true)
+			)
+			task.SetBalance(balance)
+			if err := scheduler.Register(task); err != nil {
+				t.Fatal(err)
+			}
+			scheduler.execute(task)
+
+			if expected, actual := TaskStatusTypeErrored, task.Status(); expected != actual {
+				t.Errorf("%s: expected: %v, actual: %v", balance, expected, actual)
+			}
+
+			// Every peer is tried exactly once.
+			tried := make(map[string]struct{})
+			for _, v := range task.Attempts() {
+				tried[v.Peer] = struct{}{}
+			}
+			if expected, actual := 3, len(task.Attempts()); expected != actual {
+				t.Errorf("%s: expected: %d, actual: %d", balance, expected, actual)
+			}
+			if expected, actual := 3, len(tried); expected != actual {
+				t.Errorf("%s: expected: %d, actual: %d", balance, expected, actual)
+			}
+		}
+	})
+}
+
+func TestSchedulerHedge(t *testing.T) {
+	t.Parallel()
+
+	logger := log.NewNopLogger()
+
+	// The stalled peers only answer once the request is cancelled.
+	var (
+		mutex     sync.Mutex
+		cancelled = make(map[string]int)
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/stall/"):
+			select {
+			case <-r.Context().Done():
+				mutex.Lock()
+				cancelled[r.URL.Path]++
+				mutex.Unlock()
+			case <-time.After(5 * time.Second):
+			}
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case strings.HasPrefix(r.URL.Path, "/slow/"):
+			time.Sleep(100 * time.Millisecond)
+			w.WriteHeader(http.StatusOK)
+		case strings.HasPrefix(r.URL.Path, "/bad/"):
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer server.Close()
+
+	// wasCancelled waits for the server to notice the request was cancelled.
+	wasCancelled := func(path string) bool {
+		for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); {
+			mutex.Lock()
+			n := cancelled[path]
+			mutex.Unlock()
+			if n > 0 {
+				return true
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		return false
+	}
+
+	// run the task against the peers, returning the attempts by peer.
+	run := func(t *testing.T, task *Task, peers []*peer.Peer) map[string]Attempt {
+		scheduler := NewScheduler(peers, NewMemoryTaskStore(), 1, logger)
+		if err := scheduler.Register(task); err != nil {
+			t.Fatal(err)
+		}
+		scheduler.execute(task)
+
+		res := make(map[string]Attempt)
+		for _, v := range task.Attempts() {
+			res[v.Peer] = v
+		}
+		return res
+	}
+
+	t.Run("one hedged", func(t *testing.T) {
+		var (
+			peers = newPathPeers(server, "stall/a", "0")
+			task  = NewTask(ModeTypeOne, 0, "info", true)
+			begin = time.Now()
+		)
+		task.SetHedgePolicy(HedgePolicy{Delay: Duration(20 * time.Millisecond)})
+
+		attempts := run(t, task, peers)
+		if expected, actual := TaskStatusTypeCompleted, task.Status(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if elapsed := time.Since(begin); elapsed > time.Second {
+			t.Errorf("expected: hedged, actual: %v", elapsed)
+		}
+
+		// The stalled request lost, so it's cancelled.
+		if expected, actual := 2, len(attempts); expected != actual {
+			t.Fatalf("expected: %d, actual: %d", expected, actual)
+		}
+		if attempt := attempts[peers[0].Addr()]; attempt.Success() {
+			t.Errorf("expected: stalled request to lose, actual: %v", attempt)
+		}
+		if attempt := attempts[peers[1].Addr()]; !attempt.Success() {
+			t.Errorf("expected: hedged request to win, actual: %v", attempt)
+		}
+		if !wasCancelled("/stall/a/update") {
+			t.Errorf("expected: stalled request to be cancelled")
+		}
+	})
+
+	t.Run("one hedge loses", func(t *testing.T) {
+		var (
+			peers = newPathPeers(server, "slow/a", "stall/b")
+			task  = NewTask(ModeTypeOne, 0, "info", true)
+		)
+		task.SetHedgePolicy(HedgePolicy{Delay: Duration(20 * time.Millisecond)})
+
+		attempts := run(t, task, peers)
+		if expected, actual := TaskStatusTypeCompleted, task.Status(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if attempt := attempts[peers[0].Addr()]; !attempt.Success() {
+			t.Errorf("expected: slow request to win, actual: %v", attempt)
+		}
+		if attempt := attempts[peers[1].Addr()]; attempt.Success() {
+			t.Errorf("expected: hedged request to lose, actual: %v", attempt)
+		}
+		if !wasCancelled("/stall/b/update") {
+			t.Errorf("expected: stalled request to be cancelled")
+		}
+	})
+
+	t.Run("one not hedged", func(t *testing.T) {
+		var (
+			peers = newPathPeers(server, "slow/c", "0")
+			task  = NewTask(ModeTypeOne, 0, "info", true)
+		)
+
+		attempts := run(t, task, peers)
+		if expected, actual := TaskStatusTypeCompleted, task.Status(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := 1, len(attempts); expected != actual {
+			t.Errorf("expected: %d, actual: %d", expected, actual)
+		}
+	})
+
+	t.Run("quorum hedged", func(t *testing.T) {
+		var (
+			peers = newPathPeers(server, "stall/d", "1", "2", "3")
+			task  = NewTask(ModeTypeQuorum, 0, "info", true)
+		)
+		task.SetQuorum(2)
+		task.SetHedgePolicy(HedgePolicy{Delay: Duration(20 * time.Millisecond)})
+
+		attempts := run(t, task, peers)
+		if expected, actual := TaskStatusTypeCompleted, task.Status(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+
+		// Only the quorum of the peers and a single hedge are sent the info.
+		if expected, actual := 3, len(attempts); expected != actual {
+			t.Errorf("expected: %d, actual: %d", expected, actual)
+		}
+		if _, ok := attempts[peers[3].Addr()]; ok {
+			t.Errorf("expected: %s to be spare", peers[3].Addr())
+		}
+	})
+
+	for _, testcase := range []struct {
+		name   string
+		paths  []string
+		status TaskStatusType
+	}{
+		{"quorum fall over", []string{"bad/0", "1", "2"}, TaskStatusTypeCompleted},
+		{"quorum no spares", []string{"bad/0", "bad/1", "2"}, TaskStatusTypeErrored},
+	} {
+		testcase := testcase
+		t.Run(testcase.name, func(t *testing.T) {
+			var (
+				peers = newPathPeers(server, testcase.paths...)
+				task  = NewTask(ModeTypeQuorum, 0, "info", true)
+			)
+			task.SetQuorum(2)
+			task.SetHedgePolicy(HedgePolicy{Delay: Duration(time.Minute)})
+
+			attempts := run(t, task, peers)
+			if expected, actual := testcase.status, task.Status(); expected != actual {
+				t.Errorf("expected: %v, actual: %v", expected, actual)
+			}
+			if expected, actual := 3, len(attempts); expected != actual {
+				t.Errorf("expected: %d, actual: %d", expected, actual)
+			}
+		})
+	}
+}
+
+func TestSchedulerBreaker(t *testing.T) {
+	t.Parallel()
+
+	logger := log.NewNopLogger()
+
+	// The bad peers recover once healed.
+	var (
+		mutex  sync.Mutex
+		healed bool
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		ok := healed
+		mutex.Unlock()
+
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/bad/") && !ok:
+			w.WriteHeader(http.StatusInternalServerError)
+		case strings.HasPrefix(r.URL.Path, "/stall/"):
+			<-r.Context().Done()
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer server.Close()
+
+	// run a task against the peers, returning the task once it's finished.
+	run := func(t *testing.T, scheduler *Scheduler, task *Task) *Task {
+		if err := scheduler.Register(task); err != nil {
+			t.Fatal(err)
+		}
+		scheduler.execute(task)
+		return task
+	}
+
+	t.Run("skipped once open", func(t *testing.T) {
+		var (
+			bad       = newPathPeers(server, "bad/0")[0]
+			scheduler = NewScheduler([]*peer.Peer{bad}, NewMemoryTaskStore(), 1, logger)
+		)
+		scheduler.SetBreakerPolicy(peer.BreakerPolicy{FailureRatio: 1, Window: 2, CoolDown: time.Minute})
+
+		for i := 0; i < 2; i++ {
+			task := run(t, scheduler, NewTask(ModeTypeSequential, 0, "info", true))
+			if attempt := task.Attempts()[0]; attempt.Skipped {
+				t.Errorf("%d: expected: sent, actual: %v", i, attempt)
+			}
+		}
+		if expected, actual := peer.BreakerStateOpen, bad.Breaker().State; expected != actual {
+			t.Fatalf("expected: %s, actual: %s", expected, actual)
+		}
+
+		// Skipped requests aren't retried.
+		task := NewTask(ModeTypeSequential, 0, "info", true)
+		task.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, Multiplier: 1})
+		run(t, scheduler, task)
+
+		if expected, actual := TaskStatusTypeErrored, task.Status(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		attempts := task.Attempts()
+		if expected, actual := 1, len(attempts); expected != actual {
+			t.Fatalf("expected: %d, actual: %d", expected, actual)
+		}
+		if expected, actual := SkippedCircuitOpen, attempts[0].Error; !attempts[0].Skipped || expected != actual {
+			t.Errorf("expected: %s, actual: %v", expected, attempts[0])
+		}
+
+		// Nor is a skipped request ever created.
+		var tracked int
+		scheduler.tryTracked(context.Background(), task, bad, func(*peer.Request) { tracked++ })
+		if expected, actual := 0, tracked; expected != actual {
+			t.Errorf("expected: %d, actual: %d", expected, actual)
+		}
+	})
+
+	t.Run("one falls over", func(t *testing.T) {
+		var (
+			bad       = newPathPeers(server, "bad/1")[0]
+			good      = newPathPeers(server, "1")[0]
+			scheduler = NewScheduler([]*peer.Peer{bad, good}, NewMemoryTaskStore(), 1, logger)
+		)
+		scheduler.SetBreakerPolicy(peer.BreakerPolicy{FailureRatio: 1, Window: 1, CoolDown: time.Minute})
+
+		// The first task trips the circuit of the bad peer, then the rest
+		// skip it.
+		for i := 0; i < 4; i++ {
+			task := run(t, scheduler, NewTask(ModeTypeOne, 0, "info", true))
+			if expected, actual := TaskStatusTypeCompleted, task.Status(); expected != actual {
+				t.Errorf("%d: expected: %v, actual: %v", i, expected, actual)
+			}
+			for _, attempt := range task.Attempts() {
+				if attempt.Peer == bad.Addr() && i > 0 && !attempt.Skipped {
+					t.Errorf("%d: expected: skipped, actual: %v", i, attempt)
+				}
+			}
+		}
+	})
+
+	t.Run("half-open recovers", func(t *testing.T) {
+		var (
+			bad       = newPathPeers(server, "bad/2")[0]
+			scheduler = NewScheduler([]*peer.Peer{bad}, NewMemoryTaskStore(), 1, logger)
+		)
+		scheduler.SetBreakerPolicy(peer.BreakerPolicy{FailureRatio: 1, Window: 1, CoolDown: 20 * time.Millisecond})
+
+		run(t, scheduler, NewTask(ModeTypeSequential, 0, "info", true))
+		if expected, actual := peer.BreakerStateOpen, bad.Breaker().State; expected != actual {
+			t.Fatalf("expected: %s, actual: %s", expected, actual)
+		}
+
+		mutex.Lock()
+		healed = true
+		mutex.Unlock()
+		defer func() {
+			mutex.Lock()
+			healed = false
+			mutex.Unlock()
+		}()
+		time.Sleep(40 * time.Millisecond)
+
+		task := run(t, scheduler, NewTask(ModeTypeSequential, 0, "info", true))
+		if expected, actual := TaskStatusTypeCompleted, task.Status(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := peer.BreakerStateClosed, bad.Breaker().State; expected != actual {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+	})
+
+	t.Run("cancelled requests", func(t *testing.T) {
+		var (
+			stall     = newPathPeers(server, "stall/3")[0]
+			scheduler = NewScheduler([]*peer.Peer{newPathPeers(server, "3")[0], stall}, NewMemoryTaskStore(), 1, logger)
+		)
+		scheduler.SetBreakerPolicy(peer.BreakerPolicy{FailureRatio: 1, Window: 1, CoolDown: time.Minute})
+
+		// The stalled request is cancelled once the quorum is reached.
+		task := NewTask(ModeTypeQuorum, 0, "info", true)
+		task.SetQuorum(1)
+		run(t, scheduler, task)
+
+		if expected, actual := TaskStatusTypeCompleted, task.Status(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := (peer.Breaker{State: peer.BreakerStateClosed}), stall.Breaker(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("timeouts", func(t *testing.T) {
+		var (
+			stall     = newPathPeers(server, "stall/4")[0]
+			scheduler = NewScheduler([]*peer.Peer{stall}, NewMemoryTaskStore(), 1, logger)
+		)
+		scheduler.SetBreakerPolicy(peer.BreakerPolicy{FailureRatio: 1, Window: 1, CoolDown: time.Minute})
+
+		// Running out of time with the task isn't down to the peer.
+		task := NewTask(ModeTypeSequential, 0, "info", true)
+		task.SetDeadline(20 * time.Millisecond)
+		run(t, scheduler, task)
+
+		if expected, actual := TaskStatusTypeTimedOut, task.Status(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := (peer.Breaker{State: peer.BreakerStateClosed}), stall.Breaker(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+
+		// Whereas the peer not responding in time is.
+		task = NewTask(ModeTypeSequential, 0, "info", true)
+		task.SetRequestTimeout(20 * time.Millisecond)
+		run(t, scheduler, task)
+
+		if expected, actual := peer.BreakerStateOpen, stall.Breaker().State; expected != actual {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+	})
+}
+
+// newTestPeers creates a peer for each of the handlers, whose servers are
+// closed once the test has finished.
+func newTestPeers(t *testing.T, handlers ...http.HandlerFunc) []*peer.Peer {
+	peers := make([]*peer.Peer, len(handlers))
+	for k, handler := range handlers {
+		server := httptest.NewServer(handler)
+		t.Cleanup(server.Close)
+		peers[k] = peer.NewPeer(http.DefaultClient, "http", strings.Replace(server.URL, "http://", "", 1), log.NewNopLogger())
+	}
+	return peers
+}
+
+// newTestPeer creates a peer for the handler.
+func newTestPeer(t *testing.T, handler http.HandlerFunc) *peer.Peer {
+	return newTestPeers(t, handler)[0]
+}
+
+// newPathPeers creates a peer for each of the paths on the server, so that the
+// handler of the server can tell the peers apart.
+func newPathPeers(server *httptest.Server, paths ...string) []*peer.Peer {
+	var (
+		addr  = strings.Replace(server.URL, "http://", "", 1)
+		peers = make([]*peer.Peer, len(paths))
+	)
+	for k, v := range paths {
+		peers[k] = peer.NewPeer(http.DefaultClient, "http", fmt.Sprintf("%s/%s", addr, v), log.NewNopLogger())
+	}
+	return peers
+}
+
+// respond with the status code, once the duration has passed or the request
+// goes away.
+func respond(code int, d time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(d):
+		case <-r.Context().Done():
+		}
+		w.WriteHeader(code)
+	}
+}
+
+// respondOnRelease responds once the release channel is closed.
+func respondOnRelease(release <-chan struct{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// waitForStatus of the task, as it's known to the store.
+func waitForStatus(store TaskStore, id string, status TaskStatusType) bool {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if task, err := store.Get(id); err == nil && task.Status() == status {
+			return true
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return false
+}
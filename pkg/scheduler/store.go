@@ -0,0 +1,296 @@
+package scheduler
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrTaskNotFound is returned by a TaskStore when no Task matches the ID.
+var ErrTaskNotFound = errors.New("no task found")
+
+var (
+	// ErrTaskClaimed is returned by a SharedTaskStore when the Task is already
+	// leased by a scheduler, or can no longer be claimed.
+	ErrTaskClaimed = errors.New("task already claimed")
+
+	// ErrLeaseLost is returned by a SharedTaskStore when the lease on a Task is
+	// no longer held by the owner.
+	ErrLeaseLost = errors.New("lease lost")
+)
+
+// TaskStore persists the Tasks for the Scheduler, which allows the tasks to be
+// queried (or resumed) with in the lifetime of the store.
+type TaskStore interface {
+	// Put a new Task into the store.
+	Put(*Task) error
+
+	// Get a Task by an ID.
+	// If no task is found, then it returns ErrTaskNotFound.
+	Get(id string) (*Task, error)
+
+	// List all the Tasks in the order that they were put into the store.
+	List() ([]*Task, error)
+
+	// UpdateStatus of a Task, that's already been put into the store.
+	UpdateStatus(id string, status TaskStatusType) error
+
+	// UpdatePhase of a Task, that's already been put into the store.
+	UpdatePhase(id string, phase TaskPhaseType) error
+
+	// Query the Tasks, returning the Tasks that match and a cursor for the
+	// next page of Tasks.
+	Query(q TaskQuery) ([]*Task, string, error)
+
+	// AddAttempt to a Task, that's already been put into the store.
+	AddAttempt(id string, attempt Attempt) error
+
+	// Close the store.
+	Close() error
+}
+
+// SharedTaskStore is a TaskStore that can be shared between schedulers, which
+// have to claim a lease on a Task before executing it. A Task with an expired
+// lease can be claimed by another scheduler, so that the Task is taken over if
+// the owning scheduler goes away.
+type SharedTaskStore interface {
+	TaskStore
+
+	// Claim a lease on a Task for the owner, if the Task isn't finished and
+	// isn't leased by any owner.
+	// If the Task can't be claimed, then it returns ErrTaskClaimed.
+	Claim(id, owner string, ttl time.Duration) error
+
+	// Renew the lease on a Task held by the owner.
+	// If the lease is no longer held by the owner, then it returns ErrLeaseLost.
+	Renew(id, owner string, ttl time.Duration) error
+
+	// Release the lease on a Task held by the owner.
+	Release(id, owner string) error
+}
+
+// Lease defines which owner can execute a Task and until when.
+type Lease struct {
+	Owner   string    `json:"owner"`
+	Expires time.Time `json:"expires"`
+}
+
+// Held defines if the lease is held by anyone at the time.
+func (l Lease) Held(now time.Time) bool {
+	return l.Owner != "" && now.Before(l.Expires)
+}
+
+// MemoryTaskStore keeps all the Tasks in memory, so every Task is lost once
+// the store goes away.
+type MemoryTaskStore struct {
+	mutex  sync.RWMutex
+	index  *index
+	leases map[string]Lease
+}
+
+// NewMemoryTaskStore creates a new in-memory TaskStore.
+func NewMemoryTaskStore() *MemoryTaskStore {
+	return &MemoryTaskStore{
+		mutex:  sync.RWMutex{},
+		index:  newIndex(),
+		leases: make(map[string]Lease),
+	}
+}
+
+// Put a new Task into the store.
+func (s *MemoryTaskStore) Put(task *Task) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.index.get(task.ID()); ok {
+		return errors.Errorf("task %q already exists", task.ID())
+	}
+
+	s.index.insert(task)
+	return nil
+}
+
+// Get a Task by an ID.
+func (s *MemoryTaskStore) Get(id string) (*Task, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if task, ok := s.index.get(id); ok {
+		return task, nil
+	}
+	return nil, ErrTaskNotFound
+}
+
+// List all the Tasks in the order that they were put into the store.
+func (s *MemoryTaskStore) List() ([]*Task, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	res := make([]*Task, len(s.index.tasks))
+	copy(res, s.index.tasks)
+	return res, nil
+}
+
+// Query the Tasks, returning the Tasks that match and a cursor for the next
+// page of Tasks.
+func (s *MemoryTaskStore) Query(q TaskQuery) ([]*Task, string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.index.query(q)
+}
+
+// UpdateStatus of a Task, that's already been put into the store.
+func (s *MemoryTaskStore) UpdateStatus(id string, status TaskStatusType) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	task, ok := s.index.get(id)
+	if !ok {
+		return ErrTaskNotFound
+	}
+
+	task.SetStatus(status)
+	s.index.update(id, status)
+	return nil
+}
+
+// UpdatePhase of a Task, that's already been put into the store.
+func (s *MemoryTaskStore) UpdatePhase(id string, phase TaskPhaseType) error {
+	task, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+
+	task.SetPhase(phase)
+	return nil
+}
+
+// AddAttempt to a Task, that's already been put into the store.
+func (s *MemoryTaskStore) AddAttempt(id string, attempt Attempt) error {
+	task, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+
+	task.addAttempt(attempt)
+	return nil
+}
+
+// Claim a lease on a Task for the owner, if the Task isn't finished and isn't
+// leased by any owner.
+func (s *MemoryTaskStore) Claim(id, owner string, ttl time.Duration) error {
+	_, err := s.claim(id, owner, ttl, time.Now())
+	return err
+}
+
+// Renew the lease on a Task held by the owner.
+func (s *MemoryTaskStore) Renew(id, owner string, ttl time.Duration) error {
+	_, err := s.renew(id, owner, ttl, time.Now())
+	return err
+}
+
+// Release the lease on a Task held by the owner.
+func (s *MemoryTaskStore) Release(id, owner string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.index.get(id); !ok {
+		return ErrTaskNotFound
+	}
+	if s.leases[id].Owner == owner {
+		delete(s.leases, id)
+	}
+	return nil
+}
+
+// Close the store.
+func (s *MemoryTaskStore) Close() error {
+	return nil
+}
+
+func (s *MemoryTaskStore) claim(id, owner string, ttl time.Duration, now time.Time) (Lease, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.index.get(id); !ok {
+		return Lease{}, ErrTaskNotFound
+	}
+	if s.index.status[id].Terminal() || s.leases[id].Held(now) {
+		return Lease{}, ErrTaskClaimed
+	}
+
+	lease := Lease{Owner: owner, Expires: now.Add(ttl)}
+	s.leases[id] = lease
+	return lease, nil
+}
+
+func (s *MemoryTaskStore) renew(id, owner string, ttl time.Duration, now time.Time) (Lease, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.index.get(id); !ok {
+		return Lease{}, ErrTaskNotFound
+	}
+	// A lease that has expired can still be renewed, as long as nobody else
+	// has claimed the Task in the meantime.
+	if s.leases[id].Owner != owner {
+		return Lease{}, ErrLeaseLost
+	}
+
+	lease := Lease{Owner: owner, Expires: now.Add(ttl)}
+	s.leases[id] = lease
+	return lease, nil
+}
+
+// replace a Task that's already in the store with the copy, or put the copy if
+// it's a new Task, which is used when replaying a compacted log. The lease on
+// the Task is dropped, as the log holds the leases after the Tasks.
+func (s *MemoryTaskStore) replace(task *Task) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	current, ok := s.index.get(task.ID())
+	if !ok {
+		s.index.insert(task)
+		return
+	}
+
+	current.restore(task)
+	s.index.update(task.ID(), task.Status())
+	delete(s.leases, task.ID())
+}
+
+// setLease replaces the lease on a Task, which is used when replaying a log.
+func (s *MemoryTaskStore) setLease(id string, lease Lease) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.index.get(id); !ok {
+		return ErrTaskNotFound
+	}
+	if lease.Owner == "" {
+		delete(s.leases, id)
+	} else {
+		s.leases[id] = lease
+	}
+	return nil
+}
+
+// lease returns the lease on a Task, which is empty if nobody claimed it.
+func (s *MemoryTaskStore) lease(id string) Lease {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.leases[id]
+}
+
+// status returns the status of a Task as it's known to the store.
+func (s *MemoryTaskStore) status(id string) (TaskStatusType, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	status, ok := s.index.status[id]
+	return status, ok
+}
@@ -0,0 +1,200 @@
+package scheduler
+
+import (
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/SimonRichardson/cmdproxy/pkg/test"
+)
+
+func TestMemoryTaskStore(t *testing.T) {
+	t.Parallel()
+
+	t.Run("put", func(t *testing.T) {
+		fn := func(a test.ASCII) bool {
+			var (
+				store = NewMemoryTaskStore()
+				task  = NewTask(ModeTypeSequential, 0, a.String(), false)
+			)
+			if err := store.Put(task); err != nil {
+				t.Error(err)
+			}
+
+			tsk, err := store.Get(task.ID())
+			if err != nil {
+				t.Error(err)
+			}
+			return tsk.Info() == a.String()
+		}
+
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("put duplicate", func(t *testing.T) {
+		var (
+			store = NewMemoryTaskStore()
+			task  = NewTask(ModeTypeSequential, 0, "info", false)
+		)
+		if err := store.Put(task); err != nil {
+			t.Error(err)
+		}
+		if err := store.Put(task); err == nil {
+			t.Errorf("expected: error, actual: %v", err)
+		}
+	})
+
+	t.Run("get", func(t *testing.T) {
+		store := NewMemoryTaskStore()
+		if _, err := store.Get("bad"); err != ErrTaskNotFound {
+			t.Errorf("expected: %v, actual: %v", ErrTaskNotFound, err)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		var (
+			store = NewMemoryTaskStore()
+			tasks = []*Task{
+				NewTask(ModeTypeSequential, 0, "a", false),
+				NewTask(ModeTypeParallel, 0, "b", false),
+				NewTask(ModeTypeSequential, 0, "c", false),
+			}
+		)
+		for _, v := range tasks {
+			if err := store.Put(v); err != nil {
+				t.Error(err)
+			}
+		}
+
+		res, err := store.List()
+		if err != nil {
+			t.Error(err)
+		}
+		if expected, actual := len(tasks), len(res); expected != actual {
+			t.Fatalf("expected: %d, actual: %d", expected, actual)
+		}
+		for k, v := range res {
+			if expected, actual := tasks[k].ID(), v.ID(); expected != actual {
+				t.Errorf("expected: %s, actual: %s", expected, actual)
+			}
+		}
+	})
+
+	t.Run("update status", func(t *testing.T) {
+		var (
+			store = NewMemoryTaskStore()
+			task  = NewTask(ModeTypeSequential, 0, "info", false)
+		)
+		if err := store.Put(task); err != nil {
+			t.Error(err)
+		}
+		if err := store.UpdateStatus(task.ID(), TaskStatusTypeCompleted); err != nil {
+			t.Error(err)
+		}
+		if expected, actual := TaskStatusTypeCompleted, task.Status(); expected != actual {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+		if err := store.UpdateStatus("bad", TaskStatusTypeCompleted); err != ErrTaskNotFound {
+			t.Errorf("expected: %v, actual: %v", ErrTaskNotFound, err)
+		}
+	})
+
+	t.Run("update phase", func(t *testing.T) {
+		var (
+			store = NewMemoryTaskStore()
+			task  = NewTask(ModeTypeCanary, 0, "info", false)
+		)
+		if err := store.Put(task); err != nil {
+			t.Error(err)
+		}
+		if err := store.UpdatePhase(task.ID(), TaskPhaseTypeSoaking); err != nil {
+			t.Error(err)
+		}
+		if expected, actual := TaskPhaseTypeSoaking, task.Phase(); expected != actual {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+		if err := store.UpdatePhase("bad", TaskPhaseTypeSoaking); err != ErrTaskNotFound {
+			t.Errorf("expected: %v, actual: %v", ErrTaskNotFound, err)
+		}
+	})
+	t.Run("lease", func(t *testing.T) {
+		var (
+			store = NewMemoryTaskStore()
+			task  = NewTask(ModeTypeSequential, 0, "info", false)
+		)
+		if err := store.Put(task); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.Claim(task.ID(), "a", time.Minute); err != nil {
+			t.Error(err)
+		}
+		if err := store.Claim(task.ID(), "b", time.Minute); err != ErrTaskClaimed {
+			t.Errorf("expected: %v, actual: %v", ErrTaskClaimed, err)
+		}
+		if err := store.Renew(task.ID(), "a", time.Minute); err != nil {
+			t.Error(err)
+		}
+		if err := store.Renew(task.ID(), "b", time.Minute); err != ErrLeaseLost {
+			t.Errorf("expected: %v, actual: %v", ErrLeaseLost, err)
+		}
+
+		// Releasing a lease held by someone else does nothing.
+		if err := store.Release(task.ID(), "b"); err != nil {
+			t.Error(err)
+		}
+		if err := store.Claim(task.ID(), "b", time.Minute); err != ErrTaskClaimed {
+			t.Errorf("expected: %v, actual: %v", ErrTaskClaimed, err)
+		}
+
+		if err := store.Release(task.ID(), "a"); err != nil {
+			t.Error(err)
+		}
+		if err := store.Claim(task.ID(), "b", time.Minute); err != nil {
+			t.Error(err)
+		}
+
+		if err := store.Claim("bad", "a", time.Minute); err != ErrTaskNotFound {
+			t.Errorf("expected: %v, actual: %v", ErrTaskNotFound, err)
+		}
+	})
+
+	t.Run("lease expired", func(t *testing.T) {
+		var (
+			store = NewMemoryTaskStore()
+			task  = NewTask(ModeTypeSequential, 0, "info", false)
+		)
+		if err := store.Put(task); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.Claim(task.ID(), "a", -time.Second); err != nil {
+			t.Error(err)
+		}
+		if err := store.Claim(task.ID(), "b", time.Minute); err != nil {
+			t.Error(err)
+		}
+		if err := store.Renew(task.ID(), "a", time.Minute); err != ErrLeaseLost {
+			t.Errorf("expected: %v, actual: %v", ErrLeaseLost, err)
+		}
+	})
+
+	t.Run("lease finished", func(t *testing.T) {
+		var (
+			store = NewMemoryTaskStore()
+			task  = NewTask(ModeTypeSequential, 0, "info", false)
+		)
+		if err := store.Put(task); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.UpdateStatus(task.ID(), TaskStatusTypeCancelled); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := store.Claim(task.ID(), "a", time.Minute); err != ErrTaskClaimed {
+			t.Errorf("expected: %v, actual: %v", ErrTaskClaimed, err)
+		}
+	})
+}
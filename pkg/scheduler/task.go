@@ -2,10 +2,13 @@ package scheduler
 
 import (
 	"context"
+	"time"
 
 	"sync"
 
+	"github.com/SimonRichardson/cmdproxy/pkg/peer"
 	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
 )
 
 // Task collects all the information required for the scheduler, which can then
@@ -17,8 +20,22 @@ type Task struct {
 	clientID    int
 	info        string
 	failOnError bool
+	retry       RetryPolicy
+	timeout     time.Duration
+	deadline    time.Duration
+	quorum      int
+	rolling     RollingPolicy
+	canary      CanaryPolicy
+	selector    peer.Selector
+	key         string
+	balance     peer.BalanceType
+	hedge       HedgePolicy
 	status      TaskStatusType
+	phase       TaskPhaseType
+	created     time.Time
+	attempts    []Attempt
 	cancelFns   []context.CancelFunc
+	done        chan struct{}
 }
 
 // NewTask creates a Task with all the model data.
@@ -30,7 +47,13 @@ func NewTask(mode ModeType, clientID int, info string, failOnError bool) *Task {
 		clientID:    clientID,
 		info:        info,
 		failOnError: failOnError,
+		retry:       DefaultRetryPolicy(),
+		rolling:     DefaultRollingPolicy(),
+		canary:      DefaultCanaryPolicy(),
+		balance:     peer.BalanceTypeRoundRobin,
 		status:      TaskStatusTypePending,
+		created:     time.Now(),
+		done:        make(chan struct{}),
 	}
 }
 
@@ -62,14 +85,269 @@ func (t *Task) FailOnError() bool {
 	return t.failOnError
 }
 
+// RetryPolicy defines how failed requests to the peer agents are retried.
+func (t *Task) RetryPolicy() RetryPolicy {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.retry
+}
+
+// SetRetryPolicy allows the updating of the retry policy, before the Task is
+// registered with the scheduler.
+func (t *Task) SetRetryPolicy(p RetryPolicy) {
+	t.mutex.Lock()
+	t.retry = p
+	t.mutex.Unlock()
+}
+
+// RequestTimeout defines how long each request to a peer agent can take, where
+// zero means that there is no timeout.
+func (t *Task) RequestTimeout() time.Duration {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.timeout
+}
+
+// SetRequestTimeout allows the updating of the request timeout, before the
+// Task is registered with the scheduler.
+func (t *Task) SetRequestTimeout(d time.Duration) {
+	t.mutex.Lock()
+	t.timeout = d
+	t.mutex.Unlock()
+}
+
+// Deadline defines how long the Task can take once the scheduler starts to
+// execute it, where zero means that there is no deadline.
+func (t *Task) Deadline() time.Duration {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.deadline
+}
+
+// SetDeadline allows the updating of the deadline, before the Task is
+// registered with the scheduler.
+func (t *Task) SetDeadline(d time.Duration) {
+	t.mutex.Lock()
+	t.deadline = d
+	t.mutex.Unlock()
+}
+
+// Quorum defines how many peer agents have to accept the Task info before the
+// Task is completed, when in quorum mode. Zero means a majority of the peer
+// agents.
+func (t *Task) Quorum() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.quorum
+}
+
+// SetQuorum allows the updating of the quorum, before the Task is registered
+// with the scheduler.
+func (t *Task) SetQuorum(n int) {
+	t.mutex.Lock()
+	t.quorum = n
+	t.mutex.Unlock()
+}
+
+// RollingPolicy defines how the Task is rolled out to the peer agents, when in
+// rolling mode.
+func (t *Task) RollingPolicy() RollingPolicy {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.rolling
+}
+
+// SetRollingPolicy allows the updating of the rolling policy, before the Task
+// is registered with the scheduler.
+func (t *Task) SetRollingPolicy(p RollingPolicy) {
+	t.mutex.Lock()
+	t.rolling = p
+	t.mutex.Unlock()
+}
+
+// CanaryPolicy defines how the Task is tried out on the peer agents before
+// it's promoted, when in canary mode.
+func (t *Task) CanaryPolicy() CanaryPolicy {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.canary
+}
+
+// SetCanaryPolicy allows the updating of the canary policy, before the Task is
+// registered with the scheduler.
+func (t *Task) SetCanaryPolicy(p CanaryPolicy) {
+	t.mutex.Lock()
+	t.canary = p
+	t.mutex.Unlock()
+}
+
+// Selector defines which peer agents the Task is sent to, where an empty
+// selector means every peer agent.
+func (t *Task) Selector() peer.Selector {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.selector
+}
+
+// SetSelector allows the updating of the selector, before the Task is
+// registered with the scheduler.
+func (t *Task) SetSelector(s peer.Selector) {
+	t.mutex.Lock()
+	t.selector = s
+	t.mutex.Unlock()
+}
+
+// Key defines the routing key of the Task, which picks the peer agent the Task
+// is sent to, when in hashed mode.
+func (t *Task) Key() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.key
+}
+
+// SetKey allows the updating of the routing key, before the Task is registered
+// with the scheduler.
+func (t *Task) SetKey(k string) {
+	t.mutex.Lock()
+	t.key = k
+	t.mutex.Unlock()
+}
+
+// Balance defines how the peer agent is picked, when in one mode.
+func (t *Task) Balance() peer.BalanceType {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.balance
+}
+
+// SetBalance allows the updating of the balance type, before the Task is
+// registered with the scheduler.
+func (t *Task) SetBalance(b peer.BalanceType) {
+	t.mutex.Lock()
+	t.balance = b
+	t.mutex.Unlock()
+}
+
+// HedgePolicy defines when duplicate requests are sent to other peer agents,
+// when in one or quorum mode.
+func (t *Task) HedgePolicy() HedgePolicy {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.hedge
+}
+
+// SetHedgePolicy allows the updating of the hedge policy, before the Task is
+// registered with the scheduler.
+func (t *Task) SetHedgePolicy(p HedgePolicy) {
+	t.mutex.Lock()
+	t.hedge = p
+	t.mutex.Unlock()
+}
+
+// Created defines when the Task was created.
+func (t *Task) Created() time.Time {
+	return t.created
+}
+
 // Status defines the TaskStatusType of the Task.
 func (t *Task) Status() TaskStatusType {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
 	return t.status
 }
 
 // SetStatus allows the updating of the status.
 func (t *Task) SetStatus(s TaskStatusType) {
+	t.mutex.Lock()
+	t.status = s
+	t.notify()
+	t.mutex.Unlock()
+}
+
+// changeStatus sets the TaskStatusType of the Task, returning false if the
+// Task already had the status.
+func (t *Task) changeStatus(s TaskStatusType) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.status == s {
+		return false
+	}
 	t.status = s
+	t.notify()
+	return true
+}
+
+// transition sets the TaskStatusType of the Task, only if the Task still has
+// the from status, so that a concurrent change isn't overwritten.
+func (t *Task) transition(from, to TaskStatusType) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.status != from || from == to {
+		return false
+	}
+	t.status = to
+	t.notify()
+	return true
+}
+
+// Phase defines which TaskPhaseType the Task is in, which is empty for the
+// modes that don't have any phases.
+func (t *Task) Phase() TaskPhaseType {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.phase
+}
+
+// SetPhase allows the updating of the phase.
+func (t *Task) SetPhase(p TaskPhaseType) {
+	t.mutex.Lock()
+	t.phase = p
+	t.mutex.Unlock()
+}
+
+// Done returns a channel that's closed once the Task reaches a terminal
+// status (completed, cancelled, errored or timedout) for the first time.
+func (t *Task) Done() <-chan struct{} {
+	return t.done
+}
+
+// notify closes the done channel if the status is terminal.
+// It expects the mutex to be held.
+func (t *Task) notify() {
+	if !t.status.Terminal() {
+		return
+	}
+
+	select {
+	case <-t.done:
+	default:
+		close(t.done)
+	}
+}
+
+// Attempts returns a record of every request made to a peer agent, in the
+// order that they completed.
+func (t *Task) Attempts() []Attempt {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	res := make([]Attempt, len(t.attempts))
+	copy(res, t.attempts)
+	return res
 }
 
 // Cancel attempts to cancel any requesting peer agents updates.
@@ -84,7 +362,12 @@ func (t *Task) Cancel() {
 
 // CancelledOrErrored determins if the task can continue or if it failed.
 func (t *Task) CancelledOrErrored() bool {
-	return t.status == TaskStatusTypeCancelled || t.status == TaskStatusTypeErrored
+	switch t.Status() {
+	case TaskStatusTypeCancelled, TaskStatusTypeErrored, TaskStatusTypeTimedOut:
+		return true
+	default:
+		return false
+	}
 }
 
 func (t *Task) addCancelFn(fn context.CancelFunc) {
@@ -93,6 +376,138 @@ func (t *Task) addCancelFn(fn context.CancelFunc) {
 	t.mutex.Unlock()
 }
 
+// restore the status, phase and attempts of the Task from another copy of the
+// same Task, which is used when a compacted log is replayed.
+func (t *Task) restore(other *Task) {
+	status, phase, attempts := other.Status(), other.Phase(), other.Attempts()
+
+	t.mutex.Lock()
+	t.status, t.phase, t.attempts = status, phase, attempts
+	t.notify()
+	t.mutex.Unlock()
+}
+
+func (t *Task) addAttempt(a Attempt) {
+	t.mutex.Lock()
+	t.attempts = append(t.attempts, a)
+	t.mutex.Unlock()
+}
+
+// Attempt records the outcome of sending the Task info to a single peer agent.
+type Attempt struct {
+	Peer       string    `json:"peer"`
+	Number     int       `json:"attempt"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	StatusCode int       `json:"status_code"`
+	Error      string    `json:"error,omitempty"`
+
+	// AgentDuration is the duration reported back by the agent.
+	AgentDuration string `json:"agent_duration,omitempty"`
+
+	// Skipped defines if the request was never sent to the peer agent.
+	Skipped bool `json:"skipped,omitempty"`
+}
+
+// SkippedCircuitOpen is the error of an Attempt that was skipped, because the
+// circuit breaker of the peer agent was open.
+const SkippedCircuitOpen = "skipped: circuit open"
+
+// Success defines if the peer agent accepted the Task info.
+func (a Attempt) Success() bool {
+	return a.Error == ""
+}
+
+// taskRecord is the serialisable form of a Task, which is used by the
+// TaskStores to persist a Task.
+type taskRecord struct {
+	ID          string           `json:"id"`
+	Mode        ModeType         `json:"mode"`
+	ClientID    int              `json:"client_id"`
+	Info        string           `json:"info"`
+	FailOnError bool             `json:"failonerror"`
+	Retry       RetryPolicy      `json:"retry"`
+	Timeout     time.Duration    `json:"timeout,omitempty"`
+	Deadline    time.Duration    `json:"deadline,omitempty"`
+	Quorum      int              `json:"quorum,omitempty"`
+	Rolling     RollingPolicy    `json:"rolling"`
+	Canary      CanaryPolicy     `json:"canary"`
+	Selector    peer.Selector    `json:"selector,omitempty"`
+	Key         string           `json:"key,omitempty"`
+	Balance     peer.BalanceType `json:"balance,omitempty"`
+	Hedge       HedgePolicy      `json:"hedge"`
+	Status      TaskStatusType   `json:"status"`
+	Phase       TaskPhaseType    `json:"phase,omitempty"`
+	Created     time.Time        `json:"created"`
+	Attempts    []Attempt        `json:"attempts,omitempty"`
+}
+
+func (t *Task) record() taskRecord {
+	return taskRecord{
+		ID:          t.id,
+		Mode:        t.mode,
+		ClientID:    t.clientID,
+		Info:        t.info,
+		FailOnError: t.failOnError,
+		Retry:       t.RetryPolicy(),
+		Timeout:     t.RequestTimeout(),
+		Deadline:    t.Deadline(),
+		Quorum:      t.Quorum(),
+		Rolling:     t.RollingPolicy(),
+		Canary:      t.CanaryPolicy(),
+		Selector:    t.Selector(),
+		Key:         t.Key(),
+		Balance:     t.Balance(),
+		Hedge:       t.HedgePolicy(),
+		Status:      t.Status(),
+		Phase:       t.Phase(),
+		Created:     t.created,
+		Attempts:    t.Attempts(),
+	}
+}
+
+func newTaskFromRecord(r taskRecord) *Task {
+	// Tasks persisted without a retry policy are never retried.
+	if r.Retry.MaxAttempts == 0 {
+		r.Retry = DefaultRetryPolicy()
+	}
+	if r.Rolling.BatchSize == 0 {
+		r.Rolling = DefaultRollingPolicy()
+	}
+	if r.Canary.Interval == 0 {
+		r.Canary = DefaultCanaryPolicy()
+	}
+	if r.Balance == "" {
+		r.Balance = peer.BalanceTypeRoundRobin
+	}
+
+	task := &Task{
+		mutex:       sync.Mutex{},
+		id:          r.ID,
+		mode:        r.Mode,
+		clientID:    r.ClientID,
+		info:        r.Info,
+		failOnError: r.FailOnError,
+		retry:       r.Retry,
+		timeout:     r.Timeout,
+		deadline:    r.Deadline,
+		quorum:      r.Quorum,
+		rolling:     r.Rolling,
+		canary:      r.Canary,
+		selector:    r.Selector,
+		key:         r.Key,
+		balance:     r.Balance,
+		hedge:       r.Hedge,
+		status:      r.Status,
+		phase:       r.Phase,
+		created:     r.Created,
+		attempts:    r.Attempts,
+		done:        make(chan struct{}),
+	}
+	task.notify()
+	return task
+}
+
 // TaskStatusType defines the state of the Task as it proceeds through the
 // scheduler.
 // Typically you would expect: pending -> requesting -> completed.
@@ -113,4 +528,56 @@ const (
 
 	// TaskStatusTypeErrored labels the Task once it's errored.
 	TaskStatusTypeErrored TaskStatusType = "errored"
+
+	// TaskStatusTypeTimedOut labels the Task once the deadline has passed.
+	TaskStatusTypeTimedOut TaskStatusType = "timedout"
+)
+
+// Terminal defines if the TaskStatusType can no longer change.
+func (t TaskStatusType) Terminal() bool {
+	switch t {
+	case TaskStatusTypeCompleted, TaskStatusTypeCancelled, TaskStatusTypeErrored, TaskStatusTypeTimedOut:
+		return true
+	default:
+		return false
+	}
+}
+
+// ParseTaskStatusType takes a string and validates it against known
+// TaskStatusTypes
+func ParseTaskStatusType(s string) (TaskStatusType, error) {
+	switch s {
+	case string(TaskStatusTypePending):
+		return TaskStatusTypePending, nil
+	case string(TaskStatusTypeRequesting):
+		return TaskStatusTypeRequesting, nil
+	case string(TaskStatusTypeCompleted):
+		return TaskStatusTypeCompleted, nil
+	case string(TaskStatusTypeCancelled):
+		return TaskStatusTypeCancelled, nil
+	case string(TaskStatusTypeErrored):
+		return TaskStatusTypeErrored, nil
+	case string(TaskStatusTypeTimedOut):
+		return TaskStatusTypeTimedOut, nil
+	default:
+		return TaskStatusType(""), errors.New("invalid task status type")
+	}
+}
+
+// TaskPhaseType defines which phase of the mode the Task is in, as it proceeds
+// through the scheduler.
+// Typically in canary mode you would expect: canary -> soaking -> promoted.
+type TaskPhaseType string
+
+const (
+	// TaskPhaseTypeCanary labels the Task whilst it's sent to the canaries.
+	TaskPhaseTypeCanary TaskPhaseType = "canary"
+
+	// TaskPhaseTypeSoaking labels the Task whilst the health of the canaries is
+	// checked.
+	TaskPhaseTypeSoaking TaskPhaseType = "soaking"
+
+	// TaskPhaseTypePromoted labels the Task once it's sent to the rest of the
+	// peers.
+	TaskPhaseTypePromoted TaskPhaseType = "promoted"
 )
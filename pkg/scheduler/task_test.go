@@ -1,9 +1,12 @@
 package scheduler
 
 import (
+	"reflect"
 	"testing"
 	"testing/quick"
+	"time"
 
+	"github.com/SimonRichardson/cmdproxy/pkg/peer"
 	"github.com/SimonRichardson/cmdproxy/pkg/test"
 )
 
@@ -72,6 +75,21 @@ func TestTaskStatus(t *testing.T) {
 		}
 	})
 
+	t.Run("transition", func(t *testing.T) {
+		task := NewTask(ModeTypeSequential, 1, "info", false)
+		task.SetStatus(TaskStatusTypeRequesting)
+
+		if !task.transition(TaskStatusTypeRequesting, TaskStatusTypeCancelled) {
+			t.Errorf("expected: true, actual: false")
+		}
+		if task.transition(TaskStatusTypeRequesting, TaskStatusTypeCompleted) {
+			t.Errorf("expected: false, actual: true")
+		}
+		if expected, actual := TaskStatusTypeCancelled, task.Status(); expected != actual {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+	})
+
 	t.Run("cancelledOrErrored", func(t *testing.T) {
 		task := NewTask(ModeTypeSequential, 1, "info", false)
 		task.SetStatus(TaskStatusTypePending)
@@ -89,6 +107,11 @@ func TestTaskStatus(t *testing.T) {
 			t.Errorf("expected: true, actual: %v", task.CancelledOrErrored())
 		}
 
+		task.SetStatus(TaskStatusTypeTimedOut)
+		if !task.CancelledOrErrored() {
+			t.Errorf("expected: true, actual: %v", task.CancelledOrErrored())
+		}
+
 		task.SetStatus(TaskStatusTypeRequesting)
 		if task.CancelledOrErrored() {
 			t.Errorf("expected: false, actual: %v", task.CancelledOrErrored())
@@ -96,6 +119,122 @@ func TestTaskStatus(t *testing.T) {
 	})
 }
 
+func TestTaskDone(t *testing.T) {
+	t.Parallel()
+
+	for _, status := range []TaskStatusType{
+		TaskStatusTypeCompleted,
+		TaskStatusTypeCancelled,
+		TaskStatusTypeErrored,
+		TaskStatusTypeTimedOut,
+	} {
+		task := NewTask(ModeTypeSequential, 1, "info", false)
+
+		task.SetStatus(TaskStatusTypeRequesting)
+		select {
+		case <-task.Done():
+			t.Errorf("%s: expected: open, actual: closed", status)
+		default:
+		}
+
+		task.SetStatus(status)
+		task.SetStatus(status)
+		select {
+		case <-task.Done():
+		default:
+			t.Errorf("%s: expected: closed, actual: open", status)
+		}
+	}
+
+	t.Run("record", func(t *testing.T) {
+		task := NewTask(ModeTypeSequential, 1, "info", false)
+		task.SetStatus(TaskStatusTypeCompleted)
+
+		select {
+		case <-newTaskFromRecord(task.record()).Done():
+		default:
+			t.Errorf("expected: closed, actual: open")
+		}
+	})
+}
+
+func TestTaskDeadline(t *testing.T) {
+	t.Parallel()
+
+	task := NewTask(ModeTypeSequential, 1, "info", false)
+	if expected, actual := time.Duration(0), task.Deadline(); expected != actual {
+		t.Errorf("expected: %v, actual: %v", expected, actual)
+	}
+
+	task.SetRequestTimeout(time.Second)
+	task.SetDeadline(time.Minute)
+	task.SetQuorum(2)
+	task.SetRollingPolicy(RollingPolicy{BatchSize: 2, Pause: Duration(time.Second), MaxUnavailable: 1})
+	task.SetCanaryPolicy(CanaryPolicy{Fraction: 0.5, Soak: Duration(time.Minute), Interval: Duration(time.Second)})
+	task.SetPhase(TaskPhaseTypeSoaking)
+	task.SetSelector(peer.Selector{{Key: "zone", Value: "a", Equal: true}})
+	task.SetKey("key")
+	task.SetBalance(peer.BalanceTypePowerOfTwo)
+	task.SetHedgePolicy(HedgePolicy{Delay: Duration(time.Second), Percentile: 95})
+
+	restored := newTaskFromRecord(task.record())
+	if expected, actual := time.Second, restored.RequestTimeout(); expected != actual {
+		t.Errorf("expected: %v, actual: %v", expected, actual)
+	}
+	if expected, actual := time.Minute, restored.Deadline(); expected != actual {
+		t.Errorf("expected: %v, actual: %v", expected, actual)
+	}
+	if expected, actual := 2, restored.Quorum(); expected != actual {
+		t.Errorf("expected: %d, actual: %d", expected, actual)
+	}
+	if expected, actual := task.RollingPolicy(), restored.RollingPolicy(); expected != actual {
+		t.Errorf("expected: %v, actual: %v", expected, actual)
+	}
+	if expected, actual := task.CanaryPolicy(), restored.CanaryPolicy(); expected != actual {
+		t.Errorf("expected: %v, actual: %v", expected, actual)
+	}
+	if expected, actual := TaskPhaseTypeSoaking, restored.Phase(); expected != actual {
+		t.Errorf("expected: %v, actual: %v", expected, actual)
+	}
+	if expected, actual := task.Selector(), restored.Selector(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected: %v, actual: %v", expected, actual)
+	}
+	if expected, actual := "key", restored.Key(); expected != actual {
+		t.Errorf("expected: %s, actual: %s", expected, actual)
+	}
+	if expected, actual := peer.BalanceTypePowerOfTwo, restored.Balance(); expected != actual {
+		t.Errorf("expected: %s, actual: %s", expected, actual)
+	}
+	if expected, actual := task.HedgePolicy(), restored.HedgePolicy(); expected != actual {
+		t.Errorf("expected: %v, actual: %v", expected, actual)
+	}
+}
+
+func TestParseTaskStatusType(t *testing.T) {
+	t.Parallel()
+
+	for _, v := range []TaskStatusType{
+		TaskStatusTypePending,
+		TaskStatusTypeRequesting,
+		TaskStatusTypeCompleted,
+		TaskStatusTypeCancelled,
+		TaskStatusTypeErrored,
+		TaskStatusTypeTimedOut,
+	} {
+		status, err := ParseTaskStatusType(string(v))
+		if err != nil {
+			t.Errorf("expected: nil, actual: %v", err)
+		}
+		if status != v {
+			t.Errorf("expected: %v, actual: %v", v, status)
+		}
+	}
+
+	if _, err := ParseTaskStatusType("bad"); err == nil {
+		t.Errorf("expected: error, actual: %v", err)
+	}
+}
+
 func TestTaskCancel(t *testing.T) {
 	t.Parallel()
 